@@ -0,0 +1,370 @@
+// Package audit keeps tamper-evident trail of account and secret changes made by agent.
+// Trail is JSONL file, each entry holds hash of previous one, so removed or altered entries break the chain.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"marketplace-yaga/linux/internal/plan"
+	"marketplace-yaga/pkg/logger"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultPath is path of audit log.
+const DefaultPath = "/var/log/yandex-guest-agent/audit.log"
+
+// HandleRequest is action recorded by handlers for every processed request, in addition to changes it made.
+const HandleRequest = "handle-request"
+
+// Outcomes of recorded actions.
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+// ErrBrokenChain is returned by Verify if chain of entries is broken.
+var ErrBrokenChain = errors.New("audit log chain is broken")
+
+// Entry is single audit log record.
+type Entry struct {
+	Seq       uint64 `json:"seq"`
+	Time      string `json:"time"`
+	Handler   string `json:"handler,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+	Action    string `json:"action"`
+	Target    string `json:"target"`
+	Detail    string `json:"detail,omitempty"`
+	Outcome   string `json:"outcome"`
+	Error     string `json:"error,omitempty"`
+	// Prev is hash of previous entry, empty for first one.
+	Prev string `json:"prev"`
+	// Hash is sha256 of entry with empty Hash field.
+	Hash string `json:"hash"`
+}
+
+// sum returns hash of entry.
+func (e Entry) sum() (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+
+	return hex.EncodeToString(h[:]), nil
+}
+
+// Head is sequence number and hash of last appended entry. It is kept in separate file next to log,
+// so entries removed from the end of log are detected by VerifyFile.
+type Head struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// HeadPath returns path of head file of log.
+func HeadPath(logPath string) string {
+	return logPath + ".head"
+}
+
+// maxEntrySize limits size of single entry, when log is read.
+const maxEntrySize = 1024 * 1024
+
+// Log appends entries to audit log file. Daemon and one-shot commands could append to the same log at once,
+// so file is locked and its tail is read again for every entry.
+type Log struct {
+	fs   afero.Fs
+	path string
+
+	m sync.Mutex
+}
+
+// NewLog return instance of Log writing to file path.
+func NewLog(fs afero.Fs, path string) *Log {
+	return &Log{fs: fs, path: path}
+}
+
+// Append fills sequence number, time and hashes of entry, appends it to file and publishes new head.
+func (l *Log) Append(e Entry) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if err := l.fs.MkdirAll(path.Dir(l.path), 0700); err != nil {
+		return err
+	}
+	f, err := l.fs.OpenFile(l.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	// closing file releases lock
+	defer func() { _ = f.Close() }()
+
+	if err = lockFile(f); err != nil {
+		return err
+	}
+
+	head, err := readTail(f)
+	if err != nil {
+		return err
+	}
+
+	e.Seq = head.Seq + 1
+	e.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	e.Prev = head.Hash
+
+	if e.Hash, err = e.sum(); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+
+	return l.writeHead(Head{Seq: e.Seq, Hash: e.Hash})
+}
+
+// lockFile takes exclusive lock on log file, which is held till file is closed.
+// Files, which are not backed by OS, e.g. in tests, are guarded by Log mutex only.
+func lockFile(f afero.File) error {
+	osFile, ok := f.(interface{ Fd() uintptr })
+	if !ok {
+		return nil
+	}
+
+	for {
+		err := unix.Flock(int(osFile.Fd()), unix.LOCK_EX)
+		if !errors.Is(err, unix.EINTR) {
+			return err
+		}
+	}
+}
+
+// readTail returns sequence number and hash of last entry of log, reading file backwards from its end.
+func readTail(f afero.File) (Head, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return Head{}, err
+	}
+
+	var buf []byte
+	var last []byte
+	for off := fi.Size(); ; {
+		if len(buf) > maxEntrySize {
+			return Head{}, fmt.Errorf("%w: last entry exceeds %v bytes", ErrBrokenChain, maxEntrySize)
+		}
+
+		n := int64(64 * 1024)
+		if n > off {
+			n = off
+		}
+		off -= n
+		chunk := make([]byte, n)
+		if _, err = f.ReadAt(chunk, off); err != nil && !errors.Is(err, io.EOF) {
+			return Head{}, err
+		}
+		buf = append(chunk, buf...)
+
+		trimmed := bytes.TrimRight(buf, "\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			last = trimmed[i+1:]
+			break
+		}
+		if off == 0 {
+			last = trimmed
+			break
+		}
+	}
+	if len(last) == 0 {
+		return Head{}, nil
+	}
+
+	var e Entry
+	if err = json.Unmarshal(last, &e); err != nil {
+		return Head{}, fmt.Errorf("%w: last entry: %v", ErrBrokenChain, err)
+	}
+
+	return Head{Seq: e.Seq, Hash: e.Hash}, nil
+}
+
+// writeHead atomically replaces head file. It is called with log file locked.
+func (l *Log) writeHead(h Head) error {
+	b, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	headPath := HeadPath(l.path)
+	tmp := headPath + ".tmp"
+	f, err := l.fs.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	return l.fs.Rename(tmp, headPath)
+}
+
+// readHead reads head file of log.
+func readHead(fsys afero.Fs, logPath string) (Head, error) {
+	var h Head
+	b, err := afero.ReadFile(fsys, HeadPath(logPath))
+	if err != nil {
+		return h, err
+	}
+	if err = json.Unmarshal(b, &h); err != nil {
+		return h, fmt.Errorf("%w: head: %v", ErrBrokenChain, err)
+	}
+
+	return h, nil
+}
+
+// Verify reads entries from r and checks hashes and sequence numbers. Returns number of verified entries.
+func Verify(r io.Reader) (int, error) {
+	return verify(r, nil)
+}
+
+// VerifyFile checks chain of log file and that it was not cut short, comparing it with published head.
+// Returns number of verified entries.
+func VerifyFile(fsys afero.Fs, logPath string) (int, error) {
+	f, err := fsys.Open(logPath)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+
+	h, err := readHead(fsys, logPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, fmt.Errorf("%w: head file %v is missing", ErrBrokenChain, HeadPath(logPath))
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return verify(f, &h)
+}
+
+// verify checks chain and, if head is given, that log reaches it. Log could be one entry ahead of head,
+// if agent stopped between appending entry and publishing head.
+func verify(r io.Reader, head *Head) (int, error) {
+	var prev string
+	var seq uint64
+	n := 0
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), maxEntrySize)
+	for line := 1; s.Scan(); line++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+
+		var e Entry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			return n, fmt.Errorf("%w: line %v: %v", ErrBrokenChain, line, err)
+		}
+
+		sum, err := e.sum()
+		if err != nil {
+			return n, err
+		}
+		switch {
+		case sum != e.Hash:
+			return n, fmt.Errorf("%w: line %v: entry hash mismatch", ErrBrokenChain, line)
+		case e.Prev != prev:
+			return n, fmt.Errorf("%w: line %v: previous entry hash mismatch", ErrBrokenChain, line)
+		case e.Seq != seq+1:
+			return n, fmt.Errorf("%w: line %v: sequence %v follows %v", ErrBrokenChain, line, e.Seq, seq)
+		case head != nil && e.Seq == head.Seq && e.Hash != head.Hash:
+			return n, fmt.Errorf("%w: line %v: entry does not match head", ErrBrokenChain, line)
+		}
+
+		prev, seq = e.Hash, e.Seq
+		n++
+	}
+	if err := s.Err(); err != nil {
+		return n, err
+	}
+
+	if head != nil && (seq < head.Seq || seq > head.Seq+1) {
+		return n, fmt.Errorf("%w: log ends at sequence %v, head is at %v", ErrBrokenChain, seq, head.Seq)
+	}
+
+	return n, nil
+}
+
+type key int
+
+const (
+	logKey key = iota
+	requestKey
+)
+
+type request struct {
+	handler string
+	id      string
+}
+
+// NewContext stores audit log in context, changes made with that context are recorded in it.
+func NewContext(ctx context.Context, l *Log) context.Context {
+	return context.WithValue(ctx, logKey, l)
+}
+
+// WithRequest stores handler name and request ID in context, they are added to every recorded entry.
+func WithRequest(ctx context.Context, handler, id string) context.Context {
+	return context.WithValue(ctx, requestKey, request{handler: handler, id: id})
+}
+
+// Record appends entry about action with outcome by err, if context has audit log.
+// Nothing is recorded in plan mode, as nothing is changed. Failure to record is logged only,
+// as change is already made.
+func Record(ctx context.Context, a plan.Action, err error) {
+	l, ok := ctx.Value(logKey).(*Log)
+	if !ok || plan.Enabled(ctx) {
+		return
+	}
+
+	e := Entry{Action: a.Kind, Target: a.Target, Detail: a.Detail, Outcome: Success}
+	if err != nil {
+		e.Outcome, e.Error = Failure, err.Error()
+	}
+	if r, ok := ctx.Value(requestKey).(request); ok {
+		e.Handler, e.RequestID = r.handler, r.id
+	}
+
+	if aErr := l.Append(e); aErr != nil {
+		logger.ErrorCtx(ctx, aErr, "append audit log entry",
+			zap.String("action", a.Kind),
+			zap.String("target", a.Target))
+	}
+}
@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"marketplace-yaga/linux/internal/plan"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPath = "/var/log/yandex-guest-agent/audit.log"
+
+func readLines(t *testing.T, fs afero.Fs) []string {
+	t.Helper()
+
+	c, err := afero.ReadFile(fs, testPath)
+	require.NoError(t, err)
+
+	return strings.Split(strings.TrimSpace(string(c)), "\n")
+}
+
+func TestLog_AppendVerify(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	ctx := WithRequest(NewContext(context.Background(), NewLog(fs, testPath)), "users_handler", "req-1")
+
+	Record(ctx, plan.Action{Kind: plan.CreateUser, Target: "alice"}, nil)
+	Record(ctx, plan.Action{Kind: plan.SetPassword, Target: "alice"}, errors.New("usermod failed"))
+
+	// new log instance, as after agent restart, continues the chain
+	ctx = NewContext(context.Background(), NewLog(fs, testPath))
+	Record(ctx, plan.Action{Kind: plan.WriteFile, Target: "/etc/secret", Detail: "10 bytes"}, nil)
+
+	lines := readLines(t, fs)
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[0], `"handler":"users_handler","requestId":"req-1","action":"create-user","target":"alice"`)
+	assert.Contains(t, lines[1], `"outcome":"failure","error":"usermod failed"`)
+	assert.Contains(t, lines[2], `"seq":3`)
+
+	n, err := Verify(strings.NewReader(strings.Join(lines, "\n")))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+}
+
+func TestVerify_Tampered(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	l := NewLog(fs, testPath)
+	for _, u := range []string{"alice", "bob", "carol"} {
+		require.NoError(t, l.Append(Entry{Action: plan.CreateUser, Target: u, Outcome: Success}))
+	}
+	lines := readLines(t, fs)
+
+	tests := []struct {
+		name  string
+		lines []string
+	}{
+		{name: "altered", lines: []string{lines[0], strings.Replace(lines[1], "bob", "eve", 1), lines[2]}},
+		{name: "removed", lines: []string{lines[0], lines[2]}},
+		{name: "reordered", lines: []string{lines[1], lines[0], lines[2]}},
+		{name: "not json", lines: []string{lines[0], "garbage"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Verify(strings.NewReader(strings.Join(tt.lines, "\n")))
+			assert.ErrorIs(t, err, ErrBrokenChain)
+		})
+	}
+}
+
+func TestRecord_NoLog(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	// plan mode changes nothing, so nothing is recorded
+	ctx := plan.NewContext(NewContext(context.Background(), NewLog(fs, testPath)), plan.NewRecorder())
+	Record(ctx, plan.Action{Kind: plan.CreateUser, Target: "alice"}, nil)
+	Record(context.Background(), plan.Action{Kind: plan.CreateUser, Target: "alice"}, nil)
+
+	_, err := fs.Stat(testPath)
+	assert.Error(t, err)
+
+	n, err := Verify(bytes.NewReader(nil))
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestLog_ConcurrentLogs(t *testing.T) {
+	fs := afero.NewOsFs()
+	logPath := path.Join(t.TempDir(), "audit.log")
+
+	// daemon and one-shot command append to the same log with their own instances
+	var wg sync.WaitGroup
+	for _, l := range []*Log{NewLog(fs, logPath), NewLog(fs, logPath)} {
+		wg.Add(1)
+		go func(l *Log) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				assert.NoError(t, l.Append(Entry{Action: plan.CreateUser, Target: "alice", Outcome: Success}))
+			}
+		}(l)
+	}
+	wg.Wait()
+
+	n, err := VerifyFile(fs, logPath)
+	require.NoError(t, err)
+	assert.Equal(t, 100, n)
+}
+
+func TestVerifyFile_Truncated(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	l := NewLog(fs, testPath)
+	for _, u := range []string{"alice", "bob", "carol"} {
+		require.NoError(t, l.Append(Entry{Action: plan.CreateUser, Target: u, Outcome: Success}))
+	}
+	lines := readLines(t, fs)
+
+	n, err := VerifyFile(fs, testPath)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	// last entry is removed, chain of the rest is still valid
+	require.NoError(t, afero.WriteFile(fs, testPath, []byte(strings.Join(lines[:2], "\n")+"\n"), 0600))
+	_, err = Verify(strings.NewReader(strings.Join(lines[:2], "\n")))
+	require.NoError(t, err)
+	_, err = VerifyFile(fs, testPath)
+	assert.ErrorIs(t, err, ErrBrokenChain)
+
+	require.NoError(t, fs.Remove(HeadPath(testPath)))
+	_, err = VerifyFile(fs, testPath)
+	assert.ErrorIs(t, err, ErrBrokenChain)
+}
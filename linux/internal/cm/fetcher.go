@@ -3,6 +3,7 @@ package cm
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"github.com/spf13/afero"
 	"marketplace-yaga/linux/internal/persistance"
 )
@@ -33,12 +34,21 @@ func newManager(ctx context.Context) *Manager {
 
 type CertificateSpec struct {
 	CertificateId string `json:"certificateId"`
+	// FileAttributes set owner, group and mode of file, root only readable file is written if they are empty.
+	persistance.FileAttributes
 }
 
 type CertificateMetadataMessage = map[string]CertificateSpec
 
 func (m *Manager) HandleCertificates(msg CertificateMetadataMessage) ([]string, error) {
 	var files []string
+	// bad attributes of any file reject whole mapping before anything is written
+	for filepath, spec := range msg {
+		if err := spec.Validate(); err != nil {
+			return nil, fmt.Errorf("%v: %w", filepath, err)
+		}
+	}
+
 	for filepath, spec := range msg {
 		cert, err := m.client.Fetch(spec.CertificateId)
 		if err != nil {
@@ -51,7 +61,7 @@ func (m *Manager) HandleCertificates(msg CertificateMetadataMessage) ([]string,
 		}
 		certContent = append(certContent, []byte(cert.PrivateKey)...)
 
-		err = persistance.WriteFile(m.ctx, m.fs, filepath, bytes.NewReader(certContent))
+		err = persistance.WriteFileWithAttributes(m.ctx, m.fs, filepath, bytes.NewReader(certContent), spec.FileAttributes)
 		if err != nil {
 			return nil, err
 		}
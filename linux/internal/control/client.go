@@ -0,0 +1,80 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"marketplace-yaga/pkg/meta"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Client talks to running agent over its control socket.
+type Client struct {
+	http *http.Client
+}
+
+// clientTimeout must be longer than handler run, as trigger waits for handler to finish.
+const clientTimeout = 2 * time.Minute
+
+// NewClient creates instance of Client for socket at given path.
+func NewClient(socketPath string) *Client {
+	var d net.Dialer
+
+	return &Client{
+		http: &http.Client{
+			Timeout: clientTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// host is ignored by transport, but required to form valid URL.
+const host = "http://yandex-guest-agent"
+
+func (c *Client) Status() (st Status, err error) {
+	err = c.do(http.MethodGet, statusPath, &st)
+
+	return
+}
+
+func (c *Client) Handlers() (hs []meta.HandlerStatus, err error) {
+	err = c.do(http.MethodGet, handlersPath, &hs)
+
+	return
+}
+
+func (c *Client) Trigger(name string) (tr TriggerResponse, err error) {
+	err = c.do(http.MethodPost, handlersPath+"/"+name+triggerPath, &tr)
+
+	return
+}
+
+func (c *Client) do(method, p string, v interface{}) error {
+	req, err := http.NewRequest(method, host+p, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("agent is not reachable over control socket: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		var e errorResponse
+		if err = json.NewDecoder(resp.Body).Decode(&e); err != nil {
+			return fmt.Errorf("received non 200 response, code: %v", resp.StatusCode)
+		}
+
+		return fmt.Errorf("received non 200 response, code: %v, error: %v", resp.StatusCode, e.Error)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
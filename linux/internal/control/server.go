@@ -0,0 +1,241 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"marketplace-yaga/pkg/logger"
+	"marketplace-yaga/pkg/meta"
+	"marketplace-yaga/pkg/serial"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultSocketPath is path of unix socket on which running agent serves its local API.
+const DefaultSocketPath = "/run/yandex-guest-agent/control.sock"
+
+// Status is summary of running agent state.
+type Status struct {
+	Version   string               `json:"version"`
+	StartedAt time.Time            `json:"startedAt"`
+	Uptime    string               `json:"uptime"`
+	Metadata  MetadataHealth       `json:"metadata"`
+	Serial    serial.Health        `json:"serial"`
+	Handlers  []meta.HandlerStatus `json:"handlers"`
+}
+
+// MetadataHealth is aggregated health of metadata polls.
+type MetadataHealth struct {
+	Healthy  bool      `json:"healthy"`
+	LastPoll time.Time `json:"lastPoll,omitempty"`
+	Errors   []string  `json:"errors,omitempty"`
+}
+
+// TriggerResponse is returned on handler trigger.
+type TriggerResponse struct {
+	Handler string      `json:"handler"`
+	Result  meta.Result `json:"result"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Provider is source of agent state, served over socket.
+type Provider interface {
+	Status() Status
+	Handlers() []meta.HandlerStatus
+	Trigger(name string) (meta.Result, error)
+}
+
+type Server struct {
+	ctx      context.Context
+	path     string
+	provider Provider
+	srv      *http.Server
+}
+
+// NewServer creates instance of Server, which will listen on unix socket at given path.
+func NewServer(ctx context.Context, socketPath string, p Provider) *Server {
+	l := logger.FromContext(ctx).With(zap.String("socket", socketPath))
+
+	return &Server{
+		ctx:      logger.NewContext(ctx, l),
+		path:     socketPath,
+		provider: p,
+	}
+}
+
+const (
+	socketDirPerms  os.FileMode = 0700
+	socketFilePerms os.FileMode = 0600
+)
+
+// Start creates socket (accessible to root only) and serves requests in background.
+func (s *Server) Start() error {
+	dir, _ := path.Split(s.path)
+	if err := os.MkdirAll(dir, socketDirPerms); err != nil {
+		logger.ErrorCtx(s.ctx, err, "create socket directory")
+		return err
+	}
+	if err := os.Chmod(dir, socketDirPerms); err != nil {
+		logger.ErrorCtx(s.ctx, err, "restrict socket directory permissions")
+		return err
+	}
+
+	// socket left from previous run will block listen
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.ErrorCtx(s.ctx, err, "remove stale socket")
+		return err
+	}
+
+	ln, err := net.Listen("unix", s.path)
+	if err != nil {
+		logger.ErrorCtx(s.ctx, err, "listen on socket")
+		return err
+	}
+	if err = os.Chmod(s.path, socketFilePerms); err != nil {
+		logger.ErrorCtx(s.ctx, err, "restrict socket permissions")
+		_ = ln.Close()
+		return err
+	}
+
+	s.srv = &http.Server{
+		Handler:           s.mux(),
+		ReadHeaderTimeout: time.Second,
+	}
+
+	go func() {
+		serveErr := s.srv.Serve(&rootOnlyListener{Listener: ln, ctx: s.ctx})
+		if serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+			logger.ErrorCtx(s.ctx, serveErr, "serve control socket")
+		}
+	}()
+	logger.InfoCtx(s.ctx, nil, "control socket started")
+
+	return nil
+}
+
+// Stop closes listener and waits for served requests to finish.
+func (s *Server) Stop(ctx context.Context) error {
+	if s.srv == nil {
+		return nil
+	}
+
+	err := s.srv.Shutdown(ctx)
+	if err != nil {
+		logger.ErrorCtx(s.ctx, err, "shutdown control socket")
+	}
+
+	return err
+}
+
+const (
+	statusPath   = "/v1/status"
+	handlersPath = "/v1/handlers"
+	triggerPath  = "/trigger"
+)
+
+func (s *Server) mux() *http.ServeMux {
+	m := http.NewServeMux()
+	m.HandleFunc(statusPath, s.handleStatus)
+	m.HandleFunc(handlersPath, s.handleHandlers)
+	m.HandleFunc(handlersPath+"/", s.handleTrigger)
+
+	return m
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, s.provider.Status())
+}
+
+func (s *Server) handleHandlers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, s.provider.Handlers())
+}
+
+// handleTrigger serves POST /v1/handlers/<name>/trigger.
+func (s *Server) handleTrigger(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, handlersPath+"/")
+	if !strings.HasSuffix(name, triggerPath) {
+		s.writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	name = strings.TrimSuffix(name, triggerPath)
+
+	logger.InfoCtx(s.ctx, nil, "trigger handler from control socket", zap.String("handler", name))
+	res, err := s.provider.Trigger(name)
+	switch {
+	case errors.Is(err, meta.ErrUnknownHandler):
+		s.writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		s.writeError(w, http.StatusConflict, err)
+	default:
+		s.writeJSON(w, http.StatusOK, TriggerResponse{Handler: name, Result: res})
+	}
+}
+
+func (s *Server) writeError(w http.ResponseWriter, code int, err error) {
+	s.writeJSON(w, code, errorResponse{Error: err.Error()})
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.ErrorCtx(s.ctx, err, "write response to control socket")
+	}
+}
+
+// rootOnlyListener drops connections from peers, which are not root.
+type rootOnlyListener struct {
+	net.Listener
+	ctx context.Context
+}
+
+var ErrNotRoot = errors.New("peer is not root")
+
+func (l *rootOnlyListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		uid, err := peerUID(c)
+		if err == nil && uid != allowedUID {
+			err = ErrNotRoot
+		}
+		if err != nil {
+			logger.ErrorCtx(l.ctx, err, "check control socket peer credentials")
+			_ = c.Close()
+			continue
+		}
+
+		return c, nil
+	}
+}
+
+// allowedUID is uid of peers allowed to use socket, variable for tests.
+var allowedUID = 0
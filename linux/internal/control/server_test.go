@@ -0,0 +1,91 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"marketplace-yaga/pkg/logger"
+	"marketplace-yaga/pkg/meta"
+	"os"
+	"path"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+)
+
+type providerMock struct{}
+
+func (providerMock) Status() Status {
+	return Status{Version: "1.2.3"}
+}
+
+func (providerMock) Handlers() []meta.HandlerStatus {
+	return []meta.HandlerStatus{{Name: "foo", Runs: 1}}
+}
+
+func (providerMock) Trigger(name string) (meta.Result, error) {
+	if name != "foo" {
+		return meta.Result{}, meta.ErrUnknownHandler
+	}
+
+	return meta.Result{Success: true}, nil
+}
+
+func TestServer(t *testing.T) {
+	ctx := logger.NewContext(context.Background(), zaptest.NewLogger(t))
+	allowedUID = os.Getuid()
+	defer func() { allowedUID = 0 }()
+
+	p := path.Join(t.TempDir(), "run", "control.sock")
+	s := NewServer(ctx, p, providerMock{})
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer func() { _ = s.Stop(ctx) }()
+
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Mode().Perm() != socketFilePerms {
+		t.Errorf("socket permissions = %v, want %v", info.Mode().Perm(), socketFilePerms)
+	}
+
+	c := NewClient(p)
+
+	st, err := c.Status()
+	if err != nil || st.Version != "1.2.3" {
+		t.Errorf("Status() got = %+v, error = %v", st, err)
+	}
+
+	hs, err := c.Handlers()
+	if err != nil || len(hs) != 1 || hs[0].Name != "foo" {
+		t.Errorf("Handlers() got = %+v, error = %v", hs, err)
+	}
+
+	tr, err := c.Trigger("foo")
+	if err != nil || !tr.Result.Success {
+		t.Errorf("Trigger() got = %+v, error = %v", tr, err)
+	}
+
+	if _, err = c.Trigger("bar"); err == nil {
+		t.Errorf("Trigger() expected error for unknown handler")
+	}
+}
+
+func TestServer_rejectsNonRoot(t *testing.T) {
+	ctx := logger.NewContext(context.Background(), zaptest.NewLogger(t))
+	allowedUID = os.Getuid() + 1
+	defer func() { allowedUID = 0 }()
+
+	p := path.Join(t.TempDir(), "control.sock")
+	s := NewServer(ctx, p, providerMock{})
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer func() { _ = s.Stop(ctx) }()
+
+	_, err := NewClient(p).Status()
+	if err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Status() expected connection to be dropped, error = %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"marketplace-yaga/linux/internal/executor/command"
+	"marketplace-yaga/linux/internal/plan"
 	"marketplace-yaga/pkg/logger"
 	"os/exec"
 	"strings"
@@ -51,6 +52,13 @@ func (e *Executor) run(command *command.Command) (string, string, error) {
 	lgr := logger.FromContext(e.ctx).With(
 		zap.String("id", id),
 		zap.String("command", command.String()))
+
+	if plan.Record(e.ctx, plan.Action{Kind: plan.Exec, Target: command.String()}) {
+		lgr.Info("command planned, not executed")
+
+		return "", "", nil
+	}
+
 	lgr.Info("execute")
 
 	var stdout, stderr strings.Builder
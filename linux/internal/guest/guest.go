@@ -3,14 +3,14 @@ package guest
 import (
 	"context"
 	"errors"
-	"marketplace-yaga/linux/internal/handlers/kmssecrets"
-	"marketplace-yaga/linux/internal/handlers/lockboxsecrets"
-	"marketplace-yaga/linux/internal/handlers/managedcertificates"
-	"marketplace-yaga/linux/internal/handlers/sshkeys"
-	"marketplace-yaga/linux/internal/handlers/users"
+	"marketplace-yaga/linux/internal/control"
+	"marketplace-yaga/linux/internal/hostkeys"
+	"marketplace-yaga/linux/internal/systemd"
 	"marketplace-yaga/pkg/heartbeat"
 	"marketplace-yaga/pkg/logger"
+	"marketplace-yaga/pkg/messages"
 	"marketplace-yaga/pkg/meta"
+	"marketplace-yaga/pkg/serial"
 	"os"
 	"os/signal"
 	"syscall"
@@ -24,6 +24,16 @@ type Server struct {
 	cancel    context.CancelFunc
 	asService bool
 	lastErr   error
+
+	version       string
+	controlSocket string
+	startedAt     time.Time
+	watcher       *meta.MetadataWatcher
+	control       *control.Server
+	stopped       chan struct{}
+
+	// hostKeysGuestAttributes enables publishing host keys fingerprints to guest attributes.
+	hostKeysGuestAttributes bool
 }
 
 var ErrUndefCtx = errors.New("expected context.Context")
@@ -34,7 +44,10 @@ func NewServer(ctx context.Context) (*Server, error) {
 		return nil, ErrUndefCtx
 	}
 
-	s := Server{}
+	s := Server{
+		controlSocket: control.DefaultSocketPath,
+		stopped:       make(chan struct{}),
+	}
 
 	l := logger.FromContext(ctx).With(zap.String("server", "linux"))
 	s.ctx, s.cancel = context.WithCancel(logger.NewContext(ctx, l))
@@ -42,9 +55,31 @@ func NewServer(ctx context.Context) (*Server, error) {
 	return &s, nil
 }
 
+// WithVersion sets agent version reported over control socket.
+func (s *Server) WithVersion(v string) *Server {
+	s.version = v
+
+	return s
+}
+
+// WithControlSocket sets path of control socket, empty path disables it.
+func (s *Server) WithControlSocket(p string) *Server {
+	s.controlSocket = p
+
+	return s
+}
+
+// WithHostKeysGuestAttributes enables publishing host keys fingerprints to guest attributes besides serial port.
+func (s *Server) WithHostKeysGuestAttributes(enabled bool) *Server {
+	s.hostKeysGuestAttributes = enabled
+
+	return s
+}
+
 // start initializes and starts agent.
 func (s *Server) start() error {
 	logger.InfoCtx(s.ctx, nil, "start agent")
+	s.startedAt = time.Now()
 
 	err := startHeartbeat(s.ctx)
 	if err != nil {
@@ -52,12 +87,72 @@ func (s *Server) start() error {
 		return err
 	}
 
+	// host keys are reported for console convenience, so agent keeps working without them
+	logger.DebugCtx(s.ctx, nil, "start host keys publisher")
+	if err = hostkeys.NewPublisher(s.ctx).WithGuestAttributes(s.hostKeysGuestAttributes).Start(); err != nil {
+		logger.ErrorCtx(s.ctx, err, "start host keys publisher")
+	}
+
 	logger.DebugCtx(s.ctx, nil, "start metadata watcher")
-	startUserChangeMetadataWatcher(s.ctx)
+	s.watcher = startUserChangeMetadataWatcher(s.ctx)
+
+	// control socket is auxiliary, so agent keeps working without it
+	if s.controlSocket != "" {
+		logger.DebugCtx(s.ctx, nil, "start control socket")
+		s.control = control.NewServer(s.ctx, s.controlSocket, s)
+		if err = s.control.Start(); err != nil {
+			logger.ErrorCtx(s.ctx, err, "start control socket")
+			s.control = nil
+		}
+	}
 
 	return nil
 }
 
+// Status implements control.Provider, returns summary of agent state.
+func (s *Server) Status() control.Status {
+	hs := s.Handlers()
+
+	st := control.Status{
+		Version:   s.version,
+		StartedAt: s.startedAt,
+		Uptime:    time.Since(s.startedAt).Round(time.Second).String(),
+		Serial:    serial.Status(),
+		Handlers:  hs,
+	}
+
+	st.Metadata.Healthy = true
+	for _, h := range hs {
+		if h.LastPoll.After(st.Metadata.LastPoll) {
+			st.Metadata.LastPoll = h.LastPoll
+		}
+		if h.LastPollError != "" {
+			st.Metadata.Healthy = false
+			st.Metadata.Errors = append(st.Metadata.Errors, h.Name+": "+h.LastPollError)
+		}
+	}
+
+	return st
+}
+
+// Handlers implements control.Provider, returns state of every metadata watch.
+func (s *Server) Handlers() []meta.HandlerStatus {
+	if s.watcher == nil {
+		return nil
+	}
+
+	return s.watcher.Status()
+}
+
+// Trigger implements control.Provider, re-runs handler with last received metadata.
+func (s *Server) Trigger(name string) (meta.Result, error) {
+	if s.watcher == nil {
+		return meta.Result{}, meta.ErrUnknownHandler
+	}
+
+	return s.watcher.Trigger(name)
+}
+
 type starter interface {
 	Start() error
 }
@@ -83,41 +178,74 @@ func startHeartbeat(ctx context.Context) error {
 }
 
 // startUserChangeMetadataWatcher starts poller for user change request messages.
-func startUserChangeMetadataWatcher(ctx context.Context) {
+func startUserChangeMetadataWatcher(ctx context.Context) *meta.MetadataWatcher {
 	logger.DebugCtx(ctx, nil, "create metadata watcher")
 	w := meta.NewMetadataWatcher(ctx)
 
 	logger.DebugCtx(ctx, nil, "add metadata watcher")
-	w.AddWatch(sshkeys.DefaultMetadataURL, sshkeys.NewUserHandler())
-	w.AddWatch(kmssecrets.DefaultMetadataURL, kmssecrets.NewKmsHandler())
-	w.AddWatch(lockboxsecrets.DefaultMetadataURL, lockboxsecrets.NewLockboxHandler())
-	w.AddWatch(managedcertificates.DefaultMetadataURL, managedcertificates.CertificatesHandler())
-	w.AddWatch(users.DefaultMetadataURL, users.NewUserHandle())
+	for _, wt := range newWatches() {
+		w.AddWatch(wt.url, wt.handler)
+	}
+
+	return w
 }
 
 var ErrStopTimeout = errors.New("timeout stopping service")
 
 const stopTimeout = 10 * time.Second
 
-// stop closes context and waits stopTimeout.
+// ShutdownMessageType is type of message written to serial port on agent stop.
+const ShutdownMessageType = "AgentShutdown"
+
+// shutdownReport tells console if agent stopped gracefully, or some handlers were interrupted.
+type shutdownReport struct {
+	Graceful bool
+	// Interrupted lists handlers, which operations may be left partially done.
+	Interrupted []string
+}
+
+// serialPort is interface for read or write to serial port.
+var serialPort = serial.NewBlockingWriter()
+
+// stop stops watching metadata, waits stopTimeout for handlers in flight to finish and flushes serial port.
 func (s *Server) stop() (err error) {
+	s.notify(systemd.Stopping)
+
+	if s.control != nil {
+		stopCtx, stopCancel := context.WithTimeout(context.Background(), stopTimeout)
+		_ = s.control.Stop(stopCtx)
+		stopCancel()
+	}
+
+	// no new metadata events accepted after that point
 	logger.DebugCtx(s.ctx, nil, "cancel context")
 	s.cancel()
 
-	select {
-	case <-s.ctx.Done():
-		logger.DebugCtx(s.ctx, nil, "context closed")
-	case <-time.After(stopTimeout):
+	var interrupted []string
+	if s.watcher != nil {
+		logger.DebugCtx(s.ctx, nil, "wait for handlers in flight", zap.Strings("handlers", s.watcher.InFlight()))
+		interrupted = s.watcher.Drain(stopTimeout)
+	}
+	if len(interrupted) > 0 {
 		err = ErrStopTimeout
-		logger.ErrorCtx(s.ctx, err, "gave up waiting for context close")
+		logger.ErrorCtx(s.ctx, err, "gave up waiting for handlers, their operations may be partially done",
+			zap.Strings("handlers", interrupted))
+	}
+
+	r := shutdownReport{Graceful: err == nil, Interrupted: interrupted}
+	if wErr := serialPort.WriteJSON(messages.NewEnvelope().WithType(ShutdownMessageType).Wrap(r)); wErr != nil {
+		logger.ErrorCtx(s.ctx, wErr, "write shutdown report to serial port")
 	}
 
+	logger.DebugCtx(s.ctx, nil, "flush serial port")
+	serial.Flush()
+
 	return
 }
 
 func (s *Server) wait() {
-	logger.DebugCtx(s.ctx, nil, "wait context to close")
-	<-s.ctx.Done()
+	logger.DebugCtx(s.ctx, nil, "wait server to stop")
+	<-s.stopped
 }
 
 // Run start agent and handles OS or Service Manger's signals/events.
@@ -132,22 +260,20 @@ func (s *Server) Run() error {
 	c := make(chan os.Signal, 1)
 	subscribeOsSignals(c)
 
-	var sigErr error
 	go func() {
 		<-c
 		logger.InfoCtx(s.ctx, nil, "received SIGTERM or SIGINT")
-		sigErr = s.stop()
-	}()
-	defer func() {
-		if err == nil {
-			err = sigErr
-		}
+		s.lastErr = s.stop()
+		close(s.stopped)
 	}()
 
+	s.notify(systemd.Ready, systemd.Status(s.statusLine()))
+	s.startWatchdog()
+
 	logger.DebugCtx(s.ctx, nil, "started from console")
 	s.wait()
 
-	return nil
+	return s.lastErr
 }
 
 // subscribeOsSignals is a global wrapped function for mocking in tests.
@@ -0,0 +1,166 @@
+package guest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"marketplace-yaga/linux/internal/handlers/kmssecrets"
+	"marketplace-yaga/linux/internal/handlers/lockboxsecrets"
+	"marketplace-yaga/linux/internal/handlers/managedcertificates"
+	"marketplace-yaga/linux/internal/handlers/sshca"
+	"marketplace-yaga/linux/internal/handlers/sshkeys"
+	"marketplace-yaga/linux/internal/handlers/users"
+	"marketplace-yaga/linux/internal/plan"
+	"marketplace-yaga/pkg/logger"
+	"marketplace-yaga/pkg/meta"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// watch binds metadata attribute to handler processing it.
+type watch struct {
+	// name is short handler name, used in command line.
+	name    string
+	url     string
+	handler meta.MetadataChangeHandler
+	// serialOnly handler sends its response, e.g. encrypted password, only through serial port,
+	// so running it without serial port would lose response.
+	serialOnly bool
+}
+
+// newWatches creates handlers for every metadata attribute agent is interested in.
+func newWatches() []watch {
+	return []watch{
+		{name: "sshkeys", url: sshkeys.DefaultMetadataURL, handler: sshkeys.NewUserHandler()},
+		{name: "sshusers", url: sshkeys.UsersMetadataURL, handler: sshkeys.NewSettingsHandler()},
+		{name: "sshca", url: sshca.DefaultMetadataURL, handler: sshca.NewCAHandler()},
+		{name: "kms", url: kmssecrets.DefaultMetadataURL, handler: kmssecrets.NewKmsHandler()},
+		{name: "lockbox", url: lockboxsecrets.DefaultMetadataURL, handler: lockboxsecrets.NewLockboxHandler()},
+		{name: "certificates", url: managedcertificates.DefaultMetadataURL, handler: managedcertificates.CertificatesHandler()},
+		{name: "users", url: users.DefaultMetadataURL, handler: users.NewUserHandle(), serialOnly: true},
+	}
+}
+
+// HandlerNames returns short names of all handlers.
+func HandlerNames() []string {
+	var names []string
+	for _, w := range newWatches() {
+		names = append(names, w.name)
+	}
+
+	return names
+}
+
+var ErrUnknownHandler = errors.New("unknown handler")
+
+// selectWatches filters watches by short or full handler names, empty names select all.
+func selectWatches(names []string) ([]watch, error) {
+	all := newWatches()
+	if len(names) == 0 {
+		return all, nil
+	}
+
+	var selected []watch
+	for _, n := range names {
+		n = strings.TrimSpace(n)
+		found := false
+		for _, w := range all {
+			if w.name == n || w.handler.String() == n {
+				selected = append(selected, w)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %v, expected one of: %v", ErrUnknownHandler, n, strings.Join(HandlerNames(), ","))
+		}
+	}
+
+	return selected, nil
+}
+
+// HandlerResult is outcome of single handler one-shot run.
+type HandlerResult struct {
+	Handler string        `json:"handler"`
+	URL     string        `json:"url"`
+	Result  meta.Result   `json:"result"`
+	Actions []plan.Action `json:"actions,omitempty"`
+}
+
+// Failed tells if handler run should be considered failed.
+func (r HandlerResult) Failed() bool {
+	return !r.Result.Skipped && !r.Result.Success
+}
+
+var ErrSerialRequired = errors.New("handler responds only through serial port")
+
+// ApplyOnce fetches current metadata once and runs selected handlers synchronously, one after another.
+// Missing metadata attribute is not an error, handler is reported as skipped.
+// Without serial port handlers, which respond only through it, are reported as skipped,
+// and selecting them explicitly is an error.
+func ApplyOnce(ctx context.Context, names []string, withSerial bool) ([]HandlerResult, error) {
+	ws, err := selectWatches(names)
+	if err != nil {
+		return nil, err
+	}
+	if !withSerial && len(names) > 0 {
+		for _, w := range ws {
+			if w.serialOnly {
+				return nil, fmt.Errorf("%w: %v", ErrSerialRequired, w.name)
+			}
+		}
+	}
+
+	return runOnce(ctx, ws, false, withSerial)
+}
+
+// PlanOnce is same as ApplyOnce, but handlers only record actions they would perform.
+func PlanOnce(ctx context.Context, names []string) ([]HandlerResult, error) {
+	ws, err := selectWatches(names)
+	if err != nil {
+		return nil, err
+	}
+
+	return runOnce(ctx, ws, true, false)
+}
+
+func runOnce(ctx context.Context, ws []watch, dryRun, withSerial bool) ([]HandlerResult, error) {
+	var err error
+	var results []HandlerResult
+	for _, w := range ws {
+		hr := HandlerResult{Handler: w.name, URL: w.url}
+
+		// otherwise request would be processed and marked as done, while its response is lost
+		if w.serialOnly && !dryRun && !withSerial {
+			hr.Result = meta.Result{Skipped: true, Error: ErrSerialRequired.Error()}
+			results = append(results, hr)
+			continue
+		}
+
+		handleCtx := ctx
+		var rec *plan.Recorder
+		if dryRun {
+			rec = plan.NewRecorder()
+			handleCtx = plan.NewContext(ctx, rec)
+		}
+
+		logger.DebugCtx(ctx, nil, "apply metadata once",
+			zap.String("handler", w.name),
+			zap.Bool("dryRun", dryRun))
+		hr.Result, err = meta.HandleOnce(handleCtx, w.url, w.handler)
+		switch {
+		case errors.Is(err, meta.ErrNotFound):
+			hr.Result = meta.Result{Skipped: true, Error: err.Error()}
+		case err != nil:
+			hr.Result = meta.Result{Error: err.Error()}
+		}
+		if rec != nil {
+			hr.Actions = rec.Actions()
+		}
+
+		results = append(results, hr)
+	}
+
+	return results, nil
+}
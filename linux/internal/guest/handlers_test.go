@@ -0,0 +1,45 @@
+package guest
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func Test_selectWatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   []string
+		want    []string
+		wantErr error
+	}{
+		{name: "all", names: nil, want: HandlerNames()},
+		{name: "short names", names: []string{"sshkeys", " kms"}, want: []string{"sshkeys", "kms"}},
+		{name: "full name", names: []string{"users_handler"}, want: []string{"users"}},
+		{name: "unknown", names: []string{"foo"}, wantErr: ErrUnknownHandler},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectWatches(tt.names)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("selectWatches() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("selectWatches() got = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].name != tt.want[i] {
+					t.Errorf("selectWatches() got = %v, want %v", got[i].name, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestApplyOnce_SerialRequired(t *testing.T) {
+	_, err := ApplyOnce(context.Background(), []string{"users"}, false)
+	if !errors.Is(err, ErrSerialRequired) {
+		t.Errorf("ApplyOnce() error = %v, want %v", err, ErrSerialRequired)
+	}
+}
@@ -0,0 +1,84 @@
+package guest
+
+import (
+	"fmt"
+	"marketplace-yaga/linux/internal/systemd"
+	"marketplace-yaga/pkg/logger"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// progressTimeout is time after which metadata watch without progress considered stalled.
+// Must be longer than metadata long poll, its retries and handlers run altogether.
+const progressTimeout = 5 * time.Minute
+
+// notify sends states to systemd, if agent started as notify service.
+func (s *Server) notify(states ...string) {
+	sent, err := systemd.Notify(states...)
+	if err != nil {
+		logger.ErrorCtx(s.ctx, err, "notify systemd", zap.Strings("states", states))
+		return
+	}
+	if sent {
+		logger.DebugCtx(s.ctx, nil, "notified systemd", zap.Strings("states", states))
+	}
+}
+
+// startWatchdog starts to ping systemd watchdog while metadata watches make progress.
+func (s *Server) startWatchdog() {
+	interval, err := systemd.WatchdogInterval()
+	if err != nil {
+		logger.ErrorCtx(s.ctx, err, "get systemd watchdog interval")
+		return
+	}
+	if interval == 0 {
+		logger.DebugCtx(s.ctx, nil, "systemd watchdog disabled")
+		return
+	}
+
+	// ping twice per interval, as recommended by sd_watchdog_enabled(3)
+	go s.watchdog(interval / 2)
+}
+
+func (s *Server) watchdog(every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-s.ctx.Done():
+			return
+		}
+
+		// wedged agent must be restarted, so no ping
+		stalled := s.watcher.Stalled(progressTimeout)
+		if len(stalled) > 0 {
+			logger.ErrorCtx(s.ctx, nil, "metadata watches stalled, skip watchdog ping",
+				zap.Strings("handlers", stalled))
+			s.notify(systemd.Status("metadata watches stalled: " + strings.Join(stalled, ",")))
+			continue
+		}
+
+		s.notify(systemd.Watchdog, systemd.Status(s.statusLine()))
+	}
+}
+
+// statusLine is short human-readable agent state.
+func (s *Server) statusLine() string {
+	st := s.Status()
+
+	var runs uint64
+	for _, h := range st.Handlers {
+		runs += h.Runs
+	}
+
+	health := "healthy"
+	if !st.Metadata.Healthy {
+		health = fmt.Sprintf("%d polls failing", len(st.Metadata.Errors))
+	}
+
+	return fmt.Sprintf("watching %d metadata attributes, %d handler runs, metadata %s", len(st.Handlers), runs, health)
+}
@@ -2,6 +2,7 @@ package kmssecrets
 
 import (
 	"marketplace-yaga/linux/internal/kms"
+	"marketplace-yaga/linux/internal/persistance"
 	"reflect"
 	"testing"
 )
@@ -29,6 +30,20 @@ func Test_parse(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "file attributes",
+			args: args{
+				data: []byte(`{"/etc/nginx/ssl/key.pem": {"keyId":"abj12345678901234567","ciphertext":"AAAA","owner":"root","group":"nginx","mode":"0640"}}`),
+			},
+			want: map[string]kms.Secret{
+				"/etc/nginx/ssl/key.pem": {
+					KeyId:          "abj12345678901234567",
+					Ciphertext:     "AAAA",
+					FileAttributes: persistance.FileAttributes{Owner: "root", Group: "nginx", Mode: "0640"},
+				},
+			},
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
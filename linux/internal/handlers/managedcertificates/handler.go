@@ -4,12 +4,16 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"go.uber.org/zap"
+	"marketplace-yaga/linux/internal/audit"
 	"marketplace-yaga/linux/internal/cm"
+	"marketplace-yaga/linux/internal/plan"
 	"marketplace-yaga/pkg/logger"
 	"marketplace-yaga/pkg/messages"
+	"marketplace-yaga/pkg/meta"
 	"marketplace-yaga/pkg/serial"
 	"runtime"
 	"runtime/debug"
@@ -48,19 +52,28 @@ func (h *ManagedCertificatesHandler) Handle(ctx context.Context, data []byte) {
 		return
 	}
 	dataSha := sha256.Sum256(data)
-	if bytes.Compare(dataSha[:], lastProcessedSha) == 0 {
+	if !meta.IsForced(ctx) && bytes.Compare(dataSha[:], lastProcessedSha) == 0 {
 		return
 	}
+	// metadata has no request ID, so hash of data identifies it in audit log
+	ctx = audit.WithRequest(ctx, handlerName, hex.EncodeToString(dataSha[:8]))
 
 	var resp response
 	resp, err = process(ctx, data)
 	if err != nil {
 		logger.ErrorCtx(ctx, err, "processed request")
 	}
+	meta.SetResult(ctx, resp, err)
+	audit.Record(ctx, plan.Action{Kind: audit.HandleRequest, Target: handlerName}, err)
 
 	runtime.GC()
 	debug.FreeOSMemory()
 
+	// nothing was changed in plan mode, so there is nothing to report
+	if plan.Enabled(ctx) {
+		return
+	}
+
 	// unwrap to get envelope
 	var e = messages.NewEnvelope()
 	e.WithTimestamp(time.Now()).WithType(ManagedCertificatesResponseType)
@@ -0,0 +1,444 @@
+package sshca
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"marketplace-yaga/linux/internal/audit"
+	"marketplace-yaga/linux/internal/executor"
+	"marketplace-yaga/linux/internal/executor/argument"
+	"marketplace-yaga/linux/internal/executor/command"
+	"marketplace-yaga/linux/internal/plan"
+	"marketplace-yaga/linux/internal/usermanager"
+	"marketplace-yaga/pkg/logger"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+// Files managed by handler. Principals files are read by sshd with user privileges,
+// so they and their directories are world readable and owned by root, as sshd StrictModes require.
+const (
+	caDir          = "/etc/ssh/yandex-guest-agent"
+	caKeysFile     = caDir + "/trusted_user_ca_keys"
+	principalsDir  = caDir + "/principals"
+	sshdConfigFile = "/etc/ssh/sshd_config"
+	sshdConfigDir  = "/etc/ssh/sshd_config.d"
+	dropInFile     = sshdConfigDir + "/60-yandex-guest-agent-ca.conf"
+)
+
+// commandsTimeout limits sshd validation and reload.
+const commandsTimeout = 30 * time.Second
+
+var (
+	ErrBadCAKey         = errors.New("invalid CA public key")
+	ErrBadPrincipal     = errors.New("invalid principal")
+	ErrNoDropInSupport  = errors.New(sshdConfigFile + " does not include " + sshdConfigDir)
+	ErrDropInInvalid    = errors.New("sshd rejected CA drop-in")
+	ErrSshdReloadFailed = errors.New("failed to reload sshd")
+)
+
+// principalSeparators could not be used in principals.
+const principalSeparators = " \t\r\n,"
+
+// request is content of ssh-user-ca metadata, e.g.
+// {"trustedUserCaKeys": ["ssh-ed25519 AAAA... ca@example.com"], "principals": {"alice": ["alice", "admins"]}}.
+type request struct {
+	TrustedUserCAKeys []string            `json:"trustedUserCaKeys"`
+	Principals        map[string][]string `json:"principals"`
+}
+
+func parse(data []byte) (request, error) {
+	var req request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return req, err
+	}
+
+	return req, nil
+}
+
+// caKey describes trusted CA key in response.
+type caKey struct {
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+	Comment     string `json:"comment,omitempty"`
+}
+
+// parseCAKeys validates CA keys and returns their description and normalized authorized_keys lines.
+// Certificates and keys with options are not accepted as CA keys.
+func parseCAKeys(lines []string) ([]caKey, []string, error) {
+	var keys []caKey
+	var out []string
+	for _, l := range lines {
+		pk, comment, options, rest, err := ssh.ParseAuthorizedKey([]byte(l))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrBadCAKey, err)
+		}
+		if len(options) > 0 || len(bytes.TrimSpace(rest)) > 0 {
+			return nil, nil, fmt.Errorf("%w: CA key must be single key without options", ErrBadCAKey)
+		}
+		if _, isCert := pk.(*ssh.Certificate); isCert {
+			return nil, nil, fmt.Errorf("%w: certificate could not be CA key", ErrBadCAKey)
+		}
+
+		keys = append(keys, caKey{Type: pk.Type(), Fingerprint: ssh.FingerprintSHA256(pk), Comment: comment})
+		line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pk)))
+		if comment != "" {
+			line += " " + comment
+		}
+		out = append(out, line)
+	}
+
+	return keys, out, nil
+}
+
+// validatePrincipals checks usernames and principals, which must not contain separators of principals file.
+func validatePrincipals(m *usermanager.Manager, principals map[string][]string) error {
+	for u, ps := range principals {
+		if err := m.ValidateUsername(u); err != nil {
+			return err
+		}
+		for _, p := range ps {
+			if p == "" || strings.ContainsAny(p, principalSeparators) || strings.HasPrefix(p, "#") {
+				return fmt.Errorf("%w %q of user %v", ErrBadPrincipal, p, u)
+			}
+		}
+	}
+
+	return nil
+}
+
+// sshd applies CA configuration and reloads sshd.
+type sshd struct {
+	ctx      context.Context
+	fs       afero.Fs
+	executor executor.ExecutorService
+}
+
+func newSshd(ctx context.Context) *sshd {
+	return &sshd{
+		ctx:      ctx,
+		fs:       afero.NewOsFs(),
+		executor: executor.NewBuilder(ctx).WithTimeout(commandsTimeout).Build(),
+	}
+}
+
+// apply writes CA keys, principals files and drop-in, and tells if anything changed.
+// Empty CA keys list disables CA authentication by removing drop-in, CA keys and principals files.
+// If any file could not be written or sshd rejects new configuration, all files are restored,
+// so sshd never runs with part of new configuration.
+func (s *sshd) apply(caKeys []string, principals map[string][]string) (changed bool, err error) {
+	if len(caKeys) == 0 {
+		return s.disable()
+	}
+
+	if err = s.checkDropInSupport(); err != nil {
+		return false, err
+	}
+
+	snap, err := s.snapshot(principals)
+	if err != nil {
+		return false, err
+	}
+
+	changed, err = s.install(caKeys, principals)
+	if err == nil || !changed || plan.Enabled(s.ctx) {
+		return changed, err
+	}
+
+	if rErr := s.restore(snap); rErr != nil {
+		logger.ErrorCtx(s.ctx, rErr, "restored previous sshd CA configuration")
+		return true, fmt.Errorf("%w, restoring previous configuration failed: %v", err, rErr)
+	}
+
+	return false, err
+}
+
+// install writes CA keys, principals files and drop-in, drop-in is written last and validated.
+func (s *sshd) install(caKeys []string, principals map[string][]string) (changed bool, err error) {
+	c, err := s.writeFile(caKeysFile, strings.Join(caKeys, "\n")+"\n")
+	changed = changed || c
+	if err != nil {
+		return changed, err
+	}
+
+	c, err = s.syncPrincipals(principals)
+	changed = changed || c
+	if err != nil {
+		return changed, err
+	}
+
+	content := "# managed by yandex-guest-agent\n" +
+		"TrustedUserCAKeys " + caKeysFile + "\n" +
+		"AuthorizedPrincipalsFile " + principalsDir + "/%u\n"
+	c, err = s.writeDropIn(content)
+
+	return changed || c, err
+}
+
+// snapshot keeps content of files, which apply could change, nil content means there was no file.
+type snapshot map[string][]byte
+
+// snapshot reads drop-in, CA keys, existing principals files and principals files of users, which are to be written.
+func (s *sshd) snapshot(principals map[string][]string) (snapshot, error) {
+	files := []string{dropInFile, caKeysFile}
+	for u := range principals {
+		files = append(files, path.Join(principalsDir, u))
+	}
+	infos, err := afero.ReadDir(s.fs, principalsDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, fi := range infos {
+		if !fi.IsDir() {
+			files = append(files, path.Join(principalsDir, fi.Name()))
+		}
+	}
+
+	snap := make(snapshot, len(files))
+	for _, f := range files {
+		content, err := afero.ReadFile(s.fs, f)
+		if errors.Is(err, fs.ErrNotExist) {
+			snap[f] = nil
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		snap[f] = content
+	}
+
+	return snap, nil
+}
+
+// restore puts files back as they were in snapshot. Drop-in is restored first and removed last,
+// so it never refers to configuration, which is not restored yet.
+func (s *sshd) restore(snap snapshot) error {
+	files := make([]string, 0, len(snap))
+	for f := range snap {
+		if f != dropInFile {
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	if snap[dropInFile] != nil {
+		files = append([]string{dropInFile}, files...)
+	} else {
+		files = append(files, dropInFile)
+	}
+
+	for _, f := range files {
+		old := snap[f]
+		if old != nil {
+			if err := s.put(f, string(old)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.fs.Remove(f); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// disable removes drop-in first, so sshd never refers to missing files, and then files it referred to.
+func (s *sshd) disable() (changed bool, err error) {
+	for _, f := range []string{dropInFile, caKeysFile} {
+		c, err := s.remove(f)
+		if err != nil {
+			return changed, err
+		}
+		changed = changed || c
+	}
+
+	c, err := s.syncPrincipals(nil)
+
+	return changed || c, err
+}
+
+// syncPrincipals writes principals file of each user and removes files of users, which are not listed anymore.
+func (s *sshd) syncPrincipals(principals map[string][]string) (changed bool, err error) {
+	for u, ps := range principals {
+		c, err := s.writeFile(path.Join(principalsDir, u), strings.Join(ps, "\n")+"\n")
+		if err != nil {
+			return changed, err
+		}
+		changed = changed || c
+	}
+
+	infos, err := afero.ReadDir(s.fs, principalsDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return changed, err
+	}
+	for _, fi := range infos {
+		if _, ok := principals[fi.Name()]; ok || fi.IsDir() {
+			continue
+		}
+		c, err := s.remove(path.Join(principalsDir, fi.Name()))
+		if err != nil {
+			return changed, err
+		}
+		changed = changed || c
+	}
+
+	return changed, nil
+}
+
+// checkDropInSupport makes sure main sshd config includes drop-in directory, otherwise drop-in is ignored.
+func (s *sshd) checkDropInSupport() error {
+	content, err := afero.ReadFile(s.fs, sshdConfigFile)
+	if err != nil {
+		return err
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(content))
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) >= 2 && strings.EqualFold(f[0], "Include") && strings.HasPrefix(f[1], sshdConfigDir) {
+			return nil
+		}
+	}
+
+	return ErrNoDropInSupport
+}
+
+// writeFile writes root owned, world readable file, if its content differs.
+func (s *sshd) writeFile(file, content string) (changed bool, err error) {
+	old, err := afero.ReadFile(s.fs, file)
+	if err == nil && string(old) == content {
+		return false, nil
+	}
+
+	a := plan.Action{Kind: plan.WriteFile, Target: file, Detail: fmt.Sprintf("%d bytes", len(content))}
+	if plan.Record(s.ctx, a) {
+		return true, nil
+	}
+	defer func() { audit.Record(s.ctx, a, err) }()
+
+	return true, s.put(file, content)
+}
+
+// writeDropIn writes sshd drop-in. Drop-in could conflict with main config or other drop-ins, so whole sshd
+// configuration is validated once it is in place. Broken file could never prevent sshd from starting,
+// as apply restores previous configuration, if sshd rejects it.
+func (s *sshd) writeDropIn(content string) (changed bool, err error) {
+	old, err := afero.ReadFile(s.fs, dropInFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	if err == nil && string(old) == content {
+		return false, nil
+	}
+
+	a := plan.Action{Kind: plan.WriteFile, Target: dropInFile, Detail: content}
+	if plan.Record(s.ctx, a) {
+		return true, nil
+	}
+	defer func() { audit.Record(s.ctx, a, err) }()
+
+	if err = s.put(dropInFile, content); err != nil {
+		return false, err
+	}
+
+	return true, s.validate()
+}
+
+// put writes content into temporary file, which is renamed to file.
+func (s *sshd) put(file, content string) error {
+	dir := path.Dir(file)
+	if err := s.fs.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// dot in name hides temporary file from Include globs
+	tmp := path.Join(dir, "."+path.Base(file)+".tmp")
+	if err := afero.WriteFile(s.fs, tmp, []byte(content), 0644); err != nil {
+		return err
+	}
+	if err := s.fs.Chmod(tmp, 0644); err != nil {
+		_ = s.fs.Remove(tmp)
+		return err
+	}
+
+	if err := s.fs.Rename(tmp, file); err != nil {
+		_ = s.fs.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// validate checks whole sshd configuration with sshd test mode.
+func (s *sshd) validate() error {
+	if err := s.run(argument.New("sshd"), argument.New("-t")); err != nil {
+		return fmt.Errorf("%w: %v", ErrDropInInvalid, err)
+	}
+
+	return nil
+}
+
+// remove removes file, if it exists.
+func (s *sshd) remove(file string) (changed bool, err error) {
+	_, err = s.fs.Stat(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	a := plan.Action{Kind: plan.RemoveFile, Target: file}
+	if plan.Record(s.ctx, a) {
+		return true, nil
+	}
+
+	err = s.fs.Remove(file)
+	audit.Record(s.ctx, a, err)
+
+	return true, err
+}
+
+// reload makes sshd re-read configuration. Service is named sshd on most distros and ssh on Debian derivatives.
+func (s *sshd) reload() error {
+	var err error
+	for _, unit := range []string{"sshd", "ssh"} {
+		if err = s.run(argument.New("systemctl"), argument.New("reload"), argument.New(unit)); err == nil {
+			return nil
+		}
+		logger.DebugCtx(s.ctx, err, "reload sshd",
+			zap.String("unit", unit))
+	}
+
+	return fmt.Errorf("%w: %v", ErrSshdReloadFailed, err)
+}
+
+func (s *sshd) run(args ...argument.Argument) error {
+	cmd, err := command.New(args...)
+	if err != nil {
+		return fmt.Errorf("failed construct command: %w", err)
+	}
+
+	return s.executor.Run(cmd)
+}
+
+// usernames returns sorted users with principals.
+func usernames(principals map[string][]string) []string {
+	var users []string
+	for u := range principals {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+
+	return users
+}
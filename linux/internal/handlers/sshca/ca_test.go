@@ -0,0 +1,193 @@
+package sshca
+
+import (
+	"context"
+	"errors"
+	"marketplace-yaga/linux/internal/executor/command"
+	"marketplace-yaga/linux/internal/usermanager"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCAKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIB92T8dhrVrEPl4aPclr1VesSvaYDaZajzqmASek3jPi ca@example.com"
+
+const validateConfig = "sshd -t"
+
+type fakeExecutor struct {
+	commands []string
+	// failOn fails commands starting with it
+	failOn string
+}
+
+func (e *fakeExecutor) Run(c *command.Command) error {
+	e.commands = append(e.commands, c.String())
+	if e.failOn != "" && strings.HasPrefix(c.String(), e.failOn) {
+		return errors.New("exit status 1")
+	}
+
+	return nil
+}
+
+func newTestSshd(files map[string]string) (*sshd, *fakeExecutor) {
+	fs := afero.NewMemMapFs()
+	for n, c := range files {
+		_ = afero.WriteFile(fs, n, []byte(c), 0644)
+	}
+	e := &fakeExecutor{}
+
+	return &sshd{ctx: context.Background(), fs: fs, executor: e}, e
+}
+
+func readFile(t *testing.T, s *sshd, file string) string {
+	t.Helper()
+
+	b, err := afero.ReadFile(s.fs, file)
+	require.NoError(t, err)
+
+	return string(b)
+}
+
+func TestParseCAKeys(t *testing.T) {
+	keys, lines, err := parseCAKeys([]string{testCAKey})
+	require.NoError(t, err)
+	assert.Equal(t, []caKey{{
+		Type:        "ssh-ed25519",
+		Fingerprint: "SHA256:OS/YZ3OvHak5XHhLFXqryudL50ag/IUjQt8n0xaFNVI",
+		Comment:     "ca@example.com",
+	}}, keys)
+	assert.Equal(t, []string{testCAKey}, lines)
+
+	for _, bad := range []string{
+		"garbage",
+		`cert-authority,principals="alice" ` + testCAKey,
+		testCAKey + "\n" + testCAKey,
+	} {
+		_, _, err = parseCAKeys([]string{bad})
+		assert.ErrorIs(t, err, ErrBadCAKey, bad)
+	}
+}
+
+func TestValidatePrincipals(t *testing.T) {
+	m := usermanager.New(context.Background())
+
+	assert.NoError(t, validatePrincipals(m, map[string][]string{"alice": {"alice", "admins"}}))
+	assert.Error(t, validatePrincipals(m, map[string][]string{"../etc": {"alice"}}))
+	assert.ErrorIs(t, validatePrincipals(m, map[string][]string{"alice": {"alice,admins"}}), ErrBadPrincipal)
+	assert.ErrorIs(t, validatePrincipals(m, map[string][]string{"alice": {""}}), ErrBadPrincipal)
+}
+
+func TestSshd_Apply(t *testing.T) {
+	s, e := newTestSshd(map[string]string{
+		sshdConfigFile:                 "Include /etc/ssh/sshd_config.d/*.conf\n",
+		principalsDir + "/bob":         "bob\n",
+		principalsDir + "/alice.stale": "alice\n",
+	})
+
+	changed, err := s.apply([]string{testCAKey}, map[string][]string{"alice": {"alice", "admins"}})
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []string{validateConfig}, e.commands)
+	assert.Equal(t, testCAKey+"\n", readFile(t, s, caKeysFile))
+	assert.Equal(t, "alice\nadmins\n", readFile(t, s, principalsDir+"/alice"))
+	assert.Contains(t, readFile(t, s, dropInFile), "TrustedUserCAKeys "+caKeysFile+"\n")
+	assert.Contains(t, readFile(t, s, dropInFile), "AuthorizedPrincipalsFile "+principalsDir+"/%u\n")
+	for _, f := range []string{principalsDir + "/bob", principalsDir + "/alice.stale"} {
+		_, err = s.fs.Stat(f)
+		assert.Error(t, err, f)
+	}
+
+	// same configuration is not written and validated again
+	e.commands = nil
+	changed, err = s.apply([]string{testCAKey}, map[string][]string{"alice": {"alice", "admins"}})
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Empty(t, e.commands)
+
+	// no CA keys disables CA authentication, files drop-in referred to are removed too
+	changed, err = s.apply(nil, nil)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	for _, f := range []string{dropInFile, caKeysFile, principalsDir + "/alice"} {
+		_, err = s.fs.Stat(f)
+		assert.Error(t, err, f)
+	}
+}
+
+func TestSshd_Apply_Rejected(t *testing.T) {
+	s, _ := newTestSshd(map[string]string{sshdConfigFile: "PermitRootLogin no\n"})
+
+	_, err := s.apply([]string{testCAKey}, nil)
+	assert.ErrorIs(t, err, ErrNoDropInSupport)
+
+	s, e := newTestSshd(map[string]string{sshdConfigFile: "Include /etc/ssh/sshd_config.d/*.conf\n"})
+	e.failOn = validateConfig
+
+	changed, err := s.apply([]string{testCAKey}, map[string][]string{"alice": {"alice"}})
+	assert.ErrorIs(t, err, ErrDropInInvalid)
+	assert.False(t, changed)
+	infos, err := afero.ReadDir(s.fs, sshdConfigDir)
+	require.NoError(t, err)
+	assert.Empty(t, infos)
+	for _, f := range []string{caKeysFile, principalsDir + "/alice"} {
+		_, err = s.fs.Stat(f)
+		assert.Error(t, err, f)
+	}
+
+	// previous drop-in, CA keys and principals are restored
+	const previous = "# managed by yandex-guest-agent\nTrustedUserCAKeys /etc/ssh/ca.pub\n"
+	s, e = newTestSshd(map[string]string{
+		sshdConfigFile:         "Include /etc/ssh/sshd_config.d/*.conf\n",
+		dropInFile:             previous,
+		caKeysFile:             "ssh-ed25519 OLD\n",
+		principalsDir + "/bob": "bob\n",
+	})
+	e.failOn = validateConfig
+
+	_, err = s.apply([]string{testCAKey}, map[string][]string{"alice": {"alice"}})
+	assert.ErrorIs(t, err, ErrDropInInvalid)
+	assert.Equal(t, previous, readFile(t, s, dropInFile))
+	assert.Equal(t, "ssh-ed25519 OLD\n", readFile(t, s, caKeysFile))
+	assert.Equal(t, "bob\n", readFile(t, s, principalsDir+"/bob"))
+	_, err = s.fs.Stat(principalsDir + "/alice")
+	assert.Error(t, err)
+	infos, err = afero.ReadDir(s.fs, sshdConfigDir)
+	require.NoError(t, err)
+	assert.Len(t, infos, 1)
+}
+
+func TestSshd_Reload(t *testing.T) {
+	s, e := newTestSshd(nil)
+	e.failOn = "systemctl reload sshd"
+
+	require.NoError(t, s.reload())
+	assert.Equal(t, []string{"systemctl reload sshd", "systemctl reload ssh"}, e.commands)
+}
+
+func TestProcess_ReloadRetried(t *testing.T) {
+	t.Cleanup(func() { reloadPending = false })
+	ctx := context.Background()
+	data := []byte(`{"trustedUserCaKeys": ["` + testCAKey + `"]}`)
+	s, e := newTestSshd(map[string]string{sshdConfigFile: "Include /etc/ssh/sshd_config.d/*.conf\n"})
+	e.failOn = "systemctl reload"
+
+	_, err := process(ctx, s, data)
+	assert.ErrorIs(t, err, ErrSshdReloadFailed)
+
+	// files are unchanged now, but sshd still runs with previous configuration
+	e.failOn = ""
+	e.commands = nil
+	res, err := process(ctx, s, data)
+	require.NoError(t, err)
+	assert.True(t, res.Reloaded)
+	assert.Equal(t, []string{"systemctl reload sshd"}, e.commands)
+
+	e.commands = nil
+	res, err = process(ctx, s, data)
+	require.NoError(t, err)
+	assert.False(t, res.Reloaded)
+	assert.Empty(t, e.commands)
+}
@@ -0,0 +1,146 @@
+package sshca
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go.uber.org/zap"
+	"marketplace-yaga/linux/internal/audit"
+	"marketplace-yaga/linux/internal/plan"
+	"marketplace-yaga/linux/internal/usermanager"
+	"marketplace-yaga/pkg/logger"
+	"marketplace-yaga/pkg/messages"
+	"marketplace-yaga/pkg/meta"
+	"marketplace-yaga/pkg/serial"
+	"time"
+)
+
+// handlerName contain name of that handler.
+const handlerName = "ssh_user_ca_handler"
+
+// DefaultMetadataURL contain URL which polled for trusted user CA keys and principals.
+const DefaultMetadataURL = "http://169.254.169.254/computeMetadata/v1/instance/attributes/ssh-user-ca"
+
+// serialPort is interface for read or write to serial port.
+var serialPort = serial.NewBlockingWriter()
+
+// CAHandler is struct, that implements needed methods for MetadataChangeHandler interface.
+type CAHandler struct{}
+
+// NewCAHandler return instance of CAHandler.
+func NewCAHandler() *CAHandler {
+	return &CAHandler{}
+}
+
+// String returns name of handler.
+func (h *CAHandler) String() string {
+	return handlerName
+}
+
+var lastProcessedSha []byte
+
+// reloadPending is set, when files were changed, but sshd was not reloaded, so reload is retried on next run,
+// even if files are not changed anymore.
+var reloadPending bool
+
+// Handle passes trusted user CA keys and principals to 'process' function and writes result to serial port.
+func (h *CAHandler) Handle(ctx context.Context, data []byte) {
+	err := ctx.Err()
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "checked deadline or context cancellation")
+		return
+	}
+	dataSha := sha256.Sum256(data)
+	if !meta.IsForced(ctx) && bytes.Equal(dataSha[:], lastProcessedSha) {
+		return
+	}
+	// metadata has no request ID, so hash of data identifies it in audit log
+	ctx = audit.WithRequest(ctx, handlerName, hex.EncodeToString(dataSha[:8]))
+
+	var resp response
+	resp, err = process(ctx, newSshd(ctx), data)
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "processed request")
+	}
+	meta.SetResult(ctx, resp, err)
+	audit.Record(ctx, plan.Action{Kind: audit.HandleRequest, Target: handlerName}, err)
+
+	// nothing was changed in plan mode, so there is nothing to report
+	if plan.Enabled(ctx) {
+		return
+	}
+
+	var e = messages.NewEnvelope()
+	e.WithTimestamp(time.Now()).WithType(SshUserCAResponseType)
+
+	// failed request is processed again, even if metadata does not change
+	processErr := err
+	err = serialPort.WriteJSON(e.Wrap(resp))
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "writing to serial port",
+			zap.String("response", fmt.Sprint(resp)),
+			zap.String("envelope", fmt.Sprint(e)))
+		return
+	}
+	if processErr == nil {
+		lastProcessedSha = dataSha[:]
+	}
+}
+
+// process validates CA keys and principals, installs them and reloads sshd if anything changed.
+//
+//nolint:nakedret
+func process(ctx context.Context, s *sshd, data []byte) (res response, err error) {
+	defer func() {
+		if err != nil {
+			res.withError(err)
+		}
+	}()
+
+	err = ctx.Err()
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "checked deadline or context cancellation")
+		return
+	}
+
+	req, err := parse(data)
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "parsing ssh user CA from metadata")
+		return
+	}
+
+	keys, lines, err := parseCAKeys(req.TrustedUserCAKeys)
+	if err != nil {
+		return
+	}
+	res.withCAKeys(keys)
+
+	if err = validatePrincipals(usermanager.New(ctx), req.Principals); err != nil {
+		return
+	}
+	res.withPrincipals(usernames(req.Principals))
+
+	changed, err := s.apply(lines, req.Principals)
+	if changed && !plan.Enabled(ctx) {
+		reloadPending = true
+	}
+	if err != nil {
+		return
+	}
+
+	if changed || reloadPending {
+		if err = s.reload(); err != nil {
+			return
+		}
+		if !plan.Enabled(ctx) {
+			reloadPending = false
+		}
+		res.Reloaded = true
+	}
+
+	res.withSuccess()
+
+	return
+}
@@ -0,0 +1,40 @@
+package sshca
+
+const SshUserCAResponseType = "SshUserCA"
+
+// response is struct which converted to json and passed to COM port as result of ssh CA handling.
+type response struct {
+	CAKeys     []caKey  `json:"caKeys"`
+	Principals []string `json:"principals"`
+	Reloaded   bool     `json:"reloaded"`
+	Success    bool     `json:"success"`
+	Error      string   `json:"error"`
+}
+
+// withCAKeys add trusted CA keys to resulting response.
+func (res *response) withCAKeys(keys []caKey) *response {
+	res.CAKeys = keys
+
+	return res
+}
+
+// withPrincipals add users with principals to resulting response.
+func (res *response) withPrincipals(users []string) *response {
+	res.Principals = users
+
+	return res
+}
+
+// withSuccess changes Success field of resulting response to true.
+func (res *response) withSuccess() *response {
+	res.Success = true
+
+	return res
+}
+
+// withError add error string to resulting response.
+func (res *response) withError(e error) *response {
+	res.Error = e.Error()
+
+	return res
+}
@@ -0,0 +1,166 @@
+package sshkeys
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/spf13/afero"
+	"marketplace-yaga/linux/internal/persistance"
+	"marketplace-yaga/linux/internal/plan"
+)
+
+// DefaultKeysCacheFile holds keys of last processed ssh-keys metadata, which authorized-keys command serves to sshd.
+// Command runs as unprivileged user, so cache is world readable. It holds only public keys with their options
+// and expiration time, agent state next to it stays root only.
+const DefaultKeysCacheFile = "/opt/yandex-guest-agent/ssh-keys.json"
+
+// keysCacheAttributes make cache readable and its directory traversable, but not listable, by anyone.
+var keysCacheAttributes = persistance.FileAttributes{Mode: "0644", DirMode: "0711"}
+
+// Mode tells how keys are passed to sshd.
+type Mode string
+
+const (
+	// ModeFiles writes keys into managed block of users authorized_keys files.
+	ModeFiles Mode = "files"
+	// ModeCommand leaves authorized_keys files alone, sshd gets keys with AuthorizedKeysCommand.
+	ModeCommand Mode = "command"
+)
+
+// Modes lists known modes.
+var Modes = []Mode{ModeFiles, ModeCommand}
+
+var ErrUnknownMode = errors.New("unknown ssh keys mode")
+
+// ParseMode returns mode by its name.
+func ParseMode(s string) (Mode, error) {
+	for _, m := range Modes {
+		if string(m) == s {
+			return m, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %v", ErrUnknownMode, s)
+}
+
+type modeKey struct{}
+
+// NewModeContext stores ssh keys mode in context.
+func NewModeContext(ctx context.Context, m Mode) context.Context {
+	return context.WithValue(ctx, modeKey{}, m)
+}
+
+// modeFromContext returns mode stored in context, files mode by default.
+func modeFromContext(ctx context.Context) Mode {
+	if m, ok := ctx.Value(modeKey{}).(Mode); ok {
+		return m
+	}
+
+	return ModeFiles
+}
+
+// keysCacheFile and keysCacheFs are overridden in tests.
+var (
+	keysCacheFile = DefaultKeysCacheFile
+	keysCacheFs   = afero.NewOsFs()
+)
+
+// cachedKey is authorized_keys line with its expiration time.
+type cachedKey struct {
+	Key      string     `json:"key"`
+	ExpireOn *time.Time `json:"expireOn,omitempty"`
+}
+
+// keysCache maps usernames to their keys.
+type keysCache map[string][]cachedKey
+
+func (c keysCache) add(uk userKeys) {
+	for _, k := range uk.keys {
+		ck := cachedKey{Key: k.AuthorizedKey()}
+		if !k.ExpireOn.IsZero() {
+			t := k.ExpireOn
+			ck.ExpireOn = &t
+		}
+		c[uk.name] = append(c[uk.name], ck)
+	}
+}
+
+// users returns cached usernames, sorted.
+func (c keysCache) users() []string {
+	users := make([]string, 0, len(c))
+	for u := range c {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+
+	return users
+}
+
+func writeKeysCache(ctx context.Context, fsys afero.Fs, file string, c keysCache) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	err = persistance.WriteFileWithAttributes(ctx, fsys, file, bytes.NewReader(b), keysCacheAttributes)
+	if err != nil || plan.Enabled(ctx) {
+		return err
+	}
+
+	return makeTraversable(fsys, path.Dir(file))
+}
+
+// makeTraversable lets anyone pass through dir, which existed before cache, e.g. created root only by other writes.
+func makeTraversable(fsys afero.Fs, dir string) error {
+	fi, err := fsys.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Perm()&0011 == 0011 {
+		return nil
+	}
+
+	return fsys.Chmod(dir, fi.Mode().Perm()|0011)
+}
+
+// readKeysCache reads keys cache. Missing cache means agent has not processed metadata yet, so there are no keys.
+func readKeysCache(fsys afero.Fs, file string) (keysCache, error) {
+	c := make(keysCache)
+	b, err := afero.ReadFile(fsys, file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse ssh keys cache %v: %w", file, err)
+	}
+
+	return c, nil
+}
+
+// AuthorizedKeys returns keys of user from cache, which have not expired by now.
+func AuthorizedKeys(fsys afero.Fs, file, username string, now time.Time) ([]string, error) {
+	c, err := readKeysCache(fsys, file)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, k := range c[username] {
+		if k.ExpireOn != nil && !now.Before(*k.ExpireOn) {
+			continue
+		}
+		keys = append(keys, k.Key)
+	}
+
+	return keys, nil
+}
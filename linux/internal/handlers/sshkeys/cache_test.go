@@ -0,0 +1,67 @@
+package sshkeys
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorizedKeys(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	fs := afero.NewMemMapFs()
+
+	keys, err := AuthorizedKeys(fs, DefaultKeysCacheFile, "alice", now)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	c := make(keysCache)
+	c.add(userKeys{name: "alice", keys: []sshKey{
+		{User: "alice", Type: "ssh-ed25519", Data: testKeyData, Comment: "forever"},
+		{User: "alice", Type: "ssh-ed25519", Data: testKeyData, Comment: "soon", ExpireOn: now.Add(time.Hour)},
+	}})
+	c.add(userKeys{name: "bob", keys: []sshKey{{User: "bob", Type: "ssh-ed25519", Data: testKeyData}}})
+	require.NoError(t, writeKeysCache(context.Background(), fs, DefaultKeysCacheFile, c))
+
+	keys, err = AuthorizedKeys(fs, DefaultKeysCacheFile, "alice", now)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ssh-ed25519 " + testKeyData + " forever", "ssh-ed25519 " + testKeyData + " soon"}, keys)
+
+	keys, err = AuthorizedKeys(fs, DefaultKeysCacheFile, "alice", now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ssh-ed25519 " + testKeyData + " forever"}, keys)
+
+	keys, err = AuthorizedKeys(fs, DefaultKeysCacheFile, "carol", now)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestWriteKeysCache_Readable(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, fs.MkdirAll("/opt/yandex-guest-agent", 0700))
+
+	require.NoError(t, writeKeysCache(context.Background(), fs, DefaultKeysCacheFile, keysCache{}))
+
+	// authorized-keys command runs as unprivileged user
+	fi, err := fs.Stat(DefaultKeysCacheFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), fi.Mode())
+	fi, err = fs.Stat("/opt/yandex-guest-agent")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0711), fi.Mode().Perm())
+}
+
+func TestParseMode(t *testing.T) {
+	m, err := ParseMode("command")
+	require.NoError(t, err)
+	assert.Equal(t, ModeCommand, m)
+	assert.Equal(t, ModeCommand, modeFromContext(NewModeContext(context.Background(), m)))
+	assert.Equal(t, ModeFiles, modeFromContext(context.Background()))
+
+	_, err = ParseMode("ldap")
+	assert.ErrorIs(t, err, ErrUnknownMode)
+}
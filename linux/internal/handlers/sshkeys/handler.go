@@ -4,17 +4,19 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"go.uber.org/zap"
+	"marketplace-yaga/linux/internal/audit"
+	"marketplace-yaga/linux/internal/plan"
 	"marketplace-yaga/linux/internal/usermanager"
 	"marketplace-yaga/pkg/logger"
 	"marketplace-yaga/pkg/messages"
+	"marketplace-yaga/pkg/meta"
 	"marketplace-yaga/pkg/serial"
-	"os/user"
 	"runtime"
 	"runtime/debug"
-	"strings"
 	"time"
 )
 
@@ -45,6 +47,9 @@ func (h *UserHandler) String() string {
 
 var lastProcessedSha []byte
 
+// keysExpireOn is earliest expiration time of keys from last processed metadata.
+var keysExpireOn time.Time
+
 // Handle passes 'User change or creation' request to 'processRequest' function and writes result to serial port.
 func (h *UserHandler) Handle(ctx context.Context, data []byte) {
 	err := ctx.Err()
@@ -53,20 +58,31 @@ func (h *UserHandler) Handle(ctx context.Context, data []byte) {
 		return
 	}
 	dataSha := sha256.Sum256(data)
-	if bytes.Compare(dataSha[:], lastProcessedSha) == 0 {
+	// unchanged metadata is processed again once some of its keys expire
+	expired := !keysExpireOn.IsZero() && !time.Now().Before(keysExpireOn)
+	if !meta.IsForced(ctx) && !expired && bytes.Compare(dataSha[:], lastProcessedSha) == 0 {
 		return
 	}
+	// metadata has no request ID, so hash of data identifies it in audit log
+	ctx = audit.WithRequest(ctx, handlerName, hex.EncodeToString(dataSha[:8]))
 
 	var resp response
 	resp, err = processRequest(ctx, data)
 	if err != nil {
 		logger.ErrorCtx(ctx, err, "processed request")
 	}
+	meta.SetResult(ctx, resp, err)
+	audit.Record(ctx, plan.Action{Kind: audit.HandleRequest, Target: handlerName}, err)
 	// wont spam to serial port on equal requests
 
 	runtime.GC()
 	debug.FreeOSMemory()
 
+	// nothing was changed in plan mode, so there is nothing to report
+	if plan.Enabled(ctx) {
+		return
+	}
+
 	// unwrap to get envelope
 	var e = messages.NewEnvelope()
 	e.WithTimestamp(time.Now()).WithType(UserUpdateSshKeysResponseType)
@@ -97,62 +113,186 @@ func processRequest(ctx context.Context, data []byte) (res response, err error)
 		return
 	}
 
-	parsedUsers, err := parseSshKeys(data)
+	keys, lineErrs := parseSshKeys(data, time.Now())
+	for _, e := range lineErrs {
+		logger.ErrorCtx(ctx, e.err, "parsing ssh-keys line, skipped",
+			zap.Int("line", e.Line),
+			zap.String("username", e.User))
+	}
+	res.withLineErrors(lineErrs)
+
+	// users of rejected and expired keys are still in metadata, so their accounts are not treated as vanished
+	mentioned := make(map[string]bool)
+	for _, k := range keys {
+		mentioned[k.User] = true
+	}
+	for _, e := range lineErrs {
+		mentioned[e.User] = true
+	}
+
+	keys, rejected := keyPolicyFromContext(ctx).filterKeys(keys)
+	for _, r := range rejected {
+		logger.InfoCtx(ctx, nil, "ssh key rejected",
+			zap.String("username", r.User),
+			zap.String("fingerprint", r.Fingerprint),
+			zap.String("reason", r.Error))
+	}
+	res.withRejected(rejected)
+	keysExpireOn = nextExpiry(keys)
+
+	mngr := usermanager.New(ctx)
+
+	previous, err := readKeysCache(keysCacheFs, keysCacheFile)
+	if err != nil {
+		return
+	}
+	created, err := readAccounts(keysCacheFs, accountsFile)
 	if err != nil {
-		logger.ErrorCtx(ctx, err, "parsing users from metadata")
 		return
 	}
-	mngr := usermanager.New(ctx)
 
-	for _, u := range parsedUsers {
-		err = mngr.ValidateUsername(u.Name)
-		if err != nil {
-			return
+	var parsedUsers []usermanager.User
+	users := groupByUser(keys)
+	for _, uk := range users {
+		for _, k := range uk.authorizedKeys() {
+			parsedUsers = append(parsedUsers, usermanager.User{Name: uk.name, SshKey: k})
 		}
-		err = mngr.ValidateUser(u.Name)
-		if err != nil {
-			return
+	}
+	res.withUsers(parsedUsers)
+
+	cache, revoked, userErrs := provisionUsers(ctx, mngr, users, created, previous)
+	res.withRevoked(revoked)
+	res.UserErrors = userErrs
+	if len(userErrs) > 0 {
+		err = ErrUsersPartial
+	}
+
+	// cache holds only users, which passed validation, so sshd is never given keys of denied users
+	cacheErr := writeKeysCache(ctx, keysCacheFs, keysCacheFile, cache)
+	if cacheErr != nil {
+		logger.ErrorCtx(ctx, cacheErr, "written ssh keys cache")
+		if err == nil {
+			err = cacheErr
 		}
-		_, err = mngr.Exist(u.Name)
-		if err != nil {
-			err = mngr.CreateUser(u.Name)
-			if err != nil {
-				return
-			}
+	}
 
+	// users, which failed, keep their previous cache entries, so they never look vanished
+	var deleted []string
+	var vanishedErr error
+	res.Accounts, deleted, vanishedErr = reconcileVanished(ctx, mngr, vanishedPolicyFromContext(ctx), created,
+		previous.users(), cache, mentioned)
+	if forgetErr := forgetSettings(ctx, keysCacheFs, deleted); forgetErr != nil && vanishedErr == nil {
+		vanishedErr = forgetErr
+	}
+	if vanishedErr != nil && err == nil {
+		err = vanishedErr
+	}
+	if accountsErr := writeAccounts(ctx, keysCacheFs, accountsFile, created); accountsErr != nil {
+		logger.ErrorCtx(ctx, accountsErr, "written created accounts")
+		if err == nil {
+			err = accountsErr
 		}
-		sysUser, _ := user.Lookup(u.Name)
+	}
 
-		err = mngr.AddSshKey(sysUser, u.SshKey)
-		if err != nil {
-			return
+	// groups, sudo and shell declared in ssh-users metadata are applied to provisioned users
+	declared, settingsErr := readUsersSettings(keysCacheFs, settingsFile)
+	if settingsErr == nil {
+		res.Settings, settingsErr = reconcileUsers(ctx, keysCacheFs, mngr, declared, cache.users())
+	}
+	if settingsErr != nil {
+		logger.ErrorCtx(ctx, settingsErr, "applied ssh-users settings")
+		if err == nil {
+			err = settingsErr
 		}
 	}
+	if err != nil {
+		return
+	}
 
-	res.withSuccess().withUsers(parsedUsers)
+	res.withSuccess()
 
 	return
 }
 
-func parseSshKeys(data []byte) ([]usermanager.User, error) {
-	var users []usermanager.User
-	userKeys := string(data)
-	for _, line := range strings.Split(userKeys, "\n") {
-		line := strings.Trim(line, " ")
-		if len(line) == 0 {
+var ErrUsersPartial = errors.New("some ssh-keys users were not provisioned")
+
+// provisioner creates and validates accounts of ssh-keys users.
+type provisioner interface {
+	accountManager
+	ValidateUsername(username string) error
+	ValidateUser(username string) error
+	Exist(username string) (bool, error)
+	CreateUser(username string) error
+}
+
+// provisionUsers creates accounts of users and syncs their managed keys. Failure of one user does not stop others.
+// Returns new keys cache, revoked keys and errors of failed users.
+// Denied users are left out of cache, so their keys are cleared as of vanished users. Users, which failed otherwise,
+// keep their previous cache entries, so sshd still gets their keys and accounts are not treated as vanished.
+func provisionUsers(ctx context.Context, m provisioner, users []userKeys, created accounts,
+	previous keysCache) (keysCache, []usermanager.User, []userError) {
+	cache := make(keysCache)
+	var revoked []usermanager.User
+	var errs []userError
+	fail := func(username string, err error) {
+		logger.ErrorCtx(ctx, err, "provisioned ssh-keys user",
+			zap.String("username", username))
+		errs = append(errs, userError{User: username, Error: err.Error()})
+	}
+
+	for _, uk := range users {
+		if err := validateUser(m, uk.name); err != nil {
+			fail(uk.name, err)
 			continue
 		}
-		parts := strings.Split(line, ":")
-		if len(parts) != 2 {
-			return nil, ErrWrongSshKeyFormat
+
+		removed, err := provisionUser(ctx, m, uk, created)
+		for _, k := range removed {
+			revoked = append(revoked, usermanager.User{Name: uk.name, SshKey: k})
+		}
+		if err != nil {
+			fail(uk.name, err)
+			if keys, ok := previous[uk.name]; ok {
+				cache[uk.name] = keys
+			}
+			continue
 		}
-		name := parts[0]
-		if name == "" {
-			return nil, ErrEmptyUserName
+		cache.add(uk)
+	}
+
+	return cache, revoked, errs
+}
+
+// validateUser checks that account of user could be managed by agent.
+func validateUser(m provisioner, username string) error {
+	if err := m.ValidateUsername(username); err != nil {
+		return err
+	}
+
+	return m.ValidateUser(username)
+}
+
+// provisionUser creates account of user, if there is none, and syncs its managed keys. Returns revoked keys.
+func provisionUser(ctx context.Context, m provisioner, uk userKeys, created accounts) ([]string, error) {
+	if _, err := m.Exist(uk.name); err != nil {
+		if err = m.CreateUser(uk.name); err != nil {
+			return nil, err
 		}
-		sshKey := parts[1]
-		users = append(users, usermanager.User{Name: name, SshKey: sshKey})
+		created[uk.name] = account{}
+	}
+	sysUser, err := m.Lookup(uk.name)
+	if err != nil {
+		return nil, err
 	}
 
-	return users, nil
+	// sshd asks agent for keys in command mode, so no keys are left in authorized_keys
+	lines := uk.authorizedKeys()
+	if modeFromContext(ctx) == ModeCommand {
+		lines = nil
+	}
+
+	// managed keys of user are replaced, so keys removed from metadata are revoked
+	_, removed, err := m.SyncSshKeys(sysUser, lines)
+
+	return removed, err
 }
@@ -1,7 +1,13 @@
 package sshkeys
 
 import (
+	"context"
+	"os/user"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"marketplace-yaga/linux/internal/usermanager"
 )
 
 //goland:noinspection GoUnusedType
@@ -26,3 +32,74 @@ func (m *serialPortMock) Close() error {
 
 	return args.Error(0)
 }
+
+type fakeProvisioner struct {
+	*fakeAccountManager
+	denied map[string]bool
+}
+
+func (m *fakeProvisioner) ValidateUsername(string) error { return nil }
+
+func (m *fakeProvisioner) ValidateUser(username string) error {
+	if m.denied[username] {
+		return usermanager.ErrRestrictedUser
+	}
+
+	return nil
+}
+
+func (m *fakeProvisioner) Exist(username string) (bool, error) {
+	if !m.existing[username] {
+		return false, user.UnknownUserError(username)
+	}
+
+	return true, nil
+}
+
+func (m *fakeProvisioner) CreateUser(username string) error {
+	if err := m.call("create " + username); err != nil {
+		return err
+	}
+	m.existing[username] = true
+
+	return nil
+}
+
+func TestProvisionUsers(t *testing.T) {
+	key := func(u string) sshKey { return sshKey{User: u, Type: "ssh-ed25519", Data: testKeyData} }
+	users := groupByUser([]sshKey{key("alice"), key("bob"), key("carol"), key("dave"), key("erin")})
+	previous := keysCache{"bob": {{Key: "ssh-ed25519 OLD"}}, "carol": {{Key: "ssh-ed25519 OLD"}}}
+	created := accounts{}
+
+	m := &fakeProvisioner{
+		fakeAccountManager: &fakeAccountManager{
+			existing: map[string]bool{"alice": true, "bob": true, "carol": true},
+			failOn:   "sync bob",
+		},
+		denied: map[string]bool{"carol": true},
+	}
+
+	cache, _, errs := provisionUsers(context.Background(), m, users, created, previous)
+
+	// failure of bob and carol does not stop later users
+	assert.Equal(t, []string{"sync alice", "create dave", "sync dave", "create erin", "sync erin"}, m.calls)
+	assert.Equal(t, accounts{"dave": {}, "erin": {}}, created)
+	assert.Equal(t, []string{"alice", "bob", "dave", "erin"}, cache.users())
+	// failed user keeps previous keys, denied one gets none
+	assert.Equal(t, previous["bob"], cache["bob"])
+	if assert.Len(t, errs, 2) {
+		assert.Equal(t, "bob", errs[0].User)
+		assert.Equal(t, "carol", errs[1].User)
+	}
+}
+
+func TestProvisionUsers_CreateFailed(t *testing.T) {
+	users := groupByUser([]sshKey{{User: "alice", Type: "ssh-ed25519", Data: testKeyData}})
+	m := &fakeProvisioner{fakeAccountManager: &fakeAccountManager{existing: map[string]bool{}, failOn: "create alice"}}
+	created := accounts{}
+
+	cache, _, errs := provisionUsers(context.Background(), m, users, created, keysCache{})
+	assert.Empty(t, cache)
+	assert.Empty(t, created)
+	assert.Equal(t, []userError{{User: "alice", Error: "failed create alice"}}, errs)
+}
@@ -0,0 +1,189 @@
+package sshkeys
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultKeyPolicyFile is path of key policy, default policy is used if there is no such file.
+const DefaultKeyPolicyFile = "/etc/yandex-guest-agent/ssh-key-policy.json"
+
+var ErrKeyRejected = errors.New("key rejected by policy")
+
+// KeyPolicy defines which ssh-keys metadata keys are accepted.
+type KeyPolicy struct {
+	// Algorithms lists accepted key types.
+	Algorithms []string `json:"algorithms,omitempty"`
+	// MinRSABits is minimal size of RSA key.
+	MinRSABits int `json:"minRsaBits,omitempty"`
+	// AllowedOptions lists names of authorized_keys options keys could have, e.g. "from" or "no-pty".
+	// Keys with other options are rejected, "*" allows any options.
+	AllowedOptions []string `json:"allowedOptions"`
+}
+
+// DefaultKeyPolicy rejects DSA and short RSA keys, and options except ones, which only restrict key.
+func DefaultKeyPolicy() KeyPolicy {
+	return KeyPolicy{
+		Algorithms: []string{
+			ssh.KeyAlgoED25519,
+			ssh.KeyAlgoSKED25519,
+			ssh.KeyAlgoECDSA256,
+			ssh.KeyAlgoECDSA384,
+			ssh.KeyAlgoECDSA521,
+			ssh.KeyAlgoSKECDSA256,
+			ssh.KeyAlgoRSA,
+		},
+		MinRSABits: 2048,
+		AllowedOptions: []string{
+			"from",
+			"expiry-time",
+			"restrict",
+			"no-agent-forwarding",
+			"no-port-forwarding",
+			"no-pty",
+			"no-user-rc",
+			"no-x11-forwarding",
+		},
+	}
+}
+
+// LoadKeyPolicy reads policy from JSON file, fields missing in file are taken from default policy.
+// Missing file is not an error, default policy is returned then.
+func LoadKeyPolicy(fsys afero.Fs, file string) (KeyPolicy, error) {
+	p := DefaultKeyPolicy()
+
+	content, err := afero.ReadFile(fsys, file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return p, err
+	}
+
+	if err = json.Unmarshal(content, &p); err != nil {
+		return p, fmt.Errorf("failed to parse ssh key policy %v: %w", file, err)
+	}
+	for _, a := range p.Algorithms {
+		if _, ok := keyTypes[a]; !ok {
+			return p, fmt.Errorf("%w in ssh key policy: %v", ErrUnknownKeyType, a)
+		}
+	}
+
+	return p, nil
+}
+
+// check tells if key is accepted by policy.
+func (p KeyPolicy) check(k sshKey) error {
+	if !contains(p.Algorithms, k.Type) {
+		return fmt.Errorf("%w: %v keys are not allowed", ErrKeyRejected, k.Type)
+	}
+
+	if k.Type == ssh.KeyAlgoRSA {
+		if ck, ok := k.PublicKey.(ssh.CryptoPublicKey); ok {
+			if rk, ok := ck.CryptoPublicKey().(*rsa.PublicKey); ok && rk.N.BitLen() < p.MinRSABits {
+				return fmt.Errorf("%w: RSA key has %v bits, at least %v required",
+					ErrKeyRejected, rk.N.BitLen(), p.MinRSABits)
+			}
+		}
+	}
+
+	if contains(p.AllowedOptions, "*") {
+		return nil
+	}
+	for _, o := range splitOptions(k.Options) {
+		name, _, _ := strings.Cut(o, "=")
+		if !contains(p.AllowedOptions, strings.ToLower(name)) {
+			return fmt.Errorf("%w: option %v is not allowed", ErrKeyRejected, name)
+		}
+	}
+
+	return nil
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitOptions splits authorized_keys options on commas outside of double quotes.
+func splitOptions(options string) []string {
+	if options == "" {
+		return nil
+	}
+
+	var res []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(options); i++ {
+		switch options[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				res = append(res, options[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(res, options[start:])
+}
+
+// rejectedKey describes key, which was parsed, but not accepted by policy.
+type rejectedKey struct {
+	User        string `json:"user"`
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+	Error       string `json:"error"`
+}
+
+// filterKeys returns keys accepted by policy and describes rejected ones.
+func (p KeyPolicy) filterKeys(keys []sshKey) (accepted []sshKey, rejected []rejectedKey) {
+	for _, k := range keys {
+		if err := p.check(k); err != nil {
+			rejected = append(rejected, rejectedKey{
+				User:        k.User,
+				Type:        k.Type,
+				Fingerprint: k.Fingerprint(),
+				Error:       err.Error(),
+			})
+			continue
+		}
+		accepted = append(accepted, k)
+	}
+
+	return accepted, rejected
+}
+
+type keyPolicyKey struct{}
+
+// NewKeyPolicyContext stores key policy in context.
+func NewKeyPolicyContext(ctx context.Context, p KeyPolicy) context.Context {
+	return context.WithValue(ctx, keyPolicyKey{}, p)
+}
+
+// keyPolicyFromContext returns policy stored in context or default one.
+func keyPolicyFromContext(ctx context.Context) KeyPolicy {
+	if p, ok := ctx.Value(keyPolicyKey{}).(KeyPolicy); ok {
+		return p
+	}
+
+	return DefaultKeyPolicy()
+}
@@ -0,0 +1,119 @@
+package sshkeys
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+const testDSAKeyData = "AAAAB3NzaC1kc3MAAACBALR+IPo5/6xcW4AVcveDUSrawqSBSukZ+GIbKXYA0q4dJ3u5pGsMWuTQnySyFYLTuxGfMejefLoi1Kuc" +
+	"KAstqjOgGZM0alGGgpI3GHtr0Of5BBGAPDGbeScgRXtsLZUEF9U2JJM1tawXY+hymrEx9SA8lvtErYDVajYBjto2CDnnAAAAFQDb" +
+	"qToSNIKVBBFO30TXvRA2nJ9pWQAAAIAMcqLixnl0lOKgRUziLg6SruzWuviGsl/Wj/XJisKhN0QLzb804qrArtA8ebV2Cbgksq+9" +
+	"3m6RA+EL7GDbL5aBUbHKcf53uAeQLyur96dQ5FtHW58er7BIzJyDXhCaYPiPhHcly64Gpyrdgf84+w9g/0D/IMrR0cTeyfcQEPzl" +
+	"nQAAAIBVzm/fSLLjUHz8ypJwcjDGozftrnpFDvgNSsWTXsAfRgN4FAUmbPcDQYPOBvRCl6bh7ctaDhoEdaOBZeK6yggb7+0Dk5qi" +
+	"WjrMt8wpctwgFYGk0icKmg90qIBQU3JFu1vuJdfbdoqVTFkZj3XnTkGeg0Y6ociZTkqHEpWGxg11eA=="
+
+func testRSAKeyData(t *testing.T, bits int) string {
+	t.Helper()
+
+	k, err := rsa.GenerateKey(rand.Reader, bits)
+	require.NoError(t, err)
+	pk, err := ssh.NewPublicKey(&k.PublicKey)
+	require.NoError(t, err)
+
+	return base64.StdEncoding.EncodeToString(pk.Marshal())
+}
+
+func TestKeyPolicy_check(t *testing.T) {
+	rsa1024 := testRSAKeyData(t, 1024)
+	rsa2048 := testRSAKeyData(t, 2048)
+
+	tests := []struct {
+		name    string
+		policy  KeyPolicy
+		line    string
+		wantErr bool
+	}{
+		{name: "ed25519", line: "alice:ssh-ed25519 " + testKeyData},
+		{name: "rsa 2048", line: "alice:ssh-rsa " + rsa2048},
+		{name: "rsa 1024", line: "alice:ssh-rsa " + rsa1024, wantErr: true},
+		{name: "dsa", line: "alice:ssh-dss " + testDSAKeyData, wantErr: true},
+		{name: "restricting options", line: `alice:from="10.0.0.0/8",no-pty ssh-ed25519 ` + testKeyData},
+		{name: "command option", line: `alice:no-pty,command="/bin/sh -c 'curl x,y'" ssh-ed25519 ` + testKeyData, wantErr: true},
+		{
+			name:   "any option allowed",
+			policy: KeyPolicy{Algorithms: []string{ssh.KeyAlgoED25519}, AllowedOptions: []string{"*"}},
+			line:   `alice:command="true" ssh-ed25519 ` + testKeyData,
+		},
+		{
+			name:    "algorithm not in custom list",
+			policy:  KeyPolicy{Algorithms: []string{ssh.KeyAlgoED25519}},
+			line:    "alice:ssh-rsa " + rsa2048,
+			wantErr: true,
+		},
+		{
+			name:   "dsa allowed by custom policy",
+			policy: KeyPolicy{Algorithms: []string{ssh.KeyAlgoDSA}},
+			line:   "alice:ssh-dss " + testDSAKeyData,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.policy
+			if p.Algorithms == nil {
+				p = DefaultKeyPolicy()
+			}
+			k, err := parseLine(tt.line)
+			require.NoError(t, err)
+
+			err = p.check(k)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrKeyRejected)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestKeyPolicy_filterKeys(t *testing.T) {
+	good, err := parseLine("alice:ssh-ed25519 " + testKeyData)
+	require.NoError(t, err)
+	bad, err := parseLine("bob:ssh-dss " + testDSAKeyData)
+	require.NoError(t, err)
+
+	accepted, rejected := DefaultKeyPolicy().filterKeys([]sshKey{good, bad})
+
+	require.Len(t, accepted, 1)
+	assert.Equal(t, "alice", accepted[0].User)
+	require.Len(t, rejected, 1)
+	assert.Equal(t, "bob", rejected[0].User)
+	assert.Equal(t, ssh.KeyAlgoDSA, rejected[0].Type)
+	assert.Equal(t, ssh.FingerprintSHA256(bad.PublicKey), rejected[0].Fingerprint)
+	assert.Contains(t, rejected[0].Fingerprint, "SHA256:")
+}
+
+func TestLoadKeyPolicy(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	p, err := LoadKeyPolicy(fs, DefaultKeyPolicyFile)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultKeyPolicy(), p)
+
+	require.NoError(t, afero.WriteFile(fs, DefaultKeyPolicyFile, []byte(`{"minRsaBits": 4096, "allowedOptions": []}`), 0644))
+	p, err = LoadKeyPolicy(fs, DefaultKeyPolicyFile)
+	require.NoError(t, err)
+	assert.Equal(t, 4096, p.MinRSABits)
+	assert.Empty(t, p.AllowedOptions)
+	assert.Equal(t, DefaultKeyPolicy().Algorithms, p.Algorithms)
+
+	require.NoError(t, afero.WriteFile(fs, DefaultKeyPolicyFile, []byte(`{"algorithms": ["ssh-foo"]}`), 0644))
+	_, err = LoadKeyPolicy(fs, DefaultKeyPolicyFile)
+	assert.ErrorIs(t, err, ErrUnknownKeyType)
+}
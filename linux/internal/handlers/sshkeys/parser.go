@@ -0,0 +1,267 @@
+package sshkeys
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var ErrUnknownKeyType = errors.New("unknown key type")
+var ErrBadKeyData = errors.New("key data is not valid public key")
+var ErrBadOptions = errors.New("unterminated quote in key options")
+var ErrBadExpiry = errors.New("wrong google-ssh expiry format")
+var ErrKeyExpired = errors.New("key expired")
+
+// googleSshMarker starts comment, which carries JSON with key expiration time.
+const googleSshMarker = "google-ssh"
+
+// googleSshTimeLayout is layout of expireOn field, e.g. 2018-04-23T19:00:00+0000.
+const googleSshTimeLayout = "2006-01-02T15:04:05-0700"
+
+// keyTypes lists public key algorithms sshd accepts in authorized_keys.
+var keyTypes = map[string]struct{}{
+	"ssh-rsa":                            {},
+	"ssh-dss":                            {},
+	"ssh-ed25519":                        {},
+	"ecdsa-sha2-nistp256":                {},
+	"ecdsa-sha2-nistp384":                {},
+	"ecdsa-sha2-nistp521":                {},
+	"sk-ecdsa-sha2-nistp256@openssh.com": {},
+	"sk-ssh-ed25519@openssh.com":         {},
+}
+
+// sshKey is single key line of ssh-keys metadata.
+type sshKey struct {
+	User    string
+	Options string
+	Type    string
+	Data    string
+	Comment string
+	// ExpireOn is zero if key never expires.
+	ExpireOn time.Time
+	// PublicKey is parsed Data.
+	PublicKey ssh.PublicKey
+}
+
+// Fingerprint returns SHA256 fingerprint of key, as ssh-keygen -l prints it.
+func (k sshKey) Fingerprint() string {
+	if k.PublicKey == nil {
+		return ""
+	}
+
+	return ssh.FingerprintSHA256(k.PublicKey)
+}
+
+// AuthorizedKey returns key as authorized_keys line.
+func (k sshKey) AuthorizedKey() string {
+	var b strings.Builder
+	if k.Options != "" {
+		b.WriteString(k.Options)
+		b.WriteString(" ")
+	}
+	b.WriteString(k.Type)
+	b.WriteString(" ")
+	b.WriteString(k.Data)
+	if k.Comment != "" {
+		b.WriteString(" ")
+		b.WriteString(k.Comment)
+	}
+
+	return b.String()
+}
+
+// Expired tells if key has expired at t.
+func (k sshKey) Expired(t time.Time) bool {
+	return !k.ExpireOn.IsZero() && !t.Before(k.ExpireOn)
+}
+
+// lineError describes ssh-keys metadata line, which was skipped.
+type lineError struct {
+	Line  int    `json:"line"`
+	User  string `json:"user,omitempty"`
+	Error string `json:"error"`
+	err   error
+}
+
+// parseSshKeys parses ssh-keys metadata in user:key format, where key is authorized_keys line,
+// optionally with options and google-ssh expiry comment.
+// Bad and expired lines are returned as errors, and do not prevent other lines from parsing.
+func parseSshKeys(data []byte, now time.Time) ([]sshKey, []lineError) {
+	var keys []sshKey
+	var errs []lineError
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		k, err := parseLine(line)
+		if err == nil && k.Expired(now) {
+			err = fmt.Errorf("%w on %v", ErrKeyExpired, k.ExpireOn.Format(time.RFC3339))
+		}
+		if err != nil {
+			errs = append(errs, lineError{Line: i + 1, User: k.User, Error: err.Error(), err: err})
+			continue
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, errs
+}
+
+// parseLine parses user:key line. Only the first colon separates user, key comment may contain colons.
+func parseLine(line string) (k sshKey, err error) {
+	user, key, ok := strings.Cut(line, ":")
+	if !ok {
+		return k, ErrWrongSshKeyFormat
+	}
+	k.User = strings.TrimSpace(user)
+	if k.User == "" {
+		return k, ErrEmptyUserName
+	}
+
+	key = strings.TrimSpace(key)
+	first, rest := cutField(key)
+	if _, known := keyTypes[first]; !known {
+		// authorized_keys line either starts with key type or with options followed by it
+		k.Options, rest, err = cutOptions(key)
+		if err != nil {
+			return k, err
+		}
+		first, rest = cutField(rest)
+		if _, known = keyTypes[first]; !known {
+			return k, fmt.Errorf("%w: %q", ErrUnknownKeyType, first)
+		}
+	}
+	k.Type = first
+
+	k.Data, k.Comment = cutField(rest)
+	raw, err := base64.StdEncoding.DecodeString(k.Data)
+	if err != nil || k.Data == "" {
+		return k, ErrBadKeyData
+	}
+	k.PublicKey, err = ssh.ParsePublicKey(raw)
+	if err != nil {
+		return k, fmt.Errorf("%w: %v", ErrBadKeyData, err)
+	}
+	if k.PublicKey.Type() != k.Type {
+		return k, fmt.Errorf("%w: %v key declared as %v", ErrBadKeyData, k.PublicKey.Type(), k.Type)
+	}
+
+	k.ExpireOn, err = parseExpiry(k.Comment)
+
+	return k, err
+}
+
+// cutField splits s on first run of whitespace.
+func cutField(s string) (field, rest string) {
+	i := strings.IndexAny(s, " \t")
+	if i < 0 {
+		return s, ""
+	}
+
+	return s[:i], strings.TrimLeft(s[i:], " \t")
+}
+
+// cutOptions splits leading options field, which ends with first whitespace outside of double quotes.
+func cutOptions(s string) (options, rest string, err error) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case ' ', '\t':
+			if !quoted {
+				return s[:i], strings.TrimLeft(s[i:], " \t"), nil
+			}
+		}
+	}
+	if quoted {
+		return "", "", ErrBadOptions
+	}
+
+	return s, "", nil
+}
+
+// parseExpiry returns expiration time from google-ssh comment, e.g.
+// google-ssh {"userName":"user@example.com","expireOn":"2018-04-23T19:00:00+0000"}.
+// Zero time is returned for other comments.
+func parseExpiry(comment string) (time.Time, error) {
+	if !strings.HasPrefix(comment, googleSshMarker) {
+		return time.Time{}, nil
+	}
+
+	var meta struct {
+		ExpireOn string `json:"expireOn"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(comment, googleSshMarker))), &meta); err != nil {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrBadExpiry, err)
+	}
+	if meta.ExpireOn == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(googleSshTimeLayout, meta.ExpireOn)
+	if err != nil {
+		// RFC 3339 is accepted as well
+		if t, err = time.Parse(time.RFC3339, meta.ExpireOn); err != nil {
+			return time.Time{}, fmt.Errorf("%w: %v", ErrBadExpiry, meta.ExpireOn)
+		}
+	}
+
+	return t, nil
+}
+
+// userKeys are keys of single user.
+type userKeys struct {
+	name string
+	keys []sshKey
+}
+
+// authorizedKeys returns keys as authorized_keys lines.
+func (uk userKeys) authorizedKeys() []string {
+	lines := make([]string, 0, len(uk.keys))
+	for _, k := range uk.keys {
+		lines = append(lines, k.AuthorizedKey())
+	}
+
+	return lines
+}
+
+// groupByUser groups keys by user, keeping order in which users appear in metadata.
+func groupByUser(keys []sshKey) []userKeys {
+	var users []userKeys
+	idx := make(map[string]int)
+	for _, k := range keys {
+		i, ok := idx[k.User]
+		if !ok {
+			i = len(users)
+			idx[k.User] = i
+			users = append(users, userKeys{name: k.User})
+		}
+		users[i].keys = append(users[i].keys, k)
+	}
+
+	return users
+}
+
+// nextExpiry returns earliest expiration time of keys, or zero time if none of keys expires.
+func nextExpiry(keys []sshKey) time.Time {
+	var next time.Time
+	for _, k := range keys {
+		if !k.ExpireOn.IsZero() && (next.IsZero() || k.ExpireOn.Before(next)) {
+			next = k.ExpireOn
+		}
+	}
+
+	return next
+}
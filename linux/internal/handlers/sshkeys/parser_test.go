@@ -0,0 +1,107 @@
+package sshkeys
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKeyData = "AAAAC3NzaC1lZDI1NTE5AAAAIB92T8dhrVrEPl4aPclr1VesSvaYDaZajzqmASek3jPi"
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    sshKey
+		wantErr error
+	}{
+		{
+			name: "plain",
+			line: "alice:ssh-ed25519 " + testKeyData + " alice@laptop",
+			want: sshKey{User: "alice", Type: "ssh-ed25519", Data: testKeyData, Comment: "alice@laptop"},
+		},
+		{
+			name: "no comment",
+			line: "alice:ssh-ed25519 " + testKeyData,
+			want: sshKey{User: "alice", Type: "ssh-ed25519", Data: testKeyData},
+		},
+		{
+			name: "comment with colons",
+			line: "alice:ssh-ed25519 " + testKeyData + " created 2023-01-01T10:00:00 by ci",
+			want: sshKey{User: "alice", Type: "ssh-ed25519", Data: testKeyData, Comment: "created 2023-01-01T10:00:00 by ci"},
+		},
+		{
+			name: "options",
+			line: `alice:from="10.0.0.0/8,192.168.1.1",command="echo a b",no-pty ssh-ed25519 ` + testKeyData + " ci",
+			want: sshKey{
+				User:    "alice",
+				Options: `from="10.0.0.0/8,192.168.1.1",command="echo a b",no-pty`,
+				Type:    "ssh-ed25519",
+				Data:    testKeyData,
+				Comment: "ci",
+			},
+		},
+		{
+			name: "google-ssh expiry",
+			line: `alice:ssh-ed25519 ` + testKeyData + ` google-ssh {"userName":"alice@example.com","expireOn":"2030-04-23T19:00:00+0000"}`,
+			want: sshKey{
+				User:     "alice",
+				Type:     "ssh-ed25519",
+				Data:     testKeyData,
+				Comment:  `google-ssh {"userName":"alice@example.com","expireOn":"2030-04-23T19:00:00+0000"}`,
+				ExpireOn: time.Date(2030, 4, 23, 19, 0, 0, 0, time.UTC),
+			},
+		},
+		{name: "no colon", line: "ssh-ed25519 " + testKeyData, wantErr: ErrWrongSshKeyFormat},
+		{name: "empty user", line: ":ssh-ed25519 " + testKeyData, wantErr: ErrEmptyUserName},
+		{name: "unknown type", line: "alice:ssh-foo " + testKeyData, wantErr: ErrUnknownKeyType},
+		{name: "unterminated quote", line: `alice:from="10.0.0.1 ssh-ed25519 ` + testKeyData, wantErr: ErrBadOptions},
+		{name: "bad data", line: "alice:ssh-ed25519 not-base64!", wantErr: ErrBadKeyData},
+		{name: "no data", line: "alice:ssh-ed25519", wantErr: ErrBadKeyData},
+		{name: "not a key", line: "alice:ssh-ed25519 c29tZSBnYXJiYWdl", wantErr: ErrBadKeyData},
+		{name: "type mismatch", line: "alice:ssh-rsa " + testKeyData, wantErr: ErrBadKeyData},
+		{name: "bad expiry", line: `alice:ssh-ed25519 ` + testKeyData + ` google-ssh {"expireOn":"tomorrow"}`, wantErr: ErrBadExpiry},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLine(tt.line)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, tt.want.ExpireOn.Equal(got.ExpireOn), "ExpireOn = %v, want %v", got.ExpireOn, tt.want.ExpireOn)
+			got.ExpireOn = tt.want.ExpireOn
+			require.NotNil(t, got.PublicKey)
+			got.PublicKey = nil
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.line[len(tt.want.User)+1:], got.AuthorizedKey())
+		})
+	}
+}
+
+func TestParseSshKeys(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := "alice:ssh-ed25519 " + testKeyData + " a\r\n" +
+		"\n" +
+		"broken line\n" +
+		`bob:ssh-ed25519 ` + testKeyData + ` google-ssh {"userName":"bob","expireOn":"2024-01-01T00:00:00+0000"}` + "\n" +
+		`carol:ssh-ed25519 ` + testKeyData + ` google-ssh {"userName":"carol","expireOn":"2026-01-01T00:00:00+0000"}` + "\n"
+
+	keys, errs := parseSshKeys([]byte(data), now)
+
+	require.Len(t, keys, 2)
+	assert.Equal(t, "alice", keys[0].User)
+	assert.Equal(t, "carol", keys[1].User)
+	assert.Equal(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), nextExpiry(keys).UTC())
+
+	require.Len(t, errs, 2)
+	assert.Equal(t, 3, errs[0].Line)
+	assert.True(t, errors.Is(errs[0].err, ErrWrongSshKeyFormat))
+	assert.Equal(t, 4, errs[1].Line)
+	assert.Equal(t, "bob", errs[1].User)
+	assert.True(t, errors.Is(errs[1].err, ErrKeyExpired))
+}
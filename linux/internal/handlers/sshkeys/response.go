@@ -6,9 +6,48 @@ const UserUpdateSshKeysResponseType = "UserUpdateSshKeys"
 
 // response is struct which converted to json and passed to COM port as result of user_handle execution.
 type response struct {
-	Users   []usermanager.User `json:"users"`
-	Success bool               `json:"success"`
-	Error   string             `json:"error"`
+	Users []usermanager.User `json:"users"`
+	// Revoked lists managed keys, which were removed from metadata and so from authorized_keys.
+	Revoked []usermanager.User `json:"revoked,omitempty"`
+	// Rejected lists keys, which are not accepted by key policy.
+	Rejected []rejectedKey `json:"rejected,omitempty"`
+	// Accounts lists actions taken on accounts of users, which disappeared from metadata or appeared again.
+	Accounts []accountChange `json:"accounts,omitempty"`
+	// Settings lists changes made to users to match ssh-users metadata.
+	Settings []settingsChange `json:"settings,omitempty"`
+	// UserErrors lists users, which were not provisioned.
+	UserErrors []userError `json:"userErrors,omitempty"`
+	// Errors lists metadata lines, which were skipped.
+	Errors  []lineError `json:"errors,omitempty"`
+	Success bool        `json:"success"`
+	Error   string      `json:"error"`
+}
+
+// userError is error of single user, other users are provisioned regardless of it.
+type userError struct {
+	User  string `json:"user"`
+	Error string `json:"error"`
+}
+
+// withRevoked add revoked keys to resulting response.
+func (res *response) withRevoked(users []usermanager.User) *response {
+	res.Revoked = users
+
+	return res
+}
+
+// withRejected add keys rejected by policy to resulting response.
+func (res *response) withRejected(keys []rejectedKey) *response {
+	res.Rejected = keys
+
+	return res
+}
+
+// withLineErrors add skipped metadata lines to resulting response.
+func (res *response) withLineErrors(errs []lineError) *response {
+	res.Errors = errs
+
+	return res
 }
 
 // withUsers add parsed users to resulting response.
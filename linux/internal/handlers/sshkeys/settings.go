@@ -0,0 +1,277 @@
+package sshkeys
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"sync"
+
+	"github.com/spf13/afero"
+	"go.uber.org/zap"
+	"marketplace-yaga/linux/internal/persistance"
+	"marketplace-yaga/pkg/logger"
+)
+
+// UsersMetadataURL contain URL of ssh-users attribute, which declares groups, sudo and shell of ssh-keys users.
+const UsersMetadataURL = "http://169.254.169.254/computeMetadata/v1/instance/attributes/ssh-users"
+
+// Files with declared ssh-users settings, and with settings which were applied to users.
+// Applied settings tell what must be reverted, when declaration changes, so they hold only groups and sudo,
+// which agent granted. Memberships user had before are never revoked.
+const (
+	DefaultSettingsFile = "/opt/yandex-guest-agent/ssh-users.json"
+	appliedSettingsFile = "/opt/yandex-guest-agent/ssh-users-applied.json"
+)
+
+// settingsFile and appliedFile are overridden in tests.
+var (
+	settingsFile = DefaultSettingsFile
+	appliedFile  = appliedSettingsFile
+)
+
+// settingsMu serializes reconciliation, which is done by both ssh-keys and ssh-users handlers.
+var settingsMu sync.Mutex
+
+// UserSettings are declared in ssh-users metadata for ssh-keys user, e.g.
+// {"alice": {"groups": ["docker"], "sudo": true, "shell": "/bin/zsh"}}.
+type UserSettings struct {
+	Groups []string `json:"groups,omitempty"`
+	Sudo   bool     `json:"sudo,omitempty"`
+	// Shell is left as is if empty.
+	Shell string `json:"shell,omitempty"`
+}
+
+// usersSettings maps usernames to their settings.
+type usersSettings map[string]UserSettings
+
+func parseUsersSettings(data []byte) (usersSettings, error) {
+	s := make(usersSettings)
+	if len(bytes.TrimSpace(data)) == 0 {
+		return s, nil
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse ssh-users metadata: %w", err)
+	}
+
+	return s, nil
+}
+
+func readUsersSettings(fsys afero.Fs, file string) (usersSettings, error) {
+	b, err := afero.ReadFile(fsys, file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return make(usersSettings), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return parseUsersSettings(b)
+}
+
+func writeUsersSettings(ctx context.Context, fsys afero.Fs, file string, s usersSettings) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	return persistance.WriteFile(ctx, fsys, file, bytes.NewReader(b))
+}
+
+// settingsManager applies settings to local users.
+type settingsManager interface {
+	InGroup(username, group string) (bool, error)
+	IsAdministrator(username string) (bool, error)
+	AddToGroup(username, group string) error
+	RemoveFromGroup(username, group string) error
+	AddToAdministrators(username string) error
+	RemoveFromAdministrators(username string) error
+	SetShell(username, shell string) error
+}
+
+// settingsChange lists changes made to user to match declared settings.
+type settingsChange struct {
+	User    string   `json:"user"`
+	Changes []string `json:"changes"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// reconcileSettings makes user match declared settings. Groups and sudo, which agent granted before but are not
+// declared anymore, are revoked. Declared groups and sudo, which user already has, are left as is and not recorded
+// as granted, so they are never revoked. Shell is only changed if declared.
+// Returns settings applied so far, which are to be recorded even on error, so grants are not lost.
+func reconcileSettings(m settingsManager, username string, applied, declared UserSettings) (UserSettings, []string, error) {
+	granted := UserSettings{Groups: append([]string(nil), applied.Groups...), Sudo: applied.Sudo, Shell: applied.Shell}
+	var changes []string
+
+	for _, g := range applied.Groups {
+		if contains(declared.Groups, g) {
+			continue
+		}
+		if err := m.RemoveFromGroup(username, g); err != nil {
+			return granted, changes, err
+		}
+		granted.Groups = without(granted.Groups, g)
+		changes = append(changes, "removed from group "+g)
+	}
+	for _, g := range declared.Groups {
+		if contains(applied.Groups, g) {
+			continue
+		}
+		member, err := m.InGroup(username, g)
+		if err != nil {
+			return granted, changes, err
+		}
+		if member {
+			continue
+		}
+		if err = m.AddToGroup(username, g); err != nil {
+			return granted, changes, err
+		}
+		granted.Groups = append(granted.Groups, g)
+		changes = append(changes, "added to group "+g)
+	}
+
+	switch {
+	case declared.Sudo && !applied.Sudo:
+		admin, err := m.IsAdministrator(username)
+		if err != nil {
+			return granted, changes, err
+		}
+		if admin {
+			break
+		}
+		if err = m.AddToAdministrators(username); err != nil {
+			return granted, changes, err
+		}
+		granted.Sudo = true
+		changes = append(changes, "granted sudo")
+	case !declared.Sudo && applied.Sudo:
+		if err := m.RemoveFromAdministrators(username); err != nil {
+			return granted, changes, err
+		}
+		granted.Sudo = false
+		changes = append(changes, "revoked sudo")
+	}
+
+	if declared.Shell != "" && declared.Shell != applied.Shell {
+		if err := m.SetShell(username, declared.Shell); err != nil {
+			return granted, changes, err
+		}
+		granted.Shell = declared.Shell
+		changes = append(changes, "set shell "+declared.Shell)
+	}
+
+	return granted, changes, nil
+}
+
+// without returns list without s.
+func without(l []string, s string) []string {
+	var res []string
+	for _, v := range l {
+		if v != s {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
+
+// reconcileUsers applies declared ssh-users settings to provisioned users, and records what was applied.
+// Users, which had settings applied, but are not provisioned anymore, e.g. vanished accounts, which were kept or
+// locked, have their groups and sudo revoked.
+// Failure of single user does not prevent others from reconciling, it is reported in its change.
+func reconcileUsers(ctx context.Context, fsys afero.Fs, m settingsManager, declared usersSettings, users []string) ([]settingsChange, error) {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+
+	applied, err := readUsersSettings(fsys, appliedFile)
+	if err != nil {
+		return nil, err
+	}
+
+	provisioned := make(map[string]bool, len(users))
+	for _, u := range users {
+		provisioned[u] = true
+	}
+	all := append([]string(nil), users...)
+	for u := range applied {
+		if !provisioned[u] {
+			all = append(all, u)
+		}
+	}
+	sort.Strings(all)
+
+	var res []settingsChange
+	var failed bool
+	for _, u := range all {
+		want, ok := declared[u]
+		if !provisioned[u] {
+			want, ok = UserSettings{}, false
+		}
+
+		granted, changes, err := reconcileSettings(m, u, applied[u], want)
+		if err != nil {
+			logger.ErrorCtx(ctx, err, "reconciled ssh-users settings",
+				zap.String("username", u))
+			res = append(res, settingsChange{User: u, Changes: changes, Error: err.Error()})
+			failed = true
+			// changes made so far are recorded, the rest are retried on next run
+			applied[u] = granted
+			continue
+		}
+		if len(changes) > 0 {
+			res = append(res, settingsChange{User: u, Changes: changes})
+		}
+
+		if ok {
+			applied[u] = granted
+		} else {
+			delete(applied, u)
+		}
+	}
+
+	if err = writeUsersSettings(ctx, fsys, appliedFile, applied); err != nil {
+		return res, err
+	}
+	if failed {
+		return res, ErrSettingsPartial
+	}
+
+	return res, nil
+}
+
+// forgetSettings drops applied settings of deleted users, so account created later with same name gets them anew.
+func forgetSettings(ctx context.Context, fsys afero.Fs, users []string) error {
+	if len(users) == 0 {
+		return nil
+	}
+
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+
+	applied, err := readUsersSettings(fsys, appliedFile)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		delete(applied, u)
+	}
+
+	return writeUsersSettings(ctx, fsys, appliedFile, applied)
+}
+
+var ErrSettingsPartial = errors.New("settings were not applied to some users")
+
+// cachedUsers returns users from ssh keys cache, sorted by name.
+func cachedUsers(fsys afero.Fs, file string) ([]string, error) {
+	c, err := readKeysCache(fsys, file)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.users(), nil
+}
@@ -0,0 +1,115 @@
+package sshkeys
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"marketplace-yaga/linux/internal/audit"
+	"marketplace-yaga/linux/internal/plan"
+	"marketplace-yaga/linux/internal/usermanager"
+	"marketplace-yaga/pkg/logger"
+	"marketplace-yaga/pkg/messages"
+	"marketplace-yaga/pkg/meta"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// settingsHandlerName contain name of ssh-users handler.
+const settingsHandlerName = "ssh_users_handler"
+
+const UserUpdateSshUsersResponseType = "UserUpdateSshUsers"
+
+// SettingsHandler applies ssh-users metadata to users provisioned by ssh-keys handler.
+type SettingsHandler struct {
+	lastProcessedSha []byte
+}
+
+// NewSettingsHandler return instance of SettingsHandler.
+func NewSettingsHandler() *SettingsHandler {
+	return &SettingsHandler{}
+}
+
+// String returns name of handler.
+func (h *SettingsHandler) String() string {
+	return settingsHandlerName
+}
+
+// settingsResponse is passed to COM port as result of ssh-users processing.
+type settingsResponse struct {
+	Users   []settingsChange `json:"users"`
+	Skipped []string         `json:"skipped,omitempty"`
+	Success bool             `json:"success"`
+	Error   string           `json:"error"`
+}
+
+// Handle stores declared settings and reconciles users, which ssh-keys handler provisioned.
+func (h *SettingsHandler) Handle(ctx context.Context, data []byte) {
+	err := ctx.Err()
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "checked deadline or context cancellation")
+		return
+	}
+	dataSha := sha256.Sum256(data)
+	if !meta.IsForced(ctx) && bytes.Equal(dataSha[:], h.lastProcessedSha) {
+		return
+	}
+	ctx = audit.WithRequest(ctx, settingsHandlerName, hex.EncodeToString(dataSha[:8]))
+
+	resp, err := processSettings(ctx, usermanager.New(ctx), data)
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "processed ssh-users")
+		resp.Error = err.Error()
+	} else {
+		resp.Success = true
+	}
+	meta.SetResult(ctx, resp, err)
+	audit.Record(ctx, plan.Action{Kind: audit.HandleRequest, Target: settingsHandlerName}, err)
+
+	if plan.Enabled(ctx) {
+		return
+	}
+
+	var e = messages.NewEnvelope()
+	e.WithTimestamp(time.Now()).WithType(UserUpdateSshUsersResponseType)
+
+	err = serialPort.WriteJSON(e.Wrap(resp))
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "writing to serial port",
+			zap.String("response", fmt.Sprint(resp)),
+			zap.String("envelope", fmt.Sprint(e)))
+		return
+	}
+	h.lastProcessedSha = dataSha[:]
+}
+
+// processSettings stores declared settings, so ssh-keys handler applies them to users it creates later,
+// and reconciles users ssh-keys handler has already provisioned. Other declared users are reported as skipped.
+func processSettings(ctx context.Context, m settingsManager, data []byte) (res settingsResponse, err error) {
+	declared, err := parseUsersSettings(data)
+	if err != nil {
+		return res, err
+	}
+
+	if err = writeUsersSettings(ctx, keysCacheFs, settingsFile, declared); err != nil {
+		return res, err
+	}
+
+	users, err := cachedUsers(keysCacheFs, keysCacheFile)
+	if err != nil {
+		return res, err
+	}
+	for u := range declared {
+		if !contains(users, u) {
+			res.Skipped = append(res.Skipped, u)
+		}
+	}
+	sort.Strings(res.Skipped)
+
+	res.Users, err = reconcileUsers(ctx, keysCacheFs, m, declared, users)
+
+	return res, err
+}
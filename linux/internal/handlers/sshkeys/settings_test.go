@@ -0,0 +1,167 @@
+package sshkeys
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSettingsManager struct {
+	calls   []string
+	failOn  string
+	members map[string]bool
+}
+
+func (m *fakeSettingsManager) call(c string) error {
+	if c == m.failOn {
+		return errors.New("failed " + c)
+	}
+	m.calls = append(m.calls, c)
+
+	return nil
+}
+
+func (m *fakeSettingsManager) AddToGroup(username, group string) error {
+	return m.call("add " + username + " " + group)
+}
+
+func (m *fakeSettingsManager) RemoveFromGroup(username, group string) error {
+	return m.call("remove " + username + " " + group)
+}
+
+func (m *fakeSettingsManager) AddToAdministrators(username string) error {
+	return m.call("sudo " + username)
+}
+
+func (m *fakeSettingsManager) RemoveFromAdministrators(username string) error {
+	return m.call("nosudo " + username)
+}
+
+func (m *fakeSettingsManager) InGroup(username, group string) (bool, error) {
+	return m.members[username+" "+group], nil
+}
+
+func (m *fakeSettingsManager) IsAdministrator(username string) (bool, error) {
+	return m.members[username+" sudo"], nil
+}
+
+func (m *fakeSettingsManager) SetShell(username, shell string) error {
+	return m.call("shell " + username + " " + shell)
+}
+
+func TestReconcileSettings(t *testing.T) {
+	tests := []struct {
+		name      string
+		applied   UserSettings
+		declared  UserSettings
+		members   map[string]bool
+		wantCalls []string
+		want      UserSettings
+	}{
+		{
+			name:      "first apply",
+			declared:  UserSettings{Groups: []string{"docker"}, Sudo: true, Shell: "/bin/zsh"},
+			wantCalls: []string{"add alice docker", "sudo alice", "shell alice /bin/zsh"},
+			want:      UserSettings{Groups: []string{"docker"}, Sudo: true, Shell: "/bin/zsh"},
+		},
+		{
+			name:     "unchanged",
+			applied:  UserSettings{Groups: []string{"docker"}, Sudo: true, Shell: "/bin/zsh"},
+			declared: UserSettings{Groups: []string{"docker"}, Sudo: true, Shell: "/bin/zsh"},
+			want:     UserSettings{Groups: []string{"docker"}, Sudo: true, Shell: "/bin/zsh"},
+		},
+		{
+			name:      "revoked",
+			applied:   UserSettings{Groups: []string{"docker", "video"}, Sudo: true, Shell: "/bin/zsh"},
+			declared:  UserSettings{Groups: []string{"video"}},
+			wantCalls: []string{"remove alice docker", "nosudo alice"},
+			want:      UserSettings{Groups: []string{"video"}, Shell: "/bin/zsh"},
+		},
+		{
+			name:     "existing memberships are not recorded as granted",
+			declared: UserSettings{Groups: []string{"docker"}, Sudo: true},
+			members:  map[string]bool{"alice docker": true, "alice sudo": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &fakeSettingsManager{members: tt.members}
+
+			granted, changes, err := reconcileSettings(m, "alice", tt.applied, tt.declared)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCalls, m.calls)
+			assert.Len(t, changes, len(tt.wantCalls))
+			assert.Equal(t, tt.want, granted)
+		})
+	}
+}
+
+func useMemFs(t *testing.T) afero.Fs {
+	t.Helper()
+
+	orig := keysCacheFs
+	t.Cleanup(func() { keysCacheFs = orig })
+	keysCacheFs = afero.NewMemMapFs()
+
+	return keysCacheFs
+}
+
+func TestProcessSettings(t *testing.T) {
+	fs := useMemFs(t)
+	ctx := context.Background()
+
+	c := make(keysCache)
+	c.add(userKeys{name: "alice", keys: []sshKey{{User: "alice", Type: "ssh-ed25519", Data: testKeyData}}})
+	c.add(userKeys{name: "bob", keys: []sshKey{{User: "bob", Type: "ssh-ed25519", Data: testKeyData}}})
+	require.NoError(t, writeKeysCache(ctx, fs, keysCacheFile, c))
+
+	m := &fakeSettingsManager{}
+	res, err := processSettings(ctx, m, []byte(`{"alice": {"groups": ["docker"], "sudo": true}, "root": {"sudo": true}}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"add alice docker", "sudo alice"}, m.calls)
+	assert.Equal(t, []string{"root"}, res.Skipped)
+	assert.Equal(t, []settingsChange{{User: "alice", Changes: []string{"added to group docker", "granted sudo"}}}, res.Users)
+
+	// sudo is revoked once it is not declared anymore
+	m = &fakeSettingsManager{}
+	_, err = processSettings(ctx, m, []byte(`{"alice": {"groups": ["docker"]}}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"nosudo alice"}, m.calls)
+
+	// failed user is retried on next run
+	m = &fakeSettingsManager{failOn: "add bob video"}
+	res, err = processSettings(ctx, m, []byte(`{"bob": {"groups": ["video"]}}`))
+	assert.ErrorIs(t, err, ErrSettingsPartial)
+	require.Len(t, res.Users, 2)
+	assert.NotEmpty(t, res.Users[1].Error)
+
+	m = &fakeSettingsManager{}
+	_, err = processSettings(ctx, m, []byte(`{"bob": {"groups": ["video"]}}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"add bob video"}, m.calls)
+
+	// bob is not provisioned anymore, e.g. vanished and kept, so settings are revoked though still declared
+	delete(c, "bob")
+	require.NoError(t, writeKeysCache(ctx, fs, keysCacheFile, c))
+	m = &fakeSettingsManager{}
+	res, err = processSettings(ctx, m, []byte(`{"bob": {"groups": ["video"], "sudo": true}}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"remove bob video"}, m.calls)
+	assert.Equal(t, []string{"bob"}, res.Skipped)
+
+	applied, err := readUsersSettings(fs, appliedFile)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+
+	// membership user had before is kept once it is not declared anymore
+	m = &fakeSettingsManager{members: map[string]bool{"alice sudo": true}}
+	_, err = processSettings(ctx, m, []byte(`{"alice": {"sudo": true}}`))
+	require.NoError(t, err)
+	_, err = processSettings(ctx, m, []byte(`{"alice": {}}`))
+	require.NoError(t, err)
+	assert.Empty(t, m.calls)
+}
@@ -0,0 +1,233 @@
+package sshkeys
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/user"
+	"sort"
+
+	"github.com/spf13/afero"
+	"go.uber.org/zap"
+	"marketplace-yaga/linux/internal/persistance"
+	"marketplace-yaga/pkg/logger"
+)
+
+// DefaultAccountsFile lists accounts ssh-keys handler created, only those are locked or deleted when their users
+// disappear from metadata.
+const DefaultAccountsFile = "/opt/yandex-guest-agent/ssh-keys-accounts.json"
+
+// accountsFile is overridden in tests.
+var accountsFile = DefaultAccountsFile
+
+// VanishedPolicy tells what is done with account created by agent, when its user disappears from ssh-keys metadata.
+// Managed keys of vanished users are cleared with any policy.
+type VanishedPolicy string
+
+const (
+	// VanishedKeep leaves account as is.
+	VanishedKeep VanishedPolicy = "keep"
+	// VanishedLock locks account, it is unlocked if user appears in metadata again.
+	VanishedLock VanishedPolicy = "lock"
+	// VanishedDelete deletes account, home directory is kept.
+	VanishedDelete VanishedPolicy = "delete"
+)
+
+// VanishedPolicies lists known policies.
+var VanishedPolicies = []VanishedPolicy{VanishedKeep, VanishedLock, VanishedDelete}
+
+var ErrUnknownVanishedPolicy = errors.New("unknown vanished users policy")
+
+// ParseVanishedPolicy returns policy by its name.
+func ParseVanishedPolicy(s string) (VanishedPolicy, error) {
+	for _, p := range VanishedPolicies {
+		if string(p) == s {
+			return p, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %v", ErrUnknownVanishedPolicy, s)
+}
+
+type vanishedPolicyKey struct{}
+
+// NewVanishedPolicyContext stores vanished users policy in context.
+func NewVanishedPolicyContext(ctx context.Context, p VanishedPolicy) context.Context {
+	return context.WithValue(ctx, vanishedPolicyKey{}, p)
+}
+
+// vanishedPolicyFromContext returns policy stored in context, accounts are kept by default.
+func vanishedPolicyFromContext(ctx context.Context) VanishedPolicy {
+	if p, ok := ctx.Value(vanishedPolicyKey{}).(VanishedPolicy); ok {
+		return p
+	}
+
+	return VanishedKeep
+}
+
+// account is state of account created by agent.
+type account struct {
+	Locked bool `json:"locked,omitempty"`
+}
+
+// accounts maps usernames to accounts created by agent.
+type accounts map[string]account
+
+func readAccounts(fsys afero.Fs, file string) (accounts, error) {
+	a := make(accounts)
+	b, err := afero.ReadFile(fsys, file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &a); err != nil {
+		return nil, fmt.Errorf("failed to parse created accounts %v: %w", file, err)
+	}
+
+	return a, nil
+}
+
+func writeAccounts(ctx context.Context, fsys afero.Fs, file string, a accounts) error {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	return persistance.WriteFile(ctx, fsys, file, bytes.NewReader(b))
+}
+
+// accountManager changes accounts of vanished users.
+type accountManager interface {
+	Lookup(username string) (*user.User, error)
+	SyncSshKeys(u *user.User, keys []string) (added, removed []string, err error)
+	LockUser(username string) error
+	UnlockUser(username string) error
+	DeleteUser(username string, removeHome bool) error
+}
+
+// accountChange lists actions taken on account of user, which disappeared from metadata or appeared again.
+type accountChange struct {
+	User    string   `json:"user"`
+	Actions []string `json:"actions"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// reconcileVanished clears managed keys of previously provisioned users, which have no valid keys now,
+// and applies policy to created accounts of users, which are not mentioned in metadata at all.
+// Created accounts, which were locked and appeared in metadata again, are unlocked.
+// Returns usernames of deleted accounts.
+func reconcileVanished(ctx context.Context, m accountManager, p VanishedPolicy, created accounts,
+	previous []string, provisioned keysCache, mentioned map[string]bool) ([]accountChange, []string, error) {
+	candidates := make(map[string]bool)
+	for _, u := range previous {
+		candidates[u] = true
+	}
+	for u := range created {
+		candidates[u] = true
+	}
+	names := make([]string, 0, len(candidates))
+	for u := range candidates {
+		names = append(names, u)
+	}
+	sort.Strings(names)
+
+	var res []accountChange
+	var deleted []string
+	var failed bool
+	for _, name := range names {
+		var actions []string
+		var err error
+		if _, ok := provisioned[name]; ok {
+			actions, err = reappear(m, created, name)
+		} else {
+			actions, err = vanish(m, p, created, name, !mentioned[name])
+		}
+		if contains(actions, "deleted") {
+			deleted = append(deleted, name)
+		}
+		if err != nil {
+			logger.ErrorCtx(ctx, err, "reconciled vanished ssh-keys user",
+				zap.String("username", name))
+			res = append(res, accountChange{User: name, Actions: actions, Error: err.Error()})
+			failed = true
+			continue
+		}
+		if len(actions) > 0 {
+			res = append(res, accountChange{User: name, Actions: actions})
+		}
+	}
+	if failed {
+		return res, deleted, ErrVanishedPartial
+	}
+
+	return res, deleted, nil
+}
+
+var ErrVanishedPartial = errors.New("some vanished users were not reconciled")
+
+// reappear unlocks created account, which agent locked, when its user disappeared.
+func reappear(m accountManager, created accounts, name string) ([]string, error) {
+	a, ok := created[name]
+	if !ok || !a.Locked {
+		return nil, nil
+	}
+
+	// LockUser both locked password and expired account, so both are undone
+	if err := m.UnlockUser(name); err != nil {
+		return nil, err
+	}
+	a.Locked = false
+	created[name] = a
+
+	return []string{"unlocked"}, nil
+}
+
+// vanish clears managed keys of user and, if user is gone from metadata, applies policy to account created by agent.
+func vanish(m accountManager, p VanishedPolicy, created accounts, name string, gone bool) ([]string, error) {
+	u, err := m.Lookup(name)
+	if err != nil {
+		// account was removed outside of agent
+		delete(created, name)
+		return nil, nil
+	}
+
+	var actions []string
+	_, removed, err := m.SyncSshKeys(u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(removed) > 0 {
+		actions = append(actions, "keys cleared")
+	}
+
+	a, ok := created[name]
+	if !ok || !gone {
+		return actions, nil
+	}
+
+	switch p {
+	case VanishedLock:
+		if a.Locked {
+			break
+		}
+		if err = m.LockUser(name); err != nil {
+			return actions, err
+		}
+		a.Locked = true
+		created[name] = a
+		actions = append(actions, "locked")
+	case VanishedDelete:
+		if err = m.DeleteUser(name, false); err != nil {
+			return actions, err
+		}
+		delete(created, name)
+		actions = append(actions, "deleted")
+	}
+
+	return actions, nil
+}
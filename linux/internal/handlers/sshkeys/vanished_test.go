@@ -0,0 +1,163 @@
+package sshkeys
+
+import (
+	"context"
+	"errors"
+	"os/user"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAccountManager struct {
+	calls    []string
+	existing map[string]bool
+	// keys are managed keys of users, which are revoked by sync
+	keys   map[string][]string
+	failOn string
+}
+
+func (m *fakeAccountManager) call(c string) error {
+	if c == m.failOn {
+		return errors.New("failed " + c)
+	}
+	m.calls = append(m.calls, c)
+
+	return nil
+}
+
+func (m *fakeAccountManager) Lookup(username string) (*user.User, error) {
+	if !m.existing[username] {
+		return nil, user.UnknownUserError(username)
+	}
+
+	return &user.User{Username: username}, nil
+}
+
+func (m *fakeAccountManager) SyncSshKeys(u *user.User, keys []string) (added, removed []string, err error) {
+	removed = m.keys[u.Username]
+	delete(m.keys, u.Username)
+
+	return nil, removed, m.call("sync " + u.Username)
+}
+
+func (m *fakeAccountManager) LockUser(username string) error {
+	return m.call("lock " + username)
+}
+
+func (m *fakeAccountManager) UnlockUser(username string) error {
+	return m.call("unlock " + username)
+}
+
+func (m *fakeAccountManager) DeleteUser(username string, removeHome bool) error {
+	return m.call("delete " + username)
+}
+
+func TestParseVanishedPolicy(t *testing.T) {
+	p, err := ParseVanishedPolicy("lock")
+	require.NoError(t, err)
+	assert.Equal(t, VanishedLock, p)
+
+	_, err = ParseVanishedPolicy("purge")
+	assert.ErrorIs(t, err, ErrUnknownVanishedPolicy)
+}
+
+func TestReconcileVanished(t *testing.T) {
+	ctx := context.Background()
+	provisioned := keysCache{"alice": {{Key: "ssh-ed25519 A"}}}
+
+	tests := []struct {
+		name        string
+		policy      VanishedPolicy
+		created     accounts
+		mentioned   map[string]bool
+		wantCalls   []string
+		wantChanges []accountChange
+		wantCreated accounts
+		wantDeleted []string
+	}{
+		{
+			name:        "keep",
+			policy:      VanishedKeep,
+			created:     accounts{"bob": {}},
+			wantCalls:   []string{"sync bob", "sync carol"},
+			wantChanges: []accountChange{{User: "bob", Actions: []string{"keys cleared"}}, {User: "carol", Actions: []string{"keys cleared"}}},
+			wantCreated: accounts{"bob": {}},
+		},
+		{
+			name:      "lock created account only",
+			policy:    VanishedLock,
+			created:   accounts{"bob": {}},
+			wantCalls: []string{"sync bob", "lock bob", "sync carol"},
+			wantChanges: []accountChange{
+				{User: "bob", Actions: []string{"keys cleared", "locked"}},
+				{User: "carol", Actions: []string{"keys cleared"}},
+			},
+			wantCreated: accounts{"bob": {Locked: true}},
+		},
+		{
+			name:      "delete created account",
+			policy:    VanishedDelete,
+			created:   accounts{"bob": {}},
+			wantCalls: []string{"sync bob", "delete bob", "sync carol"},
+			wantChanges: []accountChange{
+				{User: "bob", Actions: []string{"keys cleared", "deleted"}},
+				{User: "carol", Actions: []string{"keys cleared"}},
+			},
+			wantCreated: accounts{},
+			wantDeleted: []string{"bob"},
+		},
+		{
+			name:        "user with expired keys is not gone",
+			policy:      VanishedDelete,
+			created:     accounts{"bob": {}},
+			mentioned:   map[string]bool{"bob": true},
+			wantCalls:   []string{"sync bob", "sync carol"},
+			wantChanges: []accountChange{{User: "bob", Actions: []string{"keys cleared"}}, {User: "carol", Actions: []string{"keys cleared"}}},
+			wantCreated: accounts{"bob": {}},
+		},
+		{
+			name:      "locked account is unlocked when user is back",
+			policy:    VanishedLock,
+			created:   accounts{"alice": {Locked: true}, "dave": {}},
+			wantCalls: []string{"unlock alice", "sync carol"},
+			wantChanges: []accountChange{
+				{User: "alice", Actions: []string{"unlocked"}},
+				{User: "carol", Actions: []string{"keys cleared"}},
+			},
+			wantCreated: accounts{"alice": {}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &fakeAccountManager{
+				existing: map[string]bool{"alice": true, "bob": true, "carol": true},
+				keys:     map[string][]string{"bob": {"ssh-ed25519 B"}, "carol": {"ssh-ed25519 C"}},
+			}
+
+			changes, deleted, err := reconcileVanished(ctx, m, tt.policy, tt.created, []string{"alice", "carol"}, provisioned, tt.mentioned)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCalls, m.calls)
+			assert.Equal(t, tt.wantChanges, changes)
+			assert.Equal(t, tt.wantCreated, tt.created)
+			assert.Equal(t, tt.wantDeleted, deleted)
+		})
+	}
+}
+
+func TestReconcileVanished_Partial(t *testing.T) {
+	m := &fakeAccountManager{
+		existing: map[string]bool{"bob": true, "carol": true},
+		failOn:   "lock bob",
+	}
+	created := accounts{"bob": {}, "carol": {}}
+
+	changes, _, err := reconcileVanished(context.Background(), m, VanishedLock, created, nil, keysCache{}, nil)
+	assert.ErrorIs(t, err, ErrVanishedPartial)
+	require.Len(t, changes, 2)
+	assert.NotEmpty(t, changes[0].Error)
+	assert.Equal(t, accountChange{User: "carol", Actions: []string{"locked"}}, changes[1])
+	// failed account is retried on next run
+	assert.Equal(t, accounts{"bob": {}, "carol": {Locked: true}}, created)
+}
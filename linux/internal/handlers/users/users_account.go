@@ -0,0 +1,211 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"marketplace-yaga/linux/internal/usermanager"
+	"marketplace-yaga/pkg/logger"
+	"marketplace-yaga/pkg/messages"
+	"os/user"
+
+	"go.uber.org/zap"
+)
+
+const (
+	UserLockRequestType    = "UserLockRequest"
+	UserLockResponseType   = "UserLockResponse"
+	UserDeleteRequestType  = "UserDeleteRequest"
+	UserDeleteResponseType = "UserDeleteResponse"
+)
+
+// What to do with home directory of deleted user.
+const (
+	HomeKeep    = "keep"
+	HomeArchive = "archive"
+	HomeRemove  = "remove"
+)
+
+// ErrUnknownHomeAction is returned if delete request asks for unknown home directory action.
+var ErrUnknownHomeAction = errors.New("unknown home directory action")
+
+// lockRequest is struct of json passed from metadata to lock user account.
+type lockRequest struct {
+	Username      string
+	Expires       int64
+	RevokeSshKeys bool
+}
+
+// deleteRequest is struct of json passed from metadata to delete user account.
+type deleteRequest struct {
+	Username      string
+	Expires       int64
+	RevokeSshKeys bool
+	// Home is one of HomeKeep, HomeArchive, HomeRemove, empty means HomeKeep.
+	Home string
+}
+
+// accountResponse is struct which passed to COM port as result of lock or delete request.
+type accountResponse struct {
+	Username       string
+	Success        bool
+	Error          string
+	RevokedSshKeys bool
+	// HomeArchive is path of archive with home directory of deleted user.
+	HomeArchive string `json:",omitempty"`
+}
+
+// withError add error string to resulting response.
+func (res *accountResponse) withError(e error) *accountResponse {
+	res.Error = e.Error()
+
+	return res
+}
+
+// withSuccess changes Success field of resulting response to true.
+func (res *accountResponse) withSuccess() *accountResponse {
+	res.Success = true
+
+	return res
+}
+
+// accountManagerProvider is an interface that describes needed methods to lock or delete users.
+type accountManagerProvider interface {
+	ValidateUser(username string) error
+	Lookup(username string) (*user.User, error)
+	LockUser(username string) error
+	DeleteUser(username string, removeHome bool) error
+	ArchiveHome(u *user.User) (string, error)
+	RevokeSshKeys(u *user.User) (bool, error)
+}
+
+// newAccountManager is a global wrapped function for mocking in tests.
+var newAccountManager = func(ctx context.Context) accountManagerProvider {
+	return usermanager.New(ctx)
+}
+
+// processLockRequest unmarshalls passed data in lockRequest, checks it same way as password reset and locks user.
+//
+//nolint:nakedret
+func processLockRequest(ctx context.Context, data []byte) (res accountResponse, err error) {
+	defer func() {
+		if err != nil {
+			res.withError(err)
+		}
+	}()
+
+	var req lockRequest
+	err = messages.UnmarshalPayload(data, &req)
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "unmarshal lock request from message payload")
+		return
+	}
+	res.Username = req.Username
+
+	err = checkRequest(ctx, req, req.Expires)
+	if err != nil {
+		return
+	}
+
+	am := newAccountManager(ctx)
+	err = am.ValidateUser(req.Username)
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "validated user", zap.String("username", req.Username))
+		return
+	}
+
+	var u *user.User
+	u, err = am.Lookup(req.Username)
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "looked up user", zap.String("username", req.Username))
+		return
+	}
+
+	err = am.LockUser(req.Username)
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "locked user", zap.String("username", req.Username))
+		return
+	}
+
+	if req.RevokeSshKeys {
+		res.RevokedSshKeys, err = am.RevokeSshKeys(u)
+		if err != nil {
+			logger.ErrorCtx(ctx, err, "revoked ssh keys", zap.String("username", req.Username))
+			return
+		}
+	}
+	res.withSuccess()
+
+	return
+}
+
+// processDeleteRequest unmarshalls passed data in deleteRequest, checks it same way as password reset and deletes user.
+//
+//nolint:nakedret
+func processDeleteRequest(ctx context.Context, data []byte) (res accountResponse, err error) {
+	defer func() {
+		if err != nil {
+			res.withError(err)
+		}
+	}()
+
+	var req deleteRequest
+	err = messages.UnmarshalPayload(data, &req)
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "unmarshal delete request from message payload")
+		return
+	}
+	res.Username = req.Username
+
+	switch req.Home {
+	case "", HomeKeep, HomeArchive, HomeRemove:
+	default:
+		err = fmt.Errorf("%w: %v", ErrUnknownHomeAction, req.Home)
+		return
+	}
+
+	err = checkRequest(ctx, req, req.Expires)
+	if err != nil {
+		return
+	}
+
+	am := newAccountManager(ctx)
+	err = am.ValidateUser(req.Username)
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "validated user", zap.String("username", req.Username))
+		return
+	}
+
+	var u *user.User
+	u, err = am.Lookup(req.Username)
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "looked up user", zap.String("username", req.Username))
+		return
+	}
+
+	// keys are revoked first, as with kept home directory they would be still usable by account with same name
+	if req.RevokeSshKeys {
+		res.RevokedSshKeys, err = am.RevokeSshKeys(u)
+		if err != nil {
+			logger.ErrorCtx(ctx, err, "revoked ssh keys", zap.String("username", req.Username))
+			return
+		}
+	}
+
+	if req.Home == HomeArchive {
+		res.HomeArchive, err = am.ArchiveHome(u)
+		if err != nil {
+			logger.ErrorCtx(ctx, err, "archived home directory", zap.String("username", req.Username))
+			return
+		}
+	}
+
+	err = am.DeleteUser(req.Username, req.Home == HomeArchive || req.Home == HomeRemove)
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "deleted user", zap.String("username", req.Username))
+		return
+	}
+	res.withSuccess()
+
+	return
+}
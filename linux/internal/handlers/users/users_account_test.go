@@ -0,0 +1,151 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"marketplace-yaga/linux/internal/plan"
+	"marketplace-yaga/pkg/messages"
+	"os/user"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeAccountManager struct {
+	calls []string
+}
+
+func (m *fakeAccountManager) ValidateUser(username string) error {
+	if username == "root" {
+		return errors.New("restricted")
+	}
+
+	return nil
+}
+
+func (m *fakeAccountManager) Lookup(username string) (*user.User, error) {
+	return &user.User{Username: username, HomeDir: "/home/" + username}, nil
+}
+
+func (m *fakeAccountManager) LockUser(string) error {
+	m.calls = append(m.calls, "lock")
+
+	return nil
+}
+
+func (m *fakeAccountManager) DeleteUser(_ string, removeHome bool) error {
+	if removeHome {
+		m.calls = append(m.calls, "delete+home")
+	} else {
+		m.calls = append(m.calls, "delete")
+	}
+
+	return nil
+}
+
+func (m *fakeAccountManager) ArchiveHome(u *user.User) (string, error) {
+	m.calls = append(m.calls, "archive")
+
+	return "/archive/" + u.Username + ".tar.gz", nil
+}
+
+func (m *fakeAccountManager) RevokeSshKeys(*user.User) (bool, error) {
+	m.calls = append(m.calls, "revoke")
+
+	return true, nil
+}
+
+func withFakeAccountManager(t *testing.T) *fakeAccountManager {
+	t.Helper()
+
+	m := &fakeAccountManager{}
+	orig := newAccountManager
+	newAccountManager = func(context.Context) accountManagerProvider { return m }
+	t.Cleanup(func() { newAccountManager = orig })
+
+	return m
+}
+
+func accountMessage(t *testing.T, typ string, payload interface{}) []byte {
+	t.Helper()
+
+	d, err := messages.NewEnvelope().WithType(typ).Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+func TestProcessLockRequest(t *testing.T) {
+	m := withFakeAccountManager(t)
+	// plan mode keeps idempotency file untouched
+	ctx := plan.NewContext(context.Background(), plan.NewRecorder())
+
+	d := accountMessage(t, UserLockRequestType, lockRequest{Username: "alice", Expires: time.Now().Unix(), RevokeSshKeys: true})
+	res, err := processLockRequest(ctx, d)
+	if err != nil {
+		t.Fatalf("processLockRequest() error = %v", err)
+	}
+	if !res.Success || !res.RevokedSshKeys {
+		t.Errorf("processLockRequest() = %+v", res)
+	}
+	if want := []string{"lock", "revoke"}; !reflect.DeepEqual(m.calls, want) {
+		t.Errorf("calls = %v, want %v", m.calls, want)
+	}
+}
+
+func TestProcessDeleteRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		req       deleteRequest
+		wantCalls []string
+		wantErr   error
+	}{
+		{
+			name:      "keep home",
+			req:       deleteRequest{Username: "alice"},
+			wantCalls: []string{"delete"},
+		},
+		{
+			name:      "archive home and revoke keys",
+			req:       deleteRequest{Username: "alice", Home: HomeArchive, RevokeSshKeys: true},
+			wantCalls: []string{"revoke", "archive", "delete+home"},
+		},
+		{
+			name:      "remove home",
+			req:       deleteRequest{Username: "alice", Home: HomeRemove},
+			wantCalls: []string{"delete+home"},
+		},
+		{
+			name:    "unknown home action",
+			req:     deleteRequest{Username: "alice", Home: "move"},
+			wantErr: ErrUnknownHomeAction,
+		},
+		{
+			name:    "expired",
+			req:     deleteRequest{Username: "alice", Expires: time.Now().Add(-time.Hour).Unix()},
+			wantErr: ErrTimeFrame,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := withFakeAccountManager(t)
+			ctx := plan.NewContext(context.Background(), plan.NewRecorder())
+
+			if tt.req.Expires == 0 {
+				tt.req.Expires = time.Now().Unix()
+			}
+			res, err := processDeleteRequest(ctx, accountMessage(t, UserDeleteRequestType, tt.req))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("processDeleteRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if res.Success != (tt.wantErr == nil) {
+				t.Errorf("processDeleteRequest() success = %v", res.Success)
+			}
+			if !reflect.DeepEqual(m.calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", m.calls, tt.wantCalls)
+			}
+		})
+	}
+}
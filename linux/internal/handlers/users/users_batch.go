@@ -0,0 +1,112 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"marketplace-yaga/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+// maxBatchSize limits number of users in single batch request.
+const maxBatchSize = 32
+
+// ErrInvalidBatch is returned if batch request is malformed, in that case no user is changed.
+var ErrInvalidBatch = errors.New("invalid batch request")
+
+// ErrBatchPartial is returned if some users in batch request were not processed.
+var ErrBatchPartial = errors.New("batch request partially failed")
+
+// batchUser is single user entry of batch request, each user has its own recipient key.
+type batchUser struct {
+	Modulus  string
+	Exponent string
+	Username string
+}
+
+// isBatch tells if request lists several users.
+func (req request) isBatch() bool {
+	return len(req.Users) > 0
+}
+
+// validateBatch checks batch request before any user is changed.
+func validateBatch(req request) error {
+	if len(req.Users) > maxBatchSize {
+		return fmt.Errorf("%w: %v users, at most %v allowed", ErrInvalidBatch, len(req.Users), maxBatchSize)
+	}
+
+	if req.Username != "" {
+		return fmt.Errorf("%w: Username must not be set along with Users", ErrInvalidBatch)
+	}
+
+	// recipient key is per user in batch, top-level one would be silently ignored
+	if req.Modulus != "" || req.Exponent != "" {
+		return fmt.Errorf("%w: Modulus and Exponent must not be set along with Users", ErrInvalidBatch)
+	}
+
+	seen := make(map[string]struct{}, len(req.Users))
+	for i, u := range req.Users {
+		if u.Username == "" {
+			return fmt.Errorf("%w: user #%v has empty username", ErrInvalidBatch, i)
+		}
+		if u.Modulus == "" || u.Exponent == "" {
+			return fmt.Errorf("%w: user %v has no recipient key", ErrInvalidBatch, u.Username)
+		}
+		if _, ok := seen[u.Username]; ok {
+			return fmt.Errorf("%w: user %v listed twice", ErrInvalidBatch, u.Username)
+		}
+		seen[u.Username] = struct{}{}
+	}
+
+	return nil
+}
+
+// entry converts batch user to single user request, sharing batch wide fields.
+func (u batchUser) entry(req request) request {
+	return request{
+		Modulus:        u.Modulus,
+		Exponent:       u.Exponent,
+		Username:       u.Username,
+		Expires:        req.Expires,
+		Schema:         req.Schema,
+		PasswordPolicy: req.PasswordPolicy,
+
+		ForcePasswordChange: req.ForcePasswordChange,
+		PasswordMaxAgeDays:  req.PasswordMaxAgeDays,
+		Reenable:            req.Reenable,
+	}
+}
+
+// changeOrCreateUsers processes every user of batch request, failure of one user does not stop others.
+// Returns per-user responses in order of request and ErrBatchPartial if any of users failed.
+func changeOrCreateUsers(ctx context.Context, userManager userManagerProvider, req request, policy passwordPolicy) ([]response, error) {
+	res := make([]response, 0, len(req.Users))
+	var failed []string
+
+	for _, u := range req.Users {
+		ureq := u.entry(req)
+
+		var ures response
+		ures.withRequest(ureq)
+
+		encPwd, rep, err := changeOrCreateUser(ctx, userManager, ureq, policy)
+		ures.withAccountReport(rep)
+		if err != nil {
+			logger.ErrorCtx(ctx, err, "changed or created user in batch",
+				zap.String("username", u.Username))
+			ures.withError(err)
+			failed = append(failed, u.Username)
+		} else {
+			ures.withSuccess().withEncryptedPassword(encPwd)
+		}
+
+		res = append(res, ures)
+	}
+
+	if len(failed) > 0 {
+		return res, fmt.Errorf("%w: %v of %v users failed: %v", ErrBatchPartial, len(failed), len(req.Users), failed)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,179 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"marketplace-yaga/linux/internal/usermanager"
+	"math/big"
+	"testing"
+)
+
+type fakeUserManager struct {
+	users     map[string]bool
+	failOn    string
+	passwords map[string]string
+	aging     map[string]usermanager.PasswordAging
+	states    map[string]usermanager.AccountState
+	changes   []string
+}
+
+func newFakeUserManager(existing ...string) *fakeUserManager {
+	m := &fakeUserManager{users: map[string]bool{}, passwords: map[string]string{}, aging: map[string]usermanager.PasswordAging{}}
+	for _, u := range existing {
+		m.users[u] = true
+	}
+
+	return m
+}
+
+func (m *fakeUserManager) Exist(username string) (bool, error) { return m.users[username], nil }
+
+func (m *fakeUserManager) SetPassword(username, password string) error {
+	if username == m.failOn {
+		return errors.New("chpasswd failed")
+	}
+	m.passwords[username] = password
+
+	return nil
+}
+
+func (m *fakeUserManager) SetPasswordAging(username string, aging usermanager.PasswordAging) error {
+	m.aging[username] = aging
+
+	return nil
+}
+
+func (m *fakeUserManager) AccountState(username string) (usermanager.AccountState, error) {
+	st, ok := m.states[username]
+	if !ok {
+		return st, usermanager.ErrUserNotFound
+	}
+
+	return st, nil
+}
+
+func (m *fakeUserManager) ClearExpiry(username string) error {
+	m.changes = append(m.changes, "clear-expiry:"+username)
+
+	return nil
+}
+
+func (m *fakeUserManager) RestoreLoginShell(username string) (string, error) {
+	m.changes = append(m.changes, "set-shell:"+username)
+
+	return "/bin/bash", nil
+}
+
+func (m *fakeUserManager) ValidateUser(username string) error {
+	if username == "root" {
+		return usermanager.ErrRestrictedUser
+	}
+
+	return nil
+}
+
+func (m *fakeUserManager) CreateUser(username string) error {
+	m.users[username] = true
+
+	return nil
+}
+
+func (m *fakeUserManager) AddToAdministrators(string) error { return nil }
+
+func testRecipient(t *testing.T) batchUser {
+	t.Helper()
+
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return batchUser{
+		Modulus:  base64.StdEncoding.EncodeToString(k.N.Bytes()),
+		Exponent: base64.StdEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+	}
+}
+
+func TestValidateBatch(t *testing.T) {
+	key := testRecipient(t)
+	user := func(name string) batchUser {
+		u := key
+		u.Username = name
+
+		return u
+	}
+
+	tests := []struct {
+		name    string
+		req     request
+		wantErr bool
+	}{
+		{name: "ok", req: request{Users: []batchUser{user("a"), user("b")}}},
+		{name: "duplicate", req: request{Users: []batchUser{user("a"), user("a")}}, wantErr: true},
+		{name: "empty username", req: request{Users: []batchUser{user("")}}, wantErr: true},
+		{name: "no key", req: request{Users: []batchUser{{Username: "a"}}}, wantErr: true},
+		{name: "mixed with single", req: request{Username: "a", Users: []batchUser{user("b")}}, wantErr: true},
+		{name: "top-level key", req: request{Modulus: key.Modulus, Exponent: key.Exponent, Users: []batchUser{user("b")}}, wantErr: true},
+		{name: "top-level exponent", req: request{Exponent: key.Exponent, Users: []batchUser{user("b")}}, wantErr: true},
+		{name: "too large", req: request{Users: make([]batchUser, maxBatchSize+1)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateBatch(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateBatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidBatch) {
+				t.Errorf("validateBatch() error = %v, want ErrInvalidBatch", err)
+			}
+		})
+	}
+}
+
+func TestChangeOrCreateUsers(t *testing.T) {
+	key := testRecipient(t)
+	req := request{Schema: SchemaV1, ForcePasswordChange: true, PasswordMaxAgeDays: 30}
+	for _, n := range []string{"alice", "bob", "carol"} {
+		u := key
+		u.Username = n
+		req.Users = append(req.Users, u)
+	}
+
+	um := newFakeUserManager("alice")
+	um.failOn = "bob"
+
+	res, err := changeOrCreateUsers(context.Background(), um, req, defaultPasswordPolicy)
+	if !errors.Is(err, ErrBatchPartial) {
+		t.Fatalf("changeOrCreateUsers() error = %v, want ErrBatchPartial", err)
+	}
+	if len(res) != 3 {
+		t.Fatalf("changeOrCreateUsers() got %v results, want 3", len(res))
+	}
+
+	for i, want := range []bool{true, false, true} {
+		if res[i].Success != want {
+			t.Errorf("user %v success = %v, want %v", res[i].Username, res[i].Success, want)
+		}
+		if want && res[i].EncryptedPassword == "" {
+			t.Errorf("user %v has no encrypted password", res[i].Username)
+		}
+		if !want && res[i].Error == "" {
+			t.Errorf("user %v has no error", res[i].Username)
+		}
+	}
+
+	if !um.users["carol"] {
+		t.Errorf("carol was not created after bob failed")
+	}
+
+	want := usermanager.PasswordAging{MustChange: true, MaxDays: 30}
+	if um.aging["carol"] != want {
+		t.Errorf("carol password aging = %+v, want %+v", um.aging["carol"], want)
+	}
+	if _, ok := um.aging["bob"]; ok {
+		t.Errorf("bob password aging set, though password was not")
+	}
+}
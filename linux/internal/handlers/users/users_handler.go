@@ -9,13 +9,15 @@ import (
 	"errors"
 	"fmt"
 	"github.com/spf13/afero"
+	"marketplace-yaga/linux/internal/audit"
+	"marketplace-yaga/linux/internal/plan"
 	"marketplace-yaga/linux/internal/usermanager"
 	"marketplace-yaga/pkg/logger"
 	"marketplace-yaga/pkg/messages"
+	"marketplace-yaga/pkg/meta"
 	"marketplace-yaga/pkg/passwords"
 	"marketplace-yaga/pkg/serial"
 	"math/big"
-	"os"
 	"runtime"
 	"runtime/debug"
 	"time"
@@ -38,7 +40,7 @@ var ErrTimeFrame = errors.New("request timestamp is out of allowed timeframe")
 // requestTimeframe is an interval from time.now() at which request will be considered valid.
 const requestTimeframe = time.Minute * 5
 
-// idempotencyFile is file path where we'll use store idempotency key.
+// idempotencyFile is file path of journal with hashes of processed requests.
 const idempotencyFile = `/opt/yandex-guest-agent/idempotency`
 
 // passwordLength is length of generated password.
@@ -81,7 +83,8 @@ func (h *UserHandle) String() string {
 	return handlerName
 }
 
-// Handle passes 'user change or creation' request to 'processRequest' function and writes result to serial port.
+// Handle passes request to function processing its type and writes result to serial port.
+// Request without known type is treated as 'user change or creation' request and passed to 'processRequest'.
 func (h *UserHandle) Handle(ctx context.Context, data []byte) {
 	err := ctx.Err()
 	if err != nil {
@@ -89,27 +92,50 @@ func (h *UserHandle) Handle(ctx context.Context, data []byte) {
 		return
 	}
 
-	var resp response
-	resp, err = processRequest(ctx, data)
+	// unwrap to get envelope
+	var e *messages.Envelope
+	e, err = messages.UnmarshalEnvelope(data)
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "unwrap envelope from message")
+		return
+	}
+	ctx = audit.WithRequest(ctx, handlerName, e.ID)
+
+	var resp interface{}
+	var respType string
+	switch e.Type {
+	case UserLockRequestType:
+		resp, err = processLockRequest(ctx, data)
+		respType = UserLockResponseType
+	case UserDeleteRequestType:
+		resp, err = processDeleteRequest(ctx, data)
+		respType = UserDeleteResponseType
+	default:
+		resp, err = processRequest(ctx, data)
+		respType = UserChangeResponseType
+	}
 	if err != nil {
 		logger.ErrorCtx(ctx, err, "processed request")
 	}
 	// wont spam to serial port on equal requests
 	if errors.Is(err, ErrIdemp) {
+		if meta.IsForced(ctx) {
+			meta.SetResult(ctx, resp, err)
+		}
 		return
 	}
+	meta.SetResult(ctx, resp, err)
+	audit.Record(ctx, plan.Action{Kind: audit.HandleRequest, Target: e.Type}, err)
 
 	runtime.GC()
 	debug.FreeOSMemory()
 
-	// unwrap to get envelope
-	var e *messages.Envelope
-	e, err = messages.UnmarshalEnvelope(data)
-	if err != nil {
-		logger.ErrorCtx(ctx, err, "unwrap envelope from message")
+	// nothing was changed in plan mode, so there is nothing to report
+	if plan.Enabled(ctx) {
 		return
 	}
-	e.WithTimestamp(time.Now()).WithType(UserChangeResponseType)
+
+	e.WithTimestamp(time.Now()).WithType(respType)
 
 	err = serialPort.WriteJSON(e.Wrap(resp))
 	if err != nil {
@@ -122,6 +148,7 @@ func (h *UserHandle) Handle(ctx context.Context, data []byte) {
 
 // processRequest unmarshalls passed data in request struct and checks  for validity.
 // If request is valid and idempotent (we save sha256 hash) we pass it further to changeOrCreateUser function.
+// Batch request is hashed as a whole, so all its users are processed at most once.
 //
 //nolint:nakedret
 func processRequest(ctx context.Context, data []byte) (res response, err error) {
@@ -145,38 +172,52 @@ func processRequest(ctx context.Context, data []byte) (res response, err error)
 	}
 	res.withRequest(req)
 
-	rm := NewRequestManager(afero.NewOsFs())
-	var hash string
-	hash, err = rm.GetSHA256(req)
-	if err != nil {
-		logger.ErrorCtx(ctx, err, "hashed request")
-		return
+	if req.isBatch() {
+		err = validateBatch(req)
+		if err != nil {
+			logger.ErrorCtx(ctx, err, "validated batch request")
+			return
+		}
 	}
 
-	err = rm.ValidateRequestHash(hash)
+	err = checkRequest(ctx, req, req.Expires)
 	if err != nil {
-		logger.ErrorCtx(ctx, err, "checked request hash for idempotency", zap.String("hash", hash))
 		return
 	}
 
-	err = os.WriteFile(idempotencyFile, []byte(hash), 0600)
+	var policy passwordPolicy
+	policy, err = resolvePasswordPolicy(req)
 	if err != nil {
-		logger.ErrorCtx(ctx, err, "saved request hash to file",
-			zap.String("idempotencyFile", idempotencyFile),
-			zap.String("hash", hash))
+		logger.ErrorCtx(ctx, err, "resolved password policy",
+			zap.String("schema", req.Schema))
 		return
 	}
+	res.withPasswordPolicy(policy)
 
-	err = rm.ValidateRequestTimestamp(req.Expires)
+	err = req.passwordAging().Validate()
 	if err != nil {
-		logger.ErrorCtx(ctx, err, "validated user request timestamp",
-			zap.String("request", fmt.Sprint(req)))
+		logger.ErrorCtx(ctx, err, "validated password aging")
 		return
 	}
 
 	um := usermanager.New(ctx)
+	if req.isBatch() {
+		var users []response
+		users, err = changeOrCreateUsers(ctx, um, req, policy)
+		res.withUsers(users)
+		if err != nil {
+			logger.ErrorCtx(ctx, err, "changed or created users in batch")
+			return
+		}
+		res.withSuccess()
+
+		return
+	}
+
 	var encPwd string
-	encPwd, err = changeOrCreateUser(ctx, um, req)
+	var rep accountReport
+	encPwd, rep, err = changeOrCreateUser(ctx, um, req, policy)
+	res.withAccountReport(rep)
 	if err != nil {
 		logger.ErrorCtx(ctx, err, "changed or created user",
 			zap.String("request", fmt.Sprint(req)))
@@ -187,26 +228,75 @@ func processRequest(ctx context.Context, data []byte) (res response, err error)
 	return
 }
 
+// checkRequest validates request timestamp and idempotency (sha256 hash is kept in journal till request expires).
+// Every request type passes that check before any change is made.
+// Timestamp is validated first, so stale request could not occupy journal.
+func checkRequest(ctx context.Context, req interface{}, expires int64) error {
+	rm := NewRequestManager(afero.NewOsFs())
+	hash, err := rm.GetSHA256(req)
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "hashed request")
+		return err
+	}
+
+	err = rm.ValidateRequestTimestamp(expires)
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "validated user request timestamp",
+			zap.String("request", fmt.Sprint(req)))
+		return err
+	}
+
+	// hash is checked and recorded at once, in plan mode it is only checked
+	if plan.Record(ctx, plan.Action{Kind: plan.WriteFile, Target: idempotencyFile, Detail: "request hash"}) {
+		err = rm.ValidateRequestHash(hash)
+	} else {
+		err = rm.RecordRequestHash(hash, expires)
+	}
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "checked and saved request hash to journal",
+			zap.String("idempotencyFile", idempotencyFile),
+			zap.String("hash", hash))
+		return err
+	}
+
+	return nil
+}
+
 // userManagerProvider is an interface that describes needed methods to manage users.
 type userManagerProvider interface {
+	ValidateUser(username string) error
 	Exist(username string) (bool, error)
 	SetPassword(username, password string) (err error)
+	SetPasswordAging(username string, aging usermanager.PasswordAging) error
+	AccountState(username string) (usermanager.AccountState, error)
+	ClearExpiry(username string) error
+	RestoreLoginShell(username string) (string, error)
 	CreateUser(username string) error
 	AddToAdministrators(username string) error
 }
 
 // changeOrCreateUser creates local user if one in request could not be found or resets password for existing one.
+// Password is generated according to policy, and is made temporary or expiring if request asks for.
+// State of existing account is reported, and account is re-enabled if request asks for.
 // As a result passes back encrypted password with the public provided in request. (via Modulus and Exponent).
 //
 //nolint:nakedret
-func changeOrCreateUser(ctx context.Context, userManager userManagerProvider, req request) (encPwd string, err error) {
+func changeOrCreateUser(ctx context.Context, userManager userManagerProvider, req request, policy passwordPolicy) (encPwd string, rep accountReport, err error) {
 	if err = ctx.Err(); err != nil {
 		logger.ErrorCtx(ctx, err, "checked deadline or context cancellation")
 		return
 	}
 
+	// account policy is checked before anything is changed
+	err = userManager.ValidateUser(req.Username)
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "validated user",
+			zap.String("username", req.Username))
+		return
+	}
+
 	var pwd string
-	pwd, err = pwdGen.Generate(passwordLength, passwordNumDigits, passwordNumSymbols, passwordNoUppers)
+	pwd, err = policy.generate()
 	if err != nil {
 		logger.ErrorCtx(ctx, err, "generated password")
 		return
@@ -230,12 +320,30 @@ func changeOrCreateUser(ctx context.Context, userManager userManagerProvider, re
 	}
 
 	if exist {
+		var st usermanager.AccountState
+		st, err = userManager.AccountState(req.Username)
+		if err != nil {
+			// accounts from other sources than local files could not be inspected, that is not fatal
+			logger.DebugCtx(ctx, err, "read account state",
+				zap.String("username", req.Username))
+			err = nil
+		} else {
+			rep.State = &st
+		}
+
 		err = userManager.SetPassword(req.Username, pwd)
 		if err != nil {
 			logger.ErrorCtx(ctx, err, "reset password",
 				zap.String("username", req.Username))
 			return
 		}
+
+		if rep.State != nil {
+			rep.Reenabled, err = reenableAccount(ctx, userManager, req, st)
+			if err != nil {
+				return
+			}
+		}
 	} else {
 		err = userManager.CreateUser(req.Username)
 		if err != nil {
@@ -259,6 +367,13 @@ func changeOrCreateUser(ctx context.Context, userManager userManagerProvider, re
 		}
 	}
 
+	err = userManager.SetPasswordAging(req.Username, req.passwordAging())
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "set password aging",
+			zap.String("username", req.Username))
+		return
+	}
+
 	return
 }
 
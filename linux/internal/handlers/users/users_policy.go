@@ -0,0 +1,165 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+	"marketplace-yaga/pkg/passwords"
+)
+
+// Known request schemas.
+const (
+	// SchemaV1 is legacy request, password is generated with agent defaults. Empty schema is treated as v1.
+	SchemaV1 = "v1"
+	// SchemaV2 allows console to request password policy.
+	SchemaV2 = "v2"
+)
+
+// ErrUnknownSchema is returned if request schema is not supported by agent.
+var ErrUnknownSchema = errors.New("unknown request schema")
+
+// ErrWeakPolicy is returned if requested password policy does not satisfy agent-side minimum.
+var ErrWeakPolicy = errors.New("requested password policy is weaker than allowed")
+
+// ErrPolicyOutOfRange is returned if requested password policy exceeds agent-side limits.
+var ErrPolicyOutOfRange = errors.New("requested password policy is out of allowed range")
+
+// minPasswordLength is agent-side minimum, console could only ask for longer passwords.
+const minPasswordLength = passwordLength
+
+// maxPasswordLength protects from unreasonable requests.
+const maxPasswordLength = uint(128)
+
+// Minimum number of characters, which must be left in pools used by password after exclusions.
+const (
+	minLettersPoolSize = 10
+	minDigitsPoolSize  = 5
+	minSymbolsPoolSize = 5
+)
+
+// passwordPolicy is set of password generation parameters.
+type passwordPolicy struct {
+	Length   uint
+	Digits   uint
+	Symbols  uint
+	NoUppers bool
+	// ExcludedChars are removed from every character pool, e.g. symbols not supported by application.
+	ExcludedChars string
+}
+
+// defaultPasswordPolicy is applied for v1 requests or v2 requests without policy.
+var defaultPasswordPolicy = passwordPolicy{
+	Length:   passwordLength,
+	Digits:   passwordNumDigits,
+	Symbols:  passwordNumSymbols,
+	NoUppers: passwordNoUppers,
+}
+
+// resolvePasswordPolicy returns password policy, which will be applied for request of given schema.
+func resolvePasswordPolicy(req request) (passwordPolicy, error) {
+	switch req.Schema {
+	case "", SchemaV1:
+		return defaultPasswordPolicy, nil
+	case SchemaV2:
+		if req.PasswordPolicy == nil {
+			return defaultPasswordPolicy, nil
+		}
+
+		p := *req.PasswordPolicy
+		if err := p.validate(); err != nil {
+			return passwordPolicy{}, err
+		}
+
+		return p, nil
+	default:
+		return passwordPolicy{}, fmt.Errorf("%w: %v", ErrUnknownSchema, req.Schema)
+	}
+}
+
+// validate checks policy against agent-side minimum.
+func (p passwordPolicy) validate() error {
+	if p.Length < minPasswordLength {
+		return fmt.Errorf("%w: length %v is less than %v", ErrWeakPolicy, p.Length, minPasswordLength)
+	}
+
+	if p.Length > maxPasswordLength {
+		return fmt.Errorf("%w: length %v is greater than %v", ErrPolicyOutOfRange, p.Length, maxPasswordLength)
+	}
+
+	if p.Digits+p.Symbols > p.Length {
+		return fmt.Errorf("%w: %v", ErrWeakPolicy, passwords.ErrLengthTooShort)
+	}
+
+	return p.validateClasses()
+}
+
+// validateClasses checks that password has at least as many characters of each class as default one,
+// so console could ask for stronger passwords only.
+func (p passwordPolicy) validateClasses() error {
+	min := defaultPasswordPolicy
+	if p.Digits < min.Digits {
+		return fmt.Errorf("%w: %v digits is less than %v", ErrWeakPolicy, p.Digits, min.Digits)
+	}
+	if p.Symbols < min.Symbols {
+		return fmt.Errorf("%w: %v symbols is less than %v", ErrWeakPolicy, p.Symbols, min.Symbols)
+	}
+	if p.letters() < min.letters() {
+		return fmt.Errorf("%w: %v letters is less than %v", ErrWeakPolicy, p.letters(), min.letters())
+	}
+	if p.NoUppers && !min.NoUppers {
+		return fmt.Errorf("%w: upper letters could not be disabled", ErrWeakPolicy)
+	}
+
+	return p.validatePools()
+}
+
+// letters returns number of letters in password, policy must have no more digits and symbols than its length.
+func (p passwordPolicy) letters() uint {
+	return p.Length - p.Digits - p.Symbols
+}
+
+// validatePools checks that excluded characters leave enough characters in pools used by password.
+func (p passwordPolicy) validatePools() error {
+	if p.ExcludedChars == "" {
+		return nil
+	}
+
+	g, ok := p.generator().(*passwords.Generator)
+	if !ok {
+		return nil
+	}
+
+	lower, upper, digits, symbols := g.PoolSizes()
+	pools := []struct {
+		name      string
+		used      bool
+		size, min int
+	}{
+		{"lower letters", p.Length > p.Digits+p.Symbols, lower, minLettersPoolSize},
+		{"upper letters", p.Length > p.Digits+p.Symbols && !p.NoUppers, upper, minLettersPoolSize},
+		{"digits", p.Digits > 0, digits, minDigitsPoolSize},
+		{"symbols", p.Symbols > 0, symbols, minSymbolsPoolSize},
+	}
+	for _, pool := range pools {
+		if pool.used && pool.size < pool.min {
+			return fmt.Errorf("%w: only %v %v left after exclusions, at least %v required",
+				ErrWeakPolicy, pool.size, pool.name, pool.min)
+		}
+	}
+
+	return nil
+}
+
+// generator returns password generator for policy.
+func (p passwordPolicy) generator() passwords.GeneratorInterface {
+	if p.ExcludedChars == "" {
+		return pwdGen
+	}
+
+	return passwords.NewGeneratorExcluding(passwordLowerLetters, passwordUpperLetters, passwordDigits, passwordSymbols,
+		p.ExcludedChars)
+}
+
+// generate creates password satisfying policy.
+func (p passwordPolicy) generate() (string, error) {
+	return p.generator().Generate(p.Length, p.Digits, p.Symbols, p.NoUppers)
+}
@@ -0,0 +1,113 @@
+package users
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestResolvePasswordPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     request
+		want    passwordPolicy
+		wantErr error
+	}{
+		{
+			name: "empty schema",
+			req:  request{},
+			want: defaultPasswordPolicy,
+		},
+		{
+			name: "v1 ignores policy",
+			req:  request{Schema: SchemaV1, PasswordPolicy: &passwordPolicy{Length: 64}},
+			want: defaultPasswordPolicy,
+		},
+		{
+			name: "v2 without policy",
+			req:  request{Schema: SchemaV2},
+			want: defaultPasswordPolicy,
+		},
+		{
+			name: "v2 with policy",
+			req:  request{Schema: SchemaV2, PasswordPolicy: &passwordPolicy{Length: 32, Digits: 4, Symbols: 6, ExcludedChars: "`'\""}},
+			want: passwordPolicy{Length: 32, Digits: 4, Symbols: 6, ExcludedChars: "`'\""},
+		},
+		{
+			name:    "v2 too short",
+			req:     request{Schema: SchemaV2, PasswordPolicy: &passwordPolicy{Length: 8}},
+			wantErr: ErrWeakPolicy,
+		},
+		{
+			name:    "v2 too long",
+			req:     request{Schema: SchemaV2, PasswordPolicy: &passwordPolicy{Length: 1024}},
+			wantErr: ErrPolicyOutOfRange,
+		},
+		{
+			name:    "v2 too many classes",
+			req:     request{Schema: SchemaV2, PasswordPolicy: &passwordPolicy{Length: 16, Digits: 10, Symbols: 10}},
+			wantErr: ErrWeakPolicy,
+		},
+		{
+			name:    "v2 digits excluded",
+			req:     request{Schema: SchemaV2, PasswordPolicy: &passwordPolicy{Length: 16, Digits: 3, Symbols: 5, ExcludedChars: "012345"}},
+			wantErr: ErrWeakPolicy,
+		},
+		{
+			name:    "v2 letters excluded",
+			req:     request{Schema: SchemaV2, PasswordPolicy: &passwordPolicy{Length: 16, Digits: 3, Symbols: 5, ExcludedChars: "abcdefghijklmnopqrst"}},
+			wantErr: ErrWeakPolicy,
+		},
+		{
+			name:    "v2 lower letters only",
+			req:     request{Schema: SchemaV2, PasswordPolicy: &passwordPolicy{Length: 32, NoUppers: true}},
+			wantErr: ErrWeakPolicy,
+		},
+		{
+			name:    "v2 fewer digits than default",
+			req:     request{Schema: SchemaV2, PasswordPolicy: &passwordPolicy{Length: 32, Digits: 2, Symbols: 5}},
+			wantErr: ErrWeakPolicy,
+		},
+		{
+			name:    "v2 no uppers",
+			req:     request{Schema: SchemaV2, PasswordPolicy: &passwordPolicy{Length: 32, Digits: 3, Symbols: 5, NoUppers: true}},
+			wantErr: ErrWeakPolicy,
+		},
+		{
+			name:    "v2 fewer letters than default",
+			req:     request{Schema: SchemaV2, PasswordPolicy: &passwordPolicy{Length: 15, Digits: 3, Symbols: 6}},
+			wantErr: ErrWeakPolicy,
+		},
+		{
+			name:    "unknown schema",
+			req:     request{Schema: "v3"},
+			wantErr: ErrUnknownSchema,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolvePasswordPolicy(tt.req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("resolvePasswordPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("resolvePasswordPolicy() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicy_Generate(t *testing.T) {
+	p := passwordPolicy{Length: 40, Digits: 5, Symbols: 5, ExcludedChars: "!@#$%"}
+
+	pwd, err := p.generate()
+	if err != nil {
+		t.Fatalf("generate() error = %v", err)
+	}
+	if len(pwd) != 40 {
+		t.Errorf("generate() length = %v, want 40", len(pwd))
+	}
+	if strings.ContainsAny(pwd, p.ExcludedChars) {
+		t.Errorf("generate() = %v, contains excluded chars %v", pwd, p.ExcludedChars)
+	}
+}
@@ -5,9 +5,10 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/gob"
+	"errors"
 	"github.com/spf13/afero"
-	"io"
-	"io/fs"
+	"marketplace-yaga/linux/internal/usermanager"
+	"marketplace-yaga/pkg/idempotency"
 	"time"
 )
 
@@ -21,6 +22,25 @@ type request struct {
 	Username string
 	Expires  int64
 	Schema   string
+	// PasswordPolicy is honored since SchemaV2.
+	PasswordPolicy *passwordPolicy `json:",omitempty"`
+	// ForcePasswordChange makes generated password temporary, user must change it on next login.
+	ForcePasswordChange bool `json:",omitempty"`
+	// PasswordMaxAgeDays is number of days password stays valid, 0 keeps current value, -1 removes expiry.
+	PasswordMaxAgeDays int `json:",omitempty"`
+	// Reenable asks to fix expired account and nologin shell of existing user.
+	Reenable bool `json:",omitempty"`
+	// Users turns request into batch one, Modulus, Exponent and Username must be empty then.
+	// Whole batch is single request for timestamp and idempotency checks.
+	Users []batchUser `json:",omitempty"`
+}
+
+// passwordAging returns password aging requested.
+func (req request) passwordAging() usermanager.PasswordAging {
+	return usermanager.PasswordAging{
+		MustChange: req.ForcePasswordChange,
+		MaxDays:    req.PasswordMaxAgeDays,
+	}
 }
 
 type RequestManager struct {
@@ -52,30 +72,32 @@ func (r *RequestManager) GetSHA256(v interface{}) (s string, err error) {
 	return
 }
 
+// journal returns journal of processed requests.
+func (r *RequestManager) journal() *idempotency.Journal {
+	return idempotency.NewJournal(r.fs, idempotencyFile)
+}
+
 // ValidateRequestHash validates request hash.
 // We hash every request with sha256 to check if we already processed request.
 // That way we protect ourselves from situation in which something will accidentally pass same request again.
 func (r *RequestManager) ValidateRequestHash(reqHash string) error {
-	file, err := r.fs.Open(idempotencyFile)
-	// if property does not exist - request is also idempotent
-	if err != nil {
-		switch err.(type) {
-		case *fs.PathError:
-			return nil
-		default:
-			return err
-		}
-	}
-	content, err := io.ReadAll(file)
-	if err != nil {
-		return err
+	err := r.journal().Check(reqHash)
+	if errors.Is(err, idempotency.ErrReplay) {
+		return ErrIdemp
 	}
-	lastRequestHash := string(content)
-	if lastRequestHash != "" && lastRequestHash == reqHash {
+
+	return err
+}
+
+// RecordRequestHash stores request hash in journal, until request timestamp goes out of allowed timeframe.
+// Hash is validated under same lock, so request is accepted once, even if agent processes run concurrently.
+func (r *RequestManager) RecordRequestHash(reqHash string, expires int64) error {
+	err := r.journal().CheckAndRecord(reqHash, time.Unix(expires, 0).Add(requestTimeframe))
+	if errors.Is(err, idempotency.ErrReplay) {
 		return ErrIdemp
 	}
 
-	return nil
+	return err
 }
 
 // ValidateRequestTimestamp validates request timestamp.
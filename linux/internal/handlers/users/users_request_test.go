@@ -90,6 +90,26 @@ func TestRequestManager_ValidateRequestHash(t *testing.T) {
 		}
 	})
 
+	t.Run("alternating hashes", func(t *testing.T) {
+		r := &RequestManager{
+			fs: afero.NewMemMapFs(),
+		}
+		expires := time.Now().Unix()
+
+		for _, h := range []string{"123", "456"} {
+			if err := r.RecordRequestHash(h, expires); err != nil {
+				t.Fatalf("RecordRequestHash() error = %v", err)
+			}
+		}
+
+		if err := r.ValidateRequestHash("123"); !errors.Is(err, ErrIdemp) {
+			t.Errorf("ValidateRequestHash() error = %v, want %v", err, ErrIdemp)
+		}
+		if err := r.RecordRequestHash("456", expires); !errors.Is(err, ErrIdemp) {
+			t.Errorf("RecordRequestHash() error = %v, want %v", err, ErrIdemp)
+		}
+	})
+
 }
 
 func TestRequestManager_ValidateRequestTimestamp(t *testing.T) {
@@ -1,5 +1,7 @@
 package users
 
+import "marketplace-yaga/linux/internal/usermanager"
+
 const UserChangeResponseType = "UserChangeResponse"
 
 // response is struct which converted to json and passed to COM port as result of user_handle execution.
@@ -10,6 +12,18 @@ type response struct {
 	EncryptedPassword string
 	Success           bool
 	Error             string
+	Schema            string
+	// PasswordPolicy is policy which was applied to generate password.
+	PasswordPolicy *passwordPolicy `json:",omitempty"`
+	// ForcePasswordChange and PasswordMaxAgeDays echo password aging applied.
+	ForcePasswordChange bool `json:",omitempty"`
+	PasswordMaxAgeDays  int  `json:",omitempty"`
+	// AccountState is state of existing account found before password reset.
+	AccountState *usermanager.AccountState `json:",omitempty"`
+	// Reenabled lists conditions, which were fixed to let user log in.
+	Reenabled []string `json:",omitempty"`
+	// Users holds per-user results of batch request.
+	Users []response `json:",omitempty"`
 }
 
 // withRequest add request fields to resulting response.
@@ -17,6 +31,31 @@ func (res *response) withRequest(req request) *response {
 	res.Modulus = req.Modulus
 	res.Exponent = req.Exponent
 	res.Username = req.Username
+	res.Schema = req.Schema
+	res.ForcePasswordChange = req.ForcePasswordChange
+	res.PasswordMaxAgeDays = req.PasswordMaxAgeDays
+
+	return res
+}
+
+// withPasswordPolicy add applied password policy to resulting response.
+func (res *response) withPasswordPolicy(p passwordPolicy) *response {
+	res.PasswordPolicy = &p
+
+	return res
+}
+
+// withUsers add per-user results of batch request to resulting response.
+func (res *response) withUsers(users []response) *response {
+	res.Users = users
+
+	return res
+}
+
+// withAccountReport add account state and fixed conditions to resulting response.
+func (res *response) withAccountReport(rep accountReport) *response {
+	res.AccountState = rep.State
+	res.Reenabled = rep.Reenabled
 
 	return res
 }
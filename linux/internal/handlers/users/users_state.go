@@ -0,0 +1,62 @@
+package users
+
+import (
+	"context"
+	"marketplace-yaga/linux/internal/usermanager"
+	"marketplace-yaga/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+// Conditions reported as fixed in response.
+const (
+	fixedLocked          = "locked"
+	fixedPasswordExpired = "password-expired"
+	fixedExpired         = "expired"
+	fixedNoLoginShell    = "nologin-shell"
+)
+
+// accountReport tells in which state existing account was found and what was changed to let user log in.
+type accountReport struct {
+	State     *usermanager.AccountState
+	Reenabled []string
+}
+
+// reenableAccount fixes conditions, which prevent user from logging in, if request asks for.
+// Password lock is always removed by password reset itself, as new hash replaces locked one,
+// and so is password expiry, as reset sets new last change date.
+func reenableAccount(ctx context.Context, userManager userManagerProvider, req request, st usermanager.AccountState) ([]string, error) {
+	var fixed []string
+	if st.Locked {
+		fixed = append(fixed, fixedLocked)
+	}
+	if st.PasswordExpired {
+		fixed = append(fixed, fixedPasswordExpired)
+	}
+
+	if !req.Reenable {
+		return fixed, nil
+	}
+
+	if st.Expired {
+		if err := userManager.ClearExpiry(req.Username); err != nil {
+			logger.ErrorCtx(ctx, err, "cleared account expiration date",
+				zap.String("username", req.Username))
+			return fixed, err
+		}
+		fixed = append(fixed, fixedExpired)
+	}
+
+	if st.NoLoginShell {
+		shell, err := userManager.RestoreLoginShell(req.Username)
+		if err != nil {
+			logger.ErrorCtx(ctx, err, "restored login shell",
+				zap.String("username", req.Username),
+				zap.String("shell", shell))
+			return fixed, err
+		}
+		fixed = append(fixed, fixedNoLoginShell)
+	}
+
+	return fixed, nil
+}
@@ -0,0 +1,83 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"marketplace-yaga/linux/internal/usermanager"
+	"reflect"
+	"testing"
+)
+
+func TestChangeOrCreateUser_Reenable(t *testing.T) {
+	disabled := usermanager.AccountState{Locked: true, Expired: true, PasswordExpired: true, PasswordInactive: true,
+		NoLoginShell: true, Shell: "/usr/sbin/nologin"}
+
+	tests := []struct {
+		name          string
+		state         *usermanager.AccountState
+		reenable      bool
+		wantReenabled []string
+		wantChanges   []string
+	}{
+		{
+			name:          "report only",
+			state:         &disabled,
+			wantReenabled: []string{fixedLocked, fixedPasswordExpired},
+		},
+		{
+			name:          "reenable",
+			state:         &disabled,
+			reenable:      true,
+			wantReenabled: []string{fixedLocked, fixedPasswordExpired, fixedExpired, fixedNoLoginShell},
+			wantChanges:   []string{"clear-expiry:alice", "set-shell:alice"},
+		},
+		{
+			name:     "healthy account",
+			state:    &usermanager.AccountState{Shell: "/bin/bash"},
+			reenable: true,
+		},
+		{
+			name:     "state unknown",
+			reenable: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := testRecipient(t)
+			req := request{Username: "alice", Modulus: key.Modulus, Exponent: key.Exponent, Reenable: tt.reenable}
+
+			um := newFakeUserManager("alice")
+			um.states = map[string]usermanager.AccountState{}
+			if tt.state != nil {
+				um.states["alice"] = *tt.state
+			}
+
+			_, rep, err := changeOrCreateUser(context.Background(), um, req, defaultPasswordPolicy)
+			if err != nil {
+				t.Fatalf("changeOrCreateUser() error = %v", err)
+			}
+			if (rep.State != nil) != (tt.state != nil) {
+				t.Errorf("changeOrCreateUser() state = %v, want %v", rep.State, tt.state)
+			}
+			if !reflect.DeepEqual(rep.Reenabled, tt.wantReenabled) {
+				t.Errorf("changeOrCreateUser() reenabled = %v, want %v", rep.Reenabled, tt.wantReenabled)
+			}
+			if !reflect.DeepEqual(um.changes, tt.wantChanges) {
+				t.Errorf("changes = %v, want %v", um.changes, tt.wantChanges)
+			}
+		})
+	}
+}
+
+func TestChangeOrCreateUser_Restricted(t *testing.T) {
+	key := testRecipient(t)
+	req := request{Username: "root", Modulus: key.Modulus, Exponent: key.Exponent}
+
+	um := newFakeUserManager("root")
+	if _, _, err := changeOrCreateUser(context.Background(), um, req, defaultPasswordPolicy); !errors.Is(err, usermanager.ErrRestrictedUser) {
+		t.Fatalf("changeOrCreateUser() error = %v, want %v", err, usermanager.ErrRestrictedUser)
+	}
+	if _, ok := um.passwords["root"]; ok {
+		t.Errorf("password of restricted user changed")
+	}
+}
@@ -0,0 +1,343 @@
+package hostkeys
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"marketplace-yaga/pkg/logger"
+	"marketplace-yaga/pkg/messages"
+	"marketplace-yaga/pkg/serial"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultGlob matches public host keys sshd generates.
+const DefaultGlob = "/etc/ssh/ssh_host_*_key.pub"
+
+// MessageType is type of message with host keys written to serial port.
+const MessageType = "HostKeys"
+
+// GuestAttributesURL is metadata directory, where fingerprints are published, if enabled.
+const GuestAttributesURL = "http://169.254.169.254/computeMetadata/v1/instance/guest-attributes/hostkeys/"
+
+// checkInterval is how often host keys are re-read, sshd may regenerate them on first boot after agent started.
+const checkInterval = 30 * time.Second
+
+// InstanceIDURL is metadata URL of instance ID, which is signed along with host keys.
+const InstanceIDURL = "http://169.254.169.254/computeMetadata/v1/instance/id"
+
+const guestAttributesTimeout = 10 * time.Second
+
+var (
+	ErrNoHostKeys      = errors.New("no host keys found")
+	ErrNoSigningKey    = errors.New("no private host key to sign with")
+	ErrGuestAttributes = errors.New("failed to write guest attribute")
+	ErrInstanceID      = errors.New("failed to get instance ID")
+)
+
+// signingPreference orders algorithms of private host keys used to sign report.
+var signingPreference = []string{
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+	ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSA,
+}
+
+// hostKey describes public host key in report.
+type hostKey struct {
+	Algorithm   string `json:"algorithm"`
+	Fingerprint string `json:"fingerprint"`
+
+	file string
+	pk   ssh.PublicKey
+}
+
+// signature is made over JSON encoded signedData with private part of one of reported keys,
+// so console could check message comes from host, which holds that key.
+type signature struct {
+	// Fingerprint identifies reported key, which made signature.
+	Fingerprint string `json:"fingerprint"`
+	Format      string `json:"format"`
+	Blob        string `json:"blob"`
+}
+
+// signedData binds keys to envelope timestamp and instance, so signature could be neither replayed later
+// nor on behalf of another instance.
+type signedData struct {
+	Timestamp  int64     `json:"timestamp"`
+	InstanceID string    `json:"instanceId"`
+	Keys       []hostKey `json:"keys"`
+}
+
+// report is payload of HostKeys message.
+type report struct {
+	InstanceID string     `json:"instanceId"`
+	Keys       []hostKey  `json:"keys"`
+	Signature  *signature `json:"signature,omitempty"`
+}
+
+// readHostKeys parses public host keys matching glob. Unreadable keys are logged and skipped,
+// as sshd ignores them too.
+func readHostKeys(ctx context.Context, fs afero.Fs, glob string) ([]hostKey, error) {
+	files, err := afero.Glob(fs, glob)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var keys []hostKey
+	for _, f := range files {
+		b, err := afero.ReadFile(fs, f)
+		if err != nil {
+			logger.ErrorCtx(ctx, err, "read host key", zap.String("file", f))
+			continue
+		}
+		pk, _, _, _, err := ssh.ParseAuthorizedKey(b)
+		if err != nil {
+			logger.ErrorCtx(ctx, err, "parse host key", zap.String("file", f))
+			continue
+		}
+		keys = append(keys, hostKey{Algorithm: pk.Type(), Fingerprint: ssh.FingerprintSHA256(pk), file: f, pk: pk})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: %v", ErrNoHostKeys, glob)
+	}
+
+	return keys, nil
+}
+
+// sign signs data with most preferred private host key, which matches its public key.
+func sign(fs afero.Fs, d signedData) (*signature, error) {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, algo := range signingPreference {
+		for _, k := range d.Keys {
+			if k.Algorithm != algo {
+				continue
+			}
+			s, err := signer(fs, k)
+			if err != nil {
+				continue
+			}
+			sig, err := signData(s, data)
+			if err != nil {
+				return nil, err
+			}
+
+			return &signature{
+				Fingerprint: k.Fingerprint,
+				Format:      sig.Format,
+				Blob:        base64.StdEncoding.EncodeToString(sig.Blob),
+			}, nil
+		}
+	}
+
+	return nil, ErrNoSigningKey
+}
+
+// signer loads private key of host key, which is stored next to public one without .pub suffix.
+func signer(fs afero.Fs, k hostKey) (ssh.Signer, error) {
+	b, err := afero.ReadFile(fs, strings.TrimSuffix(k.file, ".pub"))
+	if err != nil {
+		return nil, err
+	}
+	s, err := ssh.ParsePrivateKey(b)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(s.PublicKey().Marshal(), k.pk.Marshal()) {
+		return nil, fmt.Errorf("private key does not match %v", k.file)
+	}
+
+	return s, nil
+}
+
+// signData avoids SHA-1 signatures with RSA keys.
+func signData(s ssh.Signer, data []byte) (*ssh.Signature, error) {
+	if as, ok := s.(ssh.AlgorithmSigner); ok && s.PublicKey().Type() == ssh.KeyAlgoRSA {
+		return as.SignWithAlgorithm(rand.Reader, data, ssh.SigAlgoRSASHA2256)
+	}
+
+	return s.Sign(rand.Reader, data)
+}
+
+// serialPort is interface for read or write to serial port.
+var serialPort = serial.NewBlockingWriter()
+
+// Publisher reports host keys to serial port at start and whenever they change.
+type Publisher struct {
+	ctx             context.Context
+	fs              afero.Fs
+	glob            string
+	guestAttributes string
+	instanceIDURL   string
+	instanceID      string
+	client          *http.Client
+	last            []byte
+}
+
+// NewPublisher returns instance of Publisher.
+func NewPublisher(ctx context.Context) *Publisher {
+	return &Publisher{
+		ctx:           ctx,
+		fs:            afero.NewOsFs(),
+		glob:          DefaultGlob,
+		instanceIDURL: InstanceIDURL,
+		client:        &http.Client{Timeout: guestAttributesTimeout},
+	}
+}
+
+// WithGuestAttributes enables publishing fingerprints to guest attributes.
+func (p *Publisher) WithGuestAttributes(enabled bool) *Publisher {
+	p.guestAttributes = ""
+	if enabled {
+		p.guestAttributes = GuestAttributesURL
+	}
+
+	return p
+}
+
+// Start starts to watch host keys in background.
+func (p *Publisher) Start() error {
+	if err := p.ctx.Err(); err != nil {
+		return err
+	}
+
+	go p.do()
+
+	return nil
+}
+
+func (p *Publisher) do() {
+	tr := time.NewTicker(checkInterval)
+	defer tr.Stop()
+
+	for {
+		if err := p.publish(); err != nil {
+			logger.ErrorCtx(p.ctx, err, "publish host keys")
+		}
+
+		select {
+		case <-tr.C:
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// publish writes report, if keys changed since last successful publish.
+func (p *Publisher) publish() error {
+	keys, err := readHostKeys(p.ctx, p.fs, p.glob)
+	if err != nil {
+		return err
+	}
+
+	state, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(state, p.last) {
+		return nil
+	}
+
+	instanceID, err := p.getInstanceID()
+	if err != nil {
+		return err
+	}
+
+	e := messages.NewEnvelope().WithTimestamp(time.Now()).WithType(MessageType)
+	r := report{InstanceID: instanceID, Keys: keys}
+	// unsigned fingerprints are still useful, console shows them as unverified
+	r.Signature, err = sign(p.fs, signedData{Timestamp: e.Timestamp, InstanceID: instanceID, Keys: keys})
+	if err != nil {
+		logger.ErrorCtx(p.ctx, err, "sign host keys")
+	}
+
+	m := e.Wrap(r)
+	if err = serialPort.WriteJSON(m); err != nil {
+		return err
+	}
+	logger.InfoCtx(p.ctx, nil, "published host keys",
+		zap.String("message", fmt.Sprintf("%+v", m)))
+
+	if p.guestAttributes != "" {
+		if err = p.writeGuestAttributes(keys); err != nil {
+			return err
+		}
+	}
+	p.last = state
+
+	return nil
+}
+
+// getInstanceID returns ID of instance from metadata, it never changes, so it is requested once.
+func (p *Publisher) getInstanceID() (string, error) {
+	if p.instanceID != "" {
+		return p.instanceID, nil
+	}
+
+	req, err := http.NewRequestWithContext(p.ctx, http.MethodGet, p.instanceIDURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Add("Metadata-Flavor", "Google")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInstanceID, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: %v", ErrInstanceID, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInstanceID, err)
+	}
+	id := strings.TrimSpace(string(b))
+	if id == "" {
+		return "", fmt.Errorf("%w: empty response", ErrInstanceID)
+	}
+	p.instanceID = id
+
+	return id, nil
+}
+
+// writeGuestAttributes puts fingerprint of each key to attribute named by its algorithm.
+func (p *Publisher) writeGuestAttributes(keys []hostKey) error {
+	for _, k := range keys {
+		u := p.guestAttributes + path.Base(k.Algorithm)
+		req, err := http.NewRequestWithContext(p.ctx, http.MethodPut, u, strings.NewReader(k.Fingerprint))
+		if err != nil {
+			return err
+		}
+		req.Header.Add("Metadata-Flavor", "Google")
+
+		resp, err := p.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("%w %v: %v", ErrGuestAttributes, u, err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%w %v: %v", ErrGuestAttributes, u, resp.Status)
+		}
+	}
+
+	return nil
+}
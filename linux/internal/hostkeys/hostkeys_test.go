@@ -0,0 +1,177 @@
+package hostkeys
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"marketplace-yaga/pkg/messages"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+type fakeSerialPort struct {
+	messages []messages.Message
+}
+
+func (p *fakeSerialPort) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (p *fakeSerialPort) WriteJSON(j interface{}) error {
+	p.messages = append(p.messages, j.(messages.Message))
+
+	return nil
+}
+
+func (p *fakeSerialPort) Close() error {
+	return nil
+}
+
+func useFakeSerialPort(t *testing.T) *fakeSerialPort {
+	t.Helper()
+
+	orig := serialPort
+	t.Cleanup(func() { serialPort = orig })
+	p := &fakeSerialPort{}
+	serialPort = p
+
+	return p
+}
+
+// writeHostKey generates ed25519 host key pair into fs and returns its public key.
+func writeHostKey(t *testing.T, fs afero.Fs, name string) ssh.PublicKey {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	pk, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+
+	require.NoError(t, afero.WriteFile(fs, "/etc/ssh/"+name, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+	require.NoError(t, afero.WriteFile(fs, "/etc/ssh/"+name+".pub", ssh.MarshalAuthorizedKey(pk), 0644))
+
+	return pk
+}
+
+func TestReadHostKeys(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	pk := writeHostKey(t, fs, "ssh_host_ed25519_key")
+	require.NoError(t, afero.WriteFile(fs, "/etc/ssh/ssh_host_rsa_key.pub", []byte("garbage\n"), 0644))
+
+	keys, err := readHostKeys(context.Background(), fs, DefaultGlob)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, ssh.KeyAlgoED25519, keys[0].Algorithm)
+	assert.Equal(t, ssh.FingerprintSHA256(pk), keys[0].Fingerprint)
+
+	_, err = readHostKeys(context.Background(), afero.NewMemMapFs(), DefaultGlob)
+	assert.ErrorIs(t, err, ErrNoHostKeys)
+}
+
+func TestSign(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	pk := writeHostKey(t, fs, "ssh_host_ed25519_key")
+
+	keys, err := readHostKeys(context.Background(), fs, DefaultGlob)
+	require.NoError(t, err)
+	d := signedData{Timestamp: 1700000000, InstanceID: "fhm1", Keys: keys}
+	sig, err := sign(fs, d)
+	require.NoError(t, err)
+	assert.Equal(t, ssh.FingerprintSHA256(pk), sig.Fingerprint)
+
+	data, err := json.Marshal(d)
+	require.NoError(t, err)
+	blob, err := base64.StdEncoding.DecodeString(sig.Blob)
+	require.NoError(t, err)
+	assert.NoError(t, pk.Verify(data, &ssh.Signature{Format: sig.Format, Blob: blob}))
+
+	// signature is bound to instance and time
+	for _, replayed := range []signedData{
+		{Timestamp: d.Timestamp, InstanceID: "fhm2", Keys: keys},
+		{Timestamp: d.Timestamp + 1, InstanceID: d.InstanceID, Keys: keys},
+	} {
+		data, err = json.Marshal(replayed)
+		require.NoError(t, err)
+		assert.Error(t, pk.Verify(data, &ssh.Signature{Format: sig.Format, Blob: blob}))
+	}
+
+	// private key which does not match public one is not used
+	other := afero.NewMemMapFs()
+	writeHostKey(t, other, "ssh_host_ed25519_key")
+	b, err := afero.ReadFile(other, "/etc/ssh/ssh_host_ed25519_key")
+	require.NoError(t, err)
+	require.NoError(t, afero.WriteFile(fs, "/etc/ssh/ssh_host_ed25519_key", b, 0600))
+
+	_, err = sign(fs, d)
+	assert.ErrorIs(t, err, ErrNoSigningKey)
+}
+
+func TestPublisher_Publish(t *testing.T) {
+	port := useFakeSerialPort(t)
+	fs := afero.NewMemMapFs()
+	writeHostKey(t, fs, "ssh_host_ed25519_key")
+
+	attributes := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/id" {
+			_, _ = io.WriteString(w, "fhm1\n")
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		attributes[r.URL.Path] = string(b)
+	}))
+	defer srv.Close()
+
+	p := NewPublisher(context.Background())
+	p.fs = fs
+	p.guestAttributes = srv.URL + "/hostkeys/"
+	p.instanceIDURL = srv.URL + "/id"
+
+	require.NoError(t, p.publish())
+	require.Len(t, port.messages, 1)
+	assert.Equal(t, MessageType, port.messages[0].Type)
+	assert.NotZero(t, port.messages[0].Timestamp)
+	r := port.messages[0].Payload.(report)
+	assert.Equal(t, "fhm1", r.InstanceID)
+	require.NotNil(t, r.Signature)
+	assert.Equal(t, map[string]string{"/hostkeys/ssh-ed25519": r.Keys[0].Fingerprint}, attributes)
+
+	// unchanged keys are not reported again
+	require.NoError(t, p.publish())
+	assert.Len(t, port.messages, 1)
+
+	// regenerated key is reported
+	writeHostKey(t, fs, "ssh_host_ed25519_key")
+	require.NoError(t, p.publish())
+	assert.Len(t, port.messages, 2)
+}
+
+func TestPublisher_Publish_NoInstanceID(t *testing.T) {
+	port := useFakeSerialPort(t)
+	fs := afero.NewMemMapFs()
+	writeHostKey(t, fs, "ssh_host_ed25519_key")
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	p := NewPublisher(context.Background())
+	p.fs = fs
+	p.instanceIDURL = srv.URL + "/id"
+
+	// keys are published on next check, once instance ID is known
+	assert.ErrorIs(t, p.publish(), ErrInstanceID)
+	assert.Empty(t, port.messages)
+}
@@ -3,6 +3,7 @@ package kms
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"github.com/spf13/afero"
 	"marketplace-yaga/linux/internal/persistance"
 )
@@ -30,19 +31,28 @@ func newManager(ctx context.Context) *Manager {
 type Secret struct {
 	KeyId      string `json:"keyId"`
 	Ciphertext string `json:"ciphertext"`
+	// FileAttributes set owner, group and mode of file, root only readable file is written if they are empty.
+	persistance.FileAttributes
 }
 
 type SecretMetadataMessage = map[string]Secret
 
 func (m *Manager) HandleSecrets(msg SecretMetadataMessage) ([]string, error) {
 	var files []string
+	// bad attributes of any file reject whole mapping before anything is written
+	for filepath, secret := range msg {
+		if err := secret.Validate(); err != nil {
+			return nil, fmt.Errorf("%v: %w", filepath, err)
+		}
+	}
+
 	for filepath, secret := range msg {
 		plaintext, err := m.client.Decode(secret.KeyId, secret.Ciphertext)
 		if err != nil {
 			return nil, err
 		}
 
-		err = persistance.WriteFile(m.ctx, m.fs, filepath, bytes.NewReader(plaintext))
+		err = persistance.WriteFileWithAttributes(m.ctx, m.fs, filepath, bytes.NewReader(plaintext), secret.FileAttributes)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,170 @@
+package persistance
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// Permissions files and directories get, when attributes do not set them.
+const (
+	defaultFileMode os.FileMode = 0600
+	defaultDirMode  os.FileMode = 0700
+)
+
+var (
+	ErrBadMode  = errors.New("invalid mode")
+	ErrBadOwner = errors.New("unknown owner")
+	ErrBadGroup = errors.New("unknown group")
+)
+
+// FileAttributes are optional owner, group and permissions of written file, declared in metadata mapping next to
+// secret or certificate, e.g. {"owner": "nginx", "group": "nginx", "mode": "0640", "dirMode": "0750"}.
+// Owner and group are names or numeric ids, modes are octal strings. Absent owner and group are root, absent modes
+// are 0600 and 0700, so file is always replaced with one having declared or root only attributes.
+// Directories created for file get dirOwner, dirGroup and dirMode, and are owned by root unless dirOwner or dirGroup
+// is declared, as owner of directory could redirect writes into it. Existing directories are left as is.
+type FileAttributes struct {
+	Owner    string `json:"owner,omitempty"`
+	Group    string `json:"group,omitempty"`
+	Mode     string `json:"mode,omitempty"`
+	DirOwner string `json:"dirOwner,omitempty"`
+	DirGroup string `json:"dirGroup,omitempty"`
+	DirMode  string `json:"dirMode,omitempty"`
+}
+
+// lookupUser and lookupGroup are overridden in tests.
+var (
+	lookupUser  = user.Lookup
+	lookupGroup = user.LookupGroup
+)
+
+// attributes are resolved FileAttributes.
+type attributes struct {
+	uid, gid       int
+	mode           os.FileMode
+	dirUID, dirGID int
+	dirMode        os.FileMode
+}
+
+// Validate checks that owner and group exist and modes are valid.
+func (a FileAttributes) Validate() error {
+	_, err := a.resolve()
+
+	return err
+}
+
+func (a FileAttributes) resolve() (attributes, error) {
+	r := attributes{mode: defaultFileMode, dirMode: defaultDirMode}
+
+	var err error
+	if r.uid, err = resolveOwner(a.Owner); err != nil {
+		return r, err
+	}
+	if r.gid, err = resolveGroup(a.Group); err != nil {
+		return r, err
+	}
+	if r.dirUID, err = resolveOwner(a.DirOwner); err != nil {
+		return r, err
+	}
+	if r.dirGID, err = resolveGroup(a.DirGroup); err != nil {
+		return r, err
+	}
+	if a.Mode != "" {
+		if r.mode, err = parseMode(a.Mode); err != nil {
+			return r, err
+		}
+	}
+	if a.DirMode != "" {
+		if r.dirMode, err = parseMode(a.DirMode); err != nil {
+			return r, err
+		}
+	}
+
+	return r, nil
+}
+
+// resolveOwner returns uid of owner, which is root if owner is empty.
+func resolveOwner(owner string) (int, error) {
+	if owner == "" {
+		return 0, nil
+	}
+
+	uid, err := resolveID(owner, func(name string) (string, error) {
+		u, err := lookupUser(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+	if err != nil {
+		return -1, fmt.Errorf("%w %q: %v", ErrBadOwner, owner, err)
+	}
+
+	return uid, nil
+}
+
+// resolveGroup returns gid of group, which is root if group is empty.
+func resolveGroup(group string) (int, error) {
+	if group == "" {
+		return 0, nil
+	}
+
+	gid, err := resolveID(group, func(name string) (string, error) {
+		g, err := lookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	})
+	if err != nil {
+		return -1, fmt.Errorf("%w %q: %v", ErrBadGroup, group, err)
+	}
+
+	return gid, nil
+}
+
+// resolveID returns numeric id as is, and looks up id of name otherwise.
+func resolveID(s string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.ParseUint(s, 10, 31); err == nil {
+		return int(id), nil
+	}
+
+	id, err := lookup(s)
+	if err != nil {
+		return -1, err
+	}
+
+	return strconv.Atoi(id)
+}
+
+// parseMode parses octal permissions. Special bits and world writable secrets are not accepted.
+func parseMode(s string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(strings.TrimPrefix(s, "0o"), 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("%w %q: expected octal permissions, e.g. 0640", ErrBadMode, s)
+	}
+	if m&0002 != 0 {
+		return 0, fmt.Errorf("%w %q: must not be world writable", ErrBadMode, s)
+	}
+
+	return os.FileMode(m), nil
+}
+
+// describe returns attributes for plan and audit log. It is not String, which mappings embedding attributes would get.
+func (a FileAttributes) describe() string {
+	var s []string
+	for _, f := range []struct{ name, value string }{
+		{"owner", a.Owner}, {"group", a.Group}, {"mode", a.Mode},
+		{"dirOwner", a.DirOwner}, {"dirGroup", a.DirGroup}, {"dirMode", a.DirMode},
+	} {
+		if f.value != "" {
+			s = append(s, f.name+"="+f.value)
+		}
+	}
+
+	return strings.Join(s, " ")
+}
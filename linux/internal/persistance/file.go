@@ -1,43 +1,187 @@
 package persistance
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/spf13/afero"
 	"go.uber.org/zap"
 	"io"
+	"marketplace-yaga/linux/internal/audit"
+	"marketplace-yaga/linux/internal/plan"
 	"marketplace-yaga/pkg/logger"
 	"os"
 	"path"
+
+	"golang.org/x/sys/unix"
 )
 
+// WriteFile writes file, which only root could read, creating directories along its path.
 func WriteFile(ctx context.Context, fs afero.Fs, filepath string, content io.Reader) error {
+	return WriteFileWithAttributes(ctx, fs, filepath, content, FileAttributes{})
+}
+
+// WriteFileWithAttributes atomically replaces file with one with declared owner, group and mode, creating directories
+// along its path. Attributes, which are not declared, are root and root only permissions.
+func WriteFileWithAttributes(ctx context.Context, fs afero.Fs, filepath string, content io.Reader, attrs FileAttributes) (err error) {
 	logOpts := []zap.Field{
 		zap.String("filepath", filepath),
+		zap.String("attributes", attrs.describe()),
 	}
-	dir, _ := path.Split(filepath)
-	err := fs.MkdirAll(dir, 0700)
+
+	r, err := attrs.resolve()
+	if err != nil {
+		return err
+	}
+
+	if plan.Enabled(ctx) {
+		return planWriteFile(ctx, fs, filepath, content, attrs)
+	}
+
+	var n int64
+	defer func() {
+		audit.Record(ctx, plan.Action{Kind: plan.WriteFile, Target: filepath, Detail: withAttributes(fmt.Sprintf("%d bytes", n), attrs)}, err)
+	}()
+
+	dir, name := path.Split(filepath)
+	err = mkdirAll(fs, dir, r)
 	if err != nil {
 		logger.ErrorCtx(ctx, err, "created all folders along file path", logOpts...)
 		return err
 	}
 
-	file, err := fs.OpenFile(filepath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	// dot in name hides temporary file from globs, e.g. of configs including directory
+	tmp := path.Join(dir, "."+name+".tmp")
+	n, err = writeTemp(fs, tmp, content, r)
+	if err != nil {
+		logger.ErrorCtx(ctx, err, fmt.Sprintf("%d bytes written to temporary file", n), logOpts...)
+		return err
+	}
+
+	// rename replaces symlink at file path rather than writing through it
+	err = fs.Rename(tmp, filepath)
 	if err != nil {
-		logger.ErrorCtx(ctx, err, "opened the file in write mode", logOpts...)
+		_ = fs.Remove(tmp)
+		logger.ErrorCtx(ctx, err, "renamed temporary file", logOpts...)
 		return err
 	}
+	return nil
+}
 
-	n, err := io.Copy(file, content)
+// writeTemp writes content into new temporary file and passes it to owner only after it is written, so file is never
+// readable with partial content. Directory could be writable by owner, so leftover temporary file is removed and new
+// one is created exclusively without following symlinks, owner and mode are set through opened file.
+func writeTemp(fs afero.Fs, tmp string, content io.Reader, r attributes) (n int64, err error) {
+	if err = fs.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+
+	file, err := fs.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL|unix.O_NOFOLLOW, defaultFileMode)
 	if err != nil {
-		logger.ErrorCtx(ctx, err, fmt.Sprintf("%d bytes written to file", n), logOpts...)
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = fs.Remove(tmp)
+		}
+	}()
+
+	n, err = io.Copy(file, content)
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = setFileAttributes(fs, file, r)
+	}
+	if cErr := file.Close(); err == nil {
+		err = cErr
+	}
+
+	return n, err
+}
+
+// setFileAttributes sets owner and mode of opened file. Files of OS are changed through descriptor,
+// other file systems, e.g. in memory one in tests, have no symlinks, so they are changed by path.
+func setFileAttributes(fs afero.Fs, file afero.File, r attributes) error {
+	if f, ok := file.(*os.File); ok {
+		if err := f.Chown(r.uid, r.gid); err != nil {
+			return err
+		}
+		return f.Chmod(r.mode)
+	}
+
+	return setAttributes(fs, file.Name(), r.uid, r.gid, r.mode)
+}
+
+// mkdirAll creates missing directories along dir path with directory owner, group and mode from attributes.
+func mkdirAll(fs afero.Fs, dir string, r attributes) error {
+	var missing []string
+	for d := path.Clean(dir); ; d = path.Dir(d) {
+		if _, err := fs.Stat(d); err == nil {
+			break
+		}
+		missing = append(missing, d)
+		if d == path.Dir(d) {
+			break
+		}
+	}
+
+	if err := fs.MkdirAll(dir, r.dirMode); err != nil {
+		return err
+	}
+
+	// mode is set explicitly, as MkdirAll is subject to umask
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := setAttributes(fs, missing[i], r.dirUID, r.dirGID, r.dirMode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func setAttributes(fs afero.Fs, name string, uid, gid int, mode os.FileMode) error {
+	if err := fs.Chown(name, uid, gid); err != nil {
 		return err
 	}
 
-	err = file.Close()
+	return fs.Chmod(name, mode)
+}
+
+// withAttributes appends declared attributes to action detail.
+func withAttributes(detail string, attrs FileAttributes) string {
+	if s := attrs.describe(); s != "" {
+		return detail + ", " + s
+	}
+
+	return detail
+}
+
+// planWriteFile records intention to write file, telling if file would be created, overwritten or left unchanged.
+func planWriteFile(ctx context.Context, fs afero.Fs, filepath string, content io.Reader, attrs FileAttributes) error {
+	newContent, err := io.ReadAll(content)
 	if err != nil {
-		logger.ErrorCtx(ctx, err, "closed the file", logOpts...)
 		return err
 	}
+
+	detail := "create"
+	oldContent, err := afero.ReadFile(fs, filepath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	case bytes.Equal(oldContent, newContent):
+		detail = "unchanged"
+	default:
+		detail = "overwrite"
+	}
+
+	plan.Record(ctx, plan.Action{
+		Kind:   plan.WriteFile,
+		Target: filepath,
+		Detail: withAttributes(fmt.Sprintf("%s, %d bytes", detail, len(newContent)), attrs),
+	})
+
 	return nil
 }
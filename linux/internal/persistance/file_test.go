@@ -0,0 +1,194 @@
+package persistance
+
+import (
+	"context"
+	"errors"
+	"marketplace-yaga/linux/internal/plan"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestWriteFile_plan(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/etc/old", []byte("old"), 0600)
+	_ = afero.WriteFile(fs, "/etc/same", []byte("same"), 0600)
+
+	tests := []struct {
+		name       string
+		filepath   string
+		content    string
+		wantDetail string
+	}{
+		{name: "create", filepath: "/etc/new", content: "new", wantDetail: "create, 3 bytes"},
+		{name: "overwrite", filepath: "/etc/old", content: "new", wantDetail: "overwrite, 3 bytes"},
+		{name: "unchanged", filepath: "/etc/same", content: "same", wantDetail: "unchanged, 4 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := plan.NewRecorder()
+			ctx := plan.NewContext(context.Background(), r)
+
+			if err := WriteFile(ctx, fs, tt.filepath, strings.NewReader(tt.content)); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+
+			actions := r.Actions()
+			if len(actions) != 1 || actions[0].Target != tt.filepath || actions[0].Detail != tt.wantDetail {
+				t.Errorf("WriteFile() recorded = %v, want detail %v", actions, tt.wantDetail)
+			}
+		})
+	}
+
+	if ok, _ := afero.Exists(fs, "/etc/new"); ok {
+		t.Errorf("WriteFile() must not create file in plan mode")
+	}
+}
+
+func TestWriteFileWithAttributes(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = fs.MkdirAll("/etc", 0755)
+
+	attrs := FileAttributes{Owner: "101", Group: "102", Mode: "0640", DirMode: "0750"}
+	if err := WriteFileWithAttributes(context.Background(), fs, "/etc/nginx/ssl/cert.pem", strings.NewReader("cert"), attrs); err != nil {
+		t.Fatalf("WriteFileWithAttributes() error = %v", err)
+	}
+
+	for name, want := range map[string]os.FileMode{
+		"/etc":                    os.ModeDir | 0755,
+		"/etc/nginx":              os.ModeDir | 0750,
+		"/etc/nginx/ssl":          os.ModeDir | 0750,
+		"/etc/nginx/ssl/cert.pem": 0640,
+	} {
+		fi, err := fs.Stat(name)
+		if err != nil {
+			t.Fatalf("Stat(%v) error = %v", name, err)
+		}
+		if fi.Mode() != want {
+			t.Errorf("mode of %v = %v, want %v", name, fi.Mode(), want)
+		}
+	}
+}
+
+func TestWriteFile_Defaults(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/etc/secret", []byte("old"), 0644)
+
+	// file written before with other attributes gets root only mode again
+	if err := WriteFile(context.Background(), fs, "/etc/secret", strings.NewReader("new")); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	fi, err := fs.Stat("/etc/secret")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if fi.Mode() != 0600 {
+		t.Errorf("mode = %v, want %v", fi.Mode(), os.FileMode(0600))
+	}
+	if ok, _ := afero.Exists(fs, "/etc/.secret.tmp"); ok {
+		t.Errorf("WriteFile() left temporary file")
+	}
+}
+
+func TestWriteFileWithAttributes_Symlinks(t *testing.T) {
+	fs := afero.NewOsFs()
+	dir := t.TempDir()
+	target := path.Join(t.TempDir(), "shadow")
+	_ = os.WriteFile(target, []byte("secret\n"), 0600)
+	attrs := FileAttributes{Owner: strconv.Itoa(os.Getuid()), Group: strconv.Itoa(os.Getgid()), Mode: "0640"}
+
+	// owner could put symlinks at file and temporary file paths
+	file := path.Join(dir, "cert.pem")
+	if err := os.Symlink(target, file); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+	if err := os.Symlink(target, path.Join(dir, ".cert.pem.tmp")); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+
+	if err := WriteFileWithAttributes(context.Background(), fs, file, strings.NewReader("cert"), attrs); err != nil {
+		t.Fatalf("WriteFileWithAttributes() error = %v", err)
+	}
+
+	fi, err := os.Lstat(file)
+	if err != nil {
+		t.Fatalf("Lstat() error = %v", err)
+	}
+	if fi.Mode() != 0640 {
+		t.Errorf("mode of %v = %v, want regular file with mode %v", file, fi.Mode(), os.FileMode(0640))
+	}
+	content, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if string(content) != "secret\n" {
+		t.Errorf("symlink target content = %q, want it unchanged", content)
+	}
+	fi, err = os.Stat(target)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if fi.Mode() != 0600 {
+		t.Errorf("symlink target mode = %v, want it unchanged", fi.Mode())
+	}
+}
+
+func TestFileAttributes_Validate(t *testing.T) {
+	origUser, origGroup := lookupUser, lookupGroup
+	defer func() { lookupUser, lookupGroup = origUser, origGroup }()
+	lookupUser = func(name string) (*user.User, error) {
+		if name == "nginx" {
+			return &user.User{Username: name, Uid: "101"}, nil
+		}
+		return nil, user.UnknownUserError(name)
+	}
+	lookupGroup = func(name string) (*user.Group, error) {
+		if name == "nginx" {
+			return &user.Group{Name: name, Gid: "102"}, nil
+		}
+		return nil, user.UnknownGroupError(name)
+	}
+
+	tests := []struct {
+		name    string
+		attrs   FileAttributes
+		want    attributes
+		wantErr error
+	}{
+		{name: "defaults", want: attributes{mode: 0600, dirMode: 0700}},
+		{
+			name:  "names",
+			attrs: FileAttributes{Owner: "nginx", Group: "nginx", Mode: "0640", DirMode: "0o750"},
+			want:  attributes{uid: 101, gid: 102, mode: 0640, dirMode: 0750},
+		},
+		{name: "ids", attrs: FileAttributes{Owner: "0", Group: "33"}, want: attributes{uid: 0, gid: 33, mode: 0600, dirMode: 0700}},
+		{
+			name:  "directory owner",
+			attrs: FileAttributes{Owner: "nginx", DirOwner: "nginx", DirGroup: "nginx"},
+			want:  attributes{uid: 101, mode: 0600, dirUID: 101, dirGID: 102, dirMode: 0700},
+		},
+		{name: "unknown owner", attrs: FileAttributes{Owner: "postgres"}, wantErr: ErrBadOwner},
+		{name: "unknown group", attrs: FileAttributes{Group: "postgres"}, wantErr: ErrBadGroup},
+		{name: "unknown directory owner", attrs: FileAttributes{DirOwner: "postgres"}, wantErr: ErrBadOwner},
+		{name: "not octal", attrs: FileAttributes{Mode: "rw-r-----"}, wantErr: ErrBadMode},
+		{name: "setuid", attrs: FileAttributes{Mode: "4755"}, wantErr: ErrBadMode},
+		{name: "world writable", attrs: FileAttributes{DirMode: "0777"}, wantErr: ErrBadMode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.attrs.resolve()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("resolve() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && got != tt.want {
+				t.Errorf("resolve() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
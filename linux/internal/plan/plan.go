@@ -0,0 +1,121 @@
+// Package plan allows to run handlers in dry-run mode. When plan Recorder is stored in context,
+// components changing system (executor, usermanager, persistance) record intended actions
+// instead of performing them.
+package plan
+
+import (
+	"context"
+	"sync"
+)
+
+// Kinds of recorded actions.
+const (
+	Exec                = "exec"
+	WriteFile           = "write-file"
+	CreateUser          = "create-user"
+	SetPassword         = "set-password"
+	SetPasswordAging    = "set-password-aging"
+	AddToGroup          = "add-to-group"
+	RemoveFromGroup     = "remove-from-group"
+	AddAuthorizedKey    = "add-authorized-key"
+	RemoveAuthorizedKey = "remove-authorized-key"
+	LockUser            = "lock-user"
+	UnlockUser          = "unlock-user"
+	ClearExpiry         = "clear-expiry"
+	SetShell            = "set-shell"
+	DeleteUser          = "delete-user"
+	RemoveFile          = "remove-file"
+)
+
+// Action is single change, which would be performed if not in plan mode.
+type Action struct {
+	Kind   string `json:"kind"`
+	Target string `json:"target"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Recorder collects actions.
+type Recorder struct {
+	m       sync.Mutex
+	actions []Action
+}
+
+// NewRecorder return instance of Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Actions returns copy of recorded actions in order they were recorded.
+func (r *Recorder) Actions() []Action {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return append([]Action(nil), r.actions...)
+}
+
+func (r *Recorder) add(a Action) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.actions = append(r.actions, a)
+}
+
+func (r *Recorder) has(kind, target string) bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for _, a := range r.actions {
+		if a.Kind == kind && a.Target == target {
+			return true
+		}
+	}
+
+	return false
+}
+
+type key int
+
+var recorderKey key
+
+// NewContext creates context and stores recorder in it, which switches context users to plan mode.
+func NewContext(ctx context.Context, r *Recorder) context.Context {
+	return context.WithValue(ctx, recorderKey, r)
+}
+
+func fromContext(ctx context.Context) *Recorder {
+	if ctx == nil {
+		return nil
+	}
+
+	r, _ := ctx.Value(recorderKey).(*Recorder)
+
+	return r
+}
+
+// Enabled tells if context is in plan mode.
+func Enabled(ctx context.Context) bool {
+	return fromContext(ctx) != nil
+}
+
+// Record stores action if context is in plan mode and returns true, so caller must not perform action.
+// Returns false if not in plan mode.
+func Record(ctx context.Context, a Action) bool {
+	r := fromContext(ctx)
+	if r == nil {
+		return false
+	}
+
+	r.add(a)
+
+	return true
+}
+
+// Planned tells if action of such kind was already recorded for target.
+func Planned(ctx context.Context, kind, target string) bool {
+	r := fromContext(ctx)
+	if r == nil {
+		return false
+	}
+
+	return r.has(kind, target)
+}
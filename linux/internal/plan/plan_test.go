@@ -0,0 +1,31 @@
+package plan
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestRecord(t *testing.T) {
+	ctx := context.Background()
+	if Record(ctx, Action{Kind: Exec, Target: "true"}) {
+		t.Errorf("Record() must not record outside of plan mode")
+	}
+	if Enabled(ctx) {
+		t.Errorf("Enabled() must be false outside of plan mode")
+	}
+
+	r := NewRecorder()
+	ctx = NewContext(ctx, r)
+	if !Record(ctx, Action{Kind: CreateUser, Target: "foo"}) {
+		t.Errorf("Record() must record in plan mode")
+	}
+	if !Planned(ctx, CreateUser, "foo") || Planned(ctx, CreateUser, "bar") {
+		t.Errorf("Planned() got unexpected result")
+	}
+
+	want := []Action{{Kind: CreateUser, Target: "foo"}}
+	if got := r.Actions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Actions() got = %v, want %v", got, want)
+	}
+}
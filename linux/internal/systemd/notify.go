@@ -0,0 +1,78 @@
+// Package systemd implements sd_notify protocol, so agent could report readiness,
+// status and liveness to service manager when started with Type=notify.
+package systemd
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Well-known states of sd_notify protocol.
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Status formats free-form status line, shown by systemctl status.
+func Status(s string) string {
+	// protocol is newline separated
+	return "STATUS=" + strings.ReplaceAll(s, "\n", " ")
+}
+
+// Notify sends states to service manager. Returns false if agent is not started by systemd with notify access.
+func Notify(states ...string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	// abstract namespace socket
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err = conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+var ErrWatchdogEnv = errors.New("malformed watchdog environment")
+
+// WatchdogInterval returns interval at which service manager expects WATCHDOG pings, zero if watchdog is disabled.
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+
+	// watchdog may be meant for other process, e.g. parent shell script
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" {
+		p, err := strconv.Atoi(pid)
+		if err != nil {
+			return 0, ErrWatchdogEnv
+		}
+		if p != os.Getpid() {
+			return 0, nil
+		}
+	}
+
+	u, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || u <= 0 {
+		return 0, ErrWatchdogEnv
+	}
+
+	return time.Duration(u) * time.Microsecond, nil
+}
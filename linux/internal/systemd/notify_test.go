@@ -0,0 +1,70 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify(Ready); sent || err != nil {
+		t.Errorf("Notify() without socket got = %v, error = %v", sent, err)
+	}
+
+	p := path.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: p, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("ListenUnixgram() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	t.Setenv("NOTIFY_SOCKET", p)
+	if sent, err := Notify(Ready, Status("line\nbreak")); !sent || err != nil {
+		t.Fatalf("Notify() got = %v, error = %v", sent, err)
+	}
+
+	buf := make([]byte, 1024)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if got, want := string(buf[:n]), "READY=1\nSTATUS=line break"; got != want {
+		t.Errorf("Notify() sent = %q, want %q", got, want)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		name    string
+		usec    string
+		pid     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "disabled", usec: "", want: 0},
+		{name: "enabled", usec: "30000000", want: 30 * time.Second},
+		{name: "own pid", usec: "30000000", pid: strconv.Itoa(os.Getpid()), want: 30 * time.Second},
+		{name: "other pid", usec: "30000000", pid: "1", want: 0},
+		{name: "malformed", usec: "foo", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("WATCHDOG_USEC", tt.usec)
+			t.Setenv("WATCHDOG_PID", tt.pid)
+
+			got, err := WatchdogInterval()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("WatchdogInterval() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("WatchdogInterval() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package usermanager
+
+import (
+	"fmt"
+	"marketplace-yaga/linux/internal/audit"
+	"marketplace-yaga/linux/internal/executor"
+	"marketplace-yaga/linux/internal/executor/argument"
+	"marketplace-yaga/linux/internal/executor/command"
+	"marketplace-yaga/linux/internal/plan"
+	"marketplace-yaga/pkg/logger"
+	"os/user"
+	"path"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// HomeArchiveDir is directory, where archived home directories of deleted users are stored.
+const HomeArchiveDir = "/opt/yandex-guest-agent/archive"
+
+// archiveTimeout is time given to archive home directory, it could be much longer than other commands.
+const archiveTimeout = 10 * time.Minute
+
+// LockUser locks user password and expires account, so user could not log in neither with password nor with ssh key.
+func (m *Manager) LockUser(username string) error {
+	logger.DebugCtx(m.ctx, nil, "lock user",
+		zap.String("username", username))
+
+	a := plan.Action{Kind: plan.LockUser, Target: username}
+	if plan.Record(m.ctx, a) {
+		return nil
+	}
+
+	err := m.backend.LockUser(username)
+	audit.Record(m.ctx, a, err)
+
+	return err
+}
+
+// UnlockUser undoes LockUser, it unlocks user password and removes account expiration date.
+func (m *Manager) UnlockUser(username string) error {
+	logger.DebugCtx(m.ctx, nil, "unlock user",
+		zap.String("username", username))
+
+	a := plan.Action{Kind: plan.UnlockUser, Target: username}
+	if plan.Record(m.ctx, a) {
+		return nil
+	}
+
+	err := m.backend.UnlockUser(username)
+	audit.Record(m.ctx, a, err)
+
+	return err
+}
+
+// DeleteUser deletes user, home directory and mail spool are removed if removeHome set.
+func (m *Manager) DeleteUser(username string, removeHome bool) error {
+	logger.DebugCtx(m.ctx, nil, "delete user",
+		zap.String("username", username),
+		zap.Bool("removeHome", removeHome))
+
+	a := plan.Action{Kind: plan.DeleteUser, Target: username, Detail: fmt.Sprintf("removeHome=%v", removeHome)}
+	if plan.Record(m.ctx, a) {
+		return nil
+	}
+
+	err := m.backend.DeleteUser(username, removeHome)
+	audit.Record(m.ctx, a, err)
+	if err != nil {
+		return err
+	}
+
+	// otherwise user created later with same name would inherit privileges
+	return m.removeSudoersDropIn(username)
+}
+
+// ArchiveHome packs user home directory into tar.gz file in HomeArchiveDir and returns path of archive.
+func (m *Manager) ArchiveHome(u *user.User) (string, error) {
+	logger.DebugCtx(m.ctx, nil, "archive home directory",
+		zap.String("username", u.Username),
+		zap.String("homedir", u.HomeDir))
+
+	archive := path.Join(HomeArchiveDir, fmt.Sprintf("%v-%v.tar.gz", u.Username, time.Now().UTC().Unix()))
+
+	if !plan.Record(m.ctx, plan.Action{Kind: plan.WriteFile, Target: HomeArchiveDir, Detail: "create directory"}) {
+		if err := m.fs.MkdirAll(HomeArchiveDir, 0700); err != nil {
+			return "", err
+		}
+	}
+
+	cmd, err := command.New(
+		argument.New("tar"),
+		argument.New("--create"),
+		argument.New("--gzip"),
+		argument.New("--file"),
+		argument.New(archive),
+		argument.New("--directory"),
+		argument.New(u.HomeDir),
+		argument.New("."))
+	if err != nil {
+		return "", fmt.Errorf("failed construct command: %w", err)
+	}
+
+	err = executor.NewBuilder(m.ctx).WithTimeout(archiveTimeout).Build().Run(cmd)
+	audit.Record(m.ctx, plan.Action{Kind: plan.WriteFile, Target: archive, Detail: "archive of " + u.HomeDir}, err)
+	if err != nil {
+		return "", err
+	}
+
+	return archive, nil
+}
+
+// RevokeSshKeys clears managed block of user authorized_keys, keys user added outside of block are left alone.
+// Returns false if user had no managed keys.
+func (m *Manager) RevokeSshKeys(u *user.User) (bool, error) {
+	logger.DebugCtx(m.ctx, nil, "revoke ssh keys",
+		zap.String("username", u.Username))
+
+	_, removed, err := m.SyncSshKeys(u, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return len(removed) > 0, nil
+}
@@ -0,0 +1,79 @@
+package usermanager
+
+import (
+	"errors"
+	"fmt"
+	"marketplace-yaga/linux/internal/audit"
+	"marketplace-yaga/linux/internal/plan"
+	"marketplace-yaga/pkg/logger"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+// NeverExpire is PasswordAging.MaxDays value, which removes password expiry.
+const NeverExpire = -1
+
+// shadowNeverExpire is maximum password age in shadow file, which is treated as never expire.
+const shadowNeverExpire = 99999
+
+// ErrInvalidAging is returned for password aging out of allowed values.
+var ErrInvalidAging = errors.New("invalid password aging")
+
+// PasswordAging is subset of shadow password aging fields, same as set by chage(1).
+type PasswordAging struct {
+	// MustChange forces user to change password on next login, sets date of last password change to 0.
+	MustChange bool
+	// MaxDays is number of days password stays valid, 0 keeps current value, NeverExpire removes expiry.
+	MaxDays int
+}
+
+// IsZero tells if aging changes nothing.
+func (a PasswordAging) IsZero() bool {
+	return !a.MustChange && a.MaxDays == 0
+}
+
+// Validate checks aging values.
+func (a PasswordAging) Validate() error {
+	if a.MaxDays < NeverExpire || a.MaxDays >= shadowNeverExpire {
+		return fmt.Errorf("%w: max days %v must be in [%v, %v)", ErrInvalidAging, a.MaxDays, NeverExpire, shadowNeverExpire)
+	}
+
+	return nil
+}
+
+// maxDays returns value of maximum password age field.
+func (a PasswordAging) maxDays() string {
+	if a.MaxDays == NeverExpire {
+		return strconv.Itoa(shadowNeverExpire)
+	}
+
+	return strconv.Itoa(a.MaxDays)
+}
+
+// SetPasswordAging changes password aging of user. Must be called after password is set,
+// as setting password updates date of last password change.
+func (m *Manager) SetPasswordAging(username string, aging PasswordAging) error {
+	logger.DebugCtx(m.ctx, nil, "set password aging",
+		zap.String("username", username),
+		zap.Bool("mustChange", aging.MustChange),
+		zap.Int("maxDays", aging.MaxDays))
+
+	if aging.IsZero() {
+		return nil
+	}
+	if err := aging.Validate(); err != nil {
+		return err
+	}
+
+	a := plan.Action{Kind: plan.SetPasswordAging, Target: username,
+		Detail: fmt.Sprintf("mustChange=%v maxDays=%v", aging.MustChange, aging.MaxDays)}
+	if plan.Record(m.ctx, a) {
+		return nil
+	}
+
+	err := m.backend.SetPasswordAging(username, aging)
+	audit.Record(m.ctx, a, err)
+
+	return err
+}
@@ -0,0 +1,35 @@
+package usermanager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_SetPasswordAging(t *testing.T) {
+	tests := []struct {
+		name    string
+		aging   PasswordAging
+		want    []string
+		wantErr error
+	}{
+		{name: "nothing", aging: PasswordAging{}},
+		{name: "must change", aging: PasswordAging{MustChange: true}, want: []string{"chage --lastday 0 alice"}},
+		{
+			name:  "must change and expire",
+			aging: PasswordAging{MustChange: true, MaxDays: 90},
+			want:  []string{"chage --lastday 0 --maxdays 90 alice"},
+		},
+		{name: "never expire", aging: PasswordAging{MaxDays: NeverExpire}, want: []string{"chage --maxdays 99999 alice"}},
+		{name: "invalid", aging: PasswordAging{MaxDays: -5}, wantErr: ErrInvalidAging},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, e := newTestManager(context.Background(), nil)
+
+			assert.ErrorIs(t, m.SetPasswordAging("alice", tt.aging), tt.wantErr)
+			assert.Equal(t, tt.want, e.commands)
+		})
+	}
+}
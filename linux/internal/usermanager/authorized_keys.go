@@ -0,0 +1,202 @@
+package usermanager
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/user"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+	"marketplace-yaga/linux/internal/audit"
+	"marketplace-yaga/linux/internal/plan"
+	"marketplace-yaga/pkg/logger"
+)
+
+// authorizedKeysFile is name of file in user .ssh dir, which sshd reads keys from.
+const authorizedKeysFile = "authorized_keys"
+
+// Markers of authorized_keys block, which content is owned by agent. Lines outside of block are left as is.
+const (
+	managedBlockBegin = "# BEGIN yandex-guest-agent managed keys, do not edit"
+	managedBlockEnd   = "# END yandex-guest-agent managed keys"
+)
+
+// ErrUnterminatedBlock is returned, when managed block of authorized_keys has no end marker, e.g. file was truncated.
+// It is not known, which of following lines are user keys, so file is left as is.
+var ErrUnterminatedBlock = errors.New("managed keys block has no end marker")
+
+// authorizedKeys is content of authorized_keys file split around managed block.
+type authorizedKeys struct {
+	head    []string
+	managed []string
+	tail    []string
+}
+
+// parseAuthorizedKeys splits content of authorized_keys file. If there is no managed block, all lines are in head.
+func parseAuthorizedKeys(content string) (authorizedKeys, error) {
+	var k authorizedKeys
+	if content == "" {
+		return k, nil
+	}
+
+	dst := &k.head
+	for _, line := range strings.Split(strings.TrimSuffix(content, "\n"), "\n") {
+		switch {
+		case line == managedBlockBegin && dst == &k.head:
+			dst = &k.managed
+		case line == managedBlockEnd && dst == &k.managed:
+			dst = &k.tail
+		case line == "" && dst == &k.managed:
+		default:
+			*dst = append(*dst, line)
+		}
+	}
+	if dst == &k.managed {
+		return k, ErrUnterminatedBlock
+	}
+
+	return k, nil
+}
+
+// String renders authorized_keys content with managed block after user lines.
+func (k authorizedKeys) String() string {
+	lines := append([]string(nil), k.head...)
+	if len(k.managed) > 0 {
+		lines = append(lines, managedBlockBegin)
+		lines = append(lines, k.managed...)
+		lines = append(lines, managedBlockEnd)
+	}
+	lines = append(lines, k.tail...)
+	if len(lines) == 0 {
+		return ""
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// reconcile sets managed block to exactly keys, returning keys which were added and removed.
+// Lines outside of block are never touched, even if they duplicate managed keys.
+func (k *authorizedKeys) reconcile(keys []string) (added, removed []string) {
+	want := make(map[string]struct{}, len(keys))
+	var managed []string
+	for _, key := range keys {
+		if _, dup := want[key]; !dup {
+			want[key] = struct{}{}
+			managed = append(managed, key)
+		}
+	}
+
+	had := make(map[string]struct{}, len(k.managed))
+	for _, key := range k.managed {
+		had[key] = struct{}{}
+		if _, ok := want[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+
+	for _, key := range managed {
+		if _, ok := had[key]; !ok {
+			added = append(added, key)
+		}
+	}
+	k.managed = managed
+
+	return added, removed
+}
+
+// SyncSshKeys makes managed block of user authorized_keys contain exactly keys, so keys removed from metadata
+// are revoked. Keys, which user added outside of block, are left alone.
+func (m *Manager) SyncSshKeys(u *user.User, keys []string) (added, removed []string, err error) {
+	file := path.Join(u.HomeDir, ".ssh", authorizedKeysFile)
+
+	logger.DebugCtx(m.ctx, nil, "sync ssh keys",
+		zap.String("username", u.Username),
+		zap.String("authorizedKeysFile", file))
+
+	content, err := m.readAuthorizedKeys(u)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	k, err := parseAuthorizedKeys(string(content))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w in %v", err, file)
+	}
+	added, removed = k.reconcile(keys)
+	newContent := k.String()
+	if newContent == string(content) {
+		return added, removed, nil
+	}
+
+	var actions []plan.Action
+	for _, key := range added {
+		actions = append(actions, plan.Action{Kind: plan.AddAuthorizedKey, Target: file, Detail: key})
+	}
+	for _, key := range removed {
+		actions = append(actions, plan.Action{Kind: plan.RemoveAuthorizedKey, Target: file, Detail: key})
+	}
+	if plan.Enabled(m.ctx) {
+		for _, a := range actions {
+			plan.Record(m.ctx, a)
+		}
+
+		return added, removed, nil
+	}
+
+	err = m.writeAuthorizedKeys(u, content, newContent)
+	for _, a := range actions {
+		audit.Record(m.ctx, a, err)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return added, removed, nil
+}
+
+// readAuthorizedKeys returns content of user authorized_keys, which is empty if there is no file.
+func (m *Manager) readAuthorizedKeys(u *user.User) ([]byte, error) {
+	d, err := m.openSshDir(u, false)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = d.Close() }()
+
+	content, err := d.readFile(authorizedKeysFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	return content, err
+}
+
+// writeAuthorizedKeys replaces authorized_keys file, creating .ssh dir if needed, and passes them to user.
+// Previous content, as it was read by agent, is kept in backup file next to it.
+func (m *Manager) writeAuthorizedKeys(u *user.User, old []byte, content string) error {
+	d, err := m.openSshDir(u, true)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = d.Close() }()
+
+	if len(old) > 0 {
+		if err = d.writeFile(authorizedKeysBackupFile, string(old)); err != nil {
+			logger.ErrorCtx(m.ctx, err, "backup authorized_keys",
+				zap.String("homedir", u.HomeDir))
+			return err
+		}
+	}
+
+	if err = d.writeFile(authorizedKeysFile, content); err != nil {
+		logger.ErrorCtx(m.ctx, err, "written authorized_keys",
+			zap.String("homedir", u.HomeDir))
+		return err
+	}
+
+	return m.restoreSecurityContext(path.Join(u.HomeDir, ".ssh"))
+}
@@ -0,0 +1,147 @@
+package usermanager
+
+import (
+	"context"
+	"os/user"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"marketplace-yaga/linux/internal/plan"
+)
+
+func managedBlock(keys ...string) string {
+	s := managedBlockBegin + "\n"
+	for _, k := range keys {
+		s += k + "\n"
+	}
+
+	return s + managedBlockEnd + "\n"
+}
+
+func TestAuthorizedKeys_reconcile(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		keys        []string
+		want        string
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		{
+			name:      "new file",
+			keys:      []string{"ssh-ed25519 A", "ssh-ed25519 B"},
+			want:      managedBlock("ssh-ed25519 A", "ssh-ed25519 B"),
+			wantAdded: []string{"ssh-ed25519 A", "ssh-ed25519 B"},
+		},
+		{
+			name:    "user keys are kept",
+			content: "ssh-rsa USER\n\n# my laptop\n",
+			keys:    []string{"ssh-ed25519 A"},
+			want:    "ssh-rsa USER\n\n# my laptop\n" + managedBlock("ssh-ed25519 A"),
+			wantAdded: []string{
+				"ssh-ed25519 A",
+			},
+		},
+		{
+			name:        "removed key is revoked",
+			content:     "ssh-rsa USER\n" + managedBlock("ssh-ed25519 A", "ssh-ed25519 B") + "ssh-rsa TAIL\n",
+			keys:        []string{"ssh-ed25519 B"},
+			want:        "ssh-rsa USER\n" + managedBlock("ssh-ed25519 B") + "ssh-rsa TAIL\n",
+			wantRemoved: []string{"ssh-ed25519 A"},
+		},
+		{
+			name:        "all keys revoked",
+			content:     "ssh-rsa USER\n" + managedBlock("ssh-ed25519 A"),
+			want:        "ssh-rsa USER\n",
+			wantRemoved: []string{"ssh-ed25519 A"},
+		},
+		{
+			name:      "user keys duplicating managed keys are left alone",
+			content:   "ssh-rsa USER\nssh-ed25519 A\n",
+			keys:      []string{"ssh-ed25519 A", "ssh-ed25519 A"},
+			want:      "ssh-rsa USER\nssh-ed25519 A\n" + managedBlock("ssh-ed25519 A"),
+			wantAdded: []string{"ssh-ed25519 A"},
+		},
+		{
+			name:        "revoked key stays outside of block",
+			content:     "ssh-ed25519 A\n" + managedBlock("ssh-ed25519 A"),
+			want:        "ssh-ed25519 A\n",
+			wantRemoved: []string{"ssh-ed25519 A"},
+		},
+		{
+			name:    "unchanged",
+			content: managedBlock("ssh-ed25519 A"),
+			keys:    []string{"ssh-ed25519 A"},
+			want:    managedBlock("ssh-ed25519 A"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := parseAuthorizedKeys(tt.content)
+			require.NoError(t, err)
+			added, removed := k.reconcile(tt.keys)
+
+			assert.Equal(t, tt.want, k.String())
+			assert.Equal(t, tt.wantAdded, added)
+			assert.Equal(t, tt.wantRemoved, removed)
+		})
+	}
+}
+
+func TestManager_SyncSshKeys(t *testing.T) {
+	const file = "/home/alice/.ssh/authorized_keys"
+	u := &user.User{Username: "alice", Uid: "1001", Gid: "1001", HomeDir: "/home/alice"}
+
+	m, _ := newTestManager(context.Background(), map[string]string{file: "ssh-rsa USER\n" + managedBlock("ssh-ed25519 A")})
+
+	added, removed, err := m.SyncSshKeys(u, []string{"ssh-ed25519 B"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ssh-ed25519 B"}, added)
+	assert.Equal(t, []string{"ssh-ed25519 A"}, removed)
+
+	content, err := afero.ReadFile(m.fs, file)
+	require.NoError(t, err)
+	assert.Equal(t, "ssh-rsa USER\n"+managedBlock("ssh-ed25519 B"), string(content))
+
+	r := plan.NewRecorder()
+	m.ctx = plan.NewContext(context.Background(), r)
+	_, _, err = m.SyncSshKeys(u, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []plan.Action{{Kind: plan.RemoveAuthorizedKey, Target: file, Detail: "ssh-ed25519 B"}}, r.Actions())
+}
+
+func TestManager_SyncSshKeys_Unterminated(t *testing.T) {
+	const file = "/home/alice/.ssh/authorized_keys"
+	const content = managedBlockBegin + "\nssh-ed25519 A\nssh-rsa USER\n"
+	u := &user.User{Username: "alice", Uid: "1001", Gid: "1001", HomeDir: "/home/alice"}
+
+	m, _ := newTestManager(context.Background(), map[string]string{file: content})
+
+	_, _, err := m.SyncSshKeys(u, nil)
+	assert.ErrorIs(t, err, ErrUnterminatedBlock)
+
+	got, err := afero.ReadFile(m.fs, file)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(got))
+}
+
+func TestManager_RevokeSshKeys(t *testing.T) {
+	const file = "/home/alice/.ssh/authorized_keys"
+	u := &user.User{Username: "alice", Uid: "1001", Gid: "1001", HomeDir: "/home/alice"}
+
+	m, _ := newTestManager(context.Background(), map[string]string{file: "ssh-rsa USER\n" + managedBlock("ssh-ed25519 A")})
+
+	revoked, err := m.RevokeSshKeys(u)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	content, err := afero.ReadFile(m.fs, file)
+	require.NoError(t, err)
+	assert.Equal(t, "ssh-rsa USER\n", string(content))
+
+	revoked, err = m.RevokeSshKeys(u)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
@@ -0,0 +1,173 @@
+package usermanager
+
+import (
+	"fmt"
+	"marketplace-yaga/linux/internal/executor"
+	"marketplace-yaga/linux/internal/executor/argument"
+	"marketplace-yaga/linux/internal/executor/command"
+	"os/exec"
+)
+
+// createOptions are applied to created user, empty values mean backend defaults.
+type createOptions struct {
+	Shell    string
+	HomeBase string
+	SkelDir  string
+	UIDMin   int
+	UIDMax   int
+}
+
+// accountBackend performs changes of local accounts database.
+type accountBackend interface {
+	CreateUser(username string, opts createOptions) error
+	// SetPassword sets password hash in crypt format.
+	SetPassword(username, hash string) error
+	SetPasswordAging(username string, aging PasswordAging) error
+	AddToGroup(username, group string) error
+	RemoveFromGroup(username, group string) error
+	LockUser(username string) error
+	// UnlockUser undoes LockUser, i.e. unlocks password and removes account expiration date.
+	UnlockUser(username string) error
+	// ClearExpiry removes account expiration date.
+	ClearExpiry(username string) error
+	SetShell(username, shell string) error
+	DeleteUser(username string, removeHome bool) error
+}
+
+// requiredTools are used by toolsBackend, if any is missing filesBackend is used.
+var requiredTools = []string{"useradd", "usermod", "userdel", "chage", "gpasswd"}
+
+// lookPath is a global wrapped function for mocking in tests.
+var lookPath = exec.LookPath
+
+// hasAccountTools tells if shadow-utils compatible tools are installed.
+func hasAccountTools() bool {
+	for _, t := range requiredTools {
+		if _, err := lookPath(t); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// toolsBackend changes accounts with shadow-utils tools.
+type toolsBackend struct {
+	executor executor.ExecutorService
+}
+
+func (b *toolsBackend) run(args ...argument.Argument) error {
+	cmd, err := command.New(args...)
+	if err != nil {
+		return fmt.Errorf("failed construct command: %w", err)
+	}
+
+	return b.executor.Run(cmd)
+}
+
+func (b *toolsBackend) CreateUser(username string, opts createOptions) error {
+	args := []argument.Argument{argument.New("useradd"), argument.New("--create-home")}
+	// policy range overrides one useradd reads from login.defs
+	if opts.UIDMin > 0 && opts.UIDMax >= opts.UIDMin {
+		args = append(args,
+			argument.New("--key"), argument.New(fmt.Sprintf("UID_MIN=%d", opts.UIDMin)),
+			argument.New("--key"), argument.New(fmt.Sprintf("UID_MAX=%d", opts.UIDMax)))
+	}
+	if opts.Shell != "" {
+		args = append(args, argument.New("--shell"), argument.New(opts.Shell))
+	}
+	if opts.HomeBase != "" {
+		args = append(args, argument.New("--base-dir"), argument.New(opts.HomeBase))
+	}
+	if opts.SkelDir != "" {
+		args = append(args, argument.New("--skel"), argument.New(opts.SkelDir))
+	}
+	args = append(args, argument.New(username))
+
+	return b.run(args...)
+}
+
+func (b *toolsBackend) SetPassword(username, hash string) error {
+	return b.run(
+		argument.New("usermod"),
+		argument.New("--password"),
+		argument.New(hash, argument.Sensitive(), argument.NoEscape()),
+		argument.New(username))
+}
+
+func (b *toolsBackend) SetPasswordAging(username string, aging PasswordAging) error {
+	args := []argument.Argument{argument.New("chage")}
+	if aging.MustChange {
+		args = append(args, argument.New("--lastday"), argument.New("0"))
+	}
+	if aging.MaxDays != 0 {
+		args = append(args, argument.New("--maxdays"), argument.New(aging.maxDays()))
+	}
+	args = append(args, argument.New(username))
+
+	return b.run(args...)
+}
+
+func (b *toolsBackend) AddToGroup(username, group string) error {
+	return b.run(
+		argument.New("usermod"),
+		argument.New("--append"),
+		argument.New("--groups"),
+		argument.New(group),
+		argument.New(username))
+}
+
+func (b *toolsBackend) RemoveFromGroup(username, group string) error {
+	return b.run(
+		argument.New("gpasswd"),
+		argument.New("--delete"),
+		argument.New(username),
+		argument.New(group))
+}
+
+func (b *toolsBackend) LockUser(username string) error {
+	// expiration date of 1 day since epoch is in past, which disables account for all authentication methods
+	return b.run(
+		argument.New("usermod"),
+		argument.New("--lock"),
+		argument.New("--expiredate"),
+		argument.New("1"),
+		argument.New(username))
+}
+
+func (b *toolsBackend) UnlockUser(username string) error {
+	// usermod leaves password locked, if unlocking would make it empty, e.g. account with no password set
+	return b.run(
+		argument.New("usermod"),
+		argument.New("--unlock"),
+		argument.New("--expiredate"),
+		argument.New("-1"),
+		argument.New(username))
+}
+
+func (b *toolsBackend) ClearExpiry(username string) error {
+	// -1 removes expiration date, same as empty value, which could not be passed safely
+	return b.run(
+		argument.New("usermod"),
+		argument.New("--expiredate"),
+		argument.New("-1"),
+		argument.New(username))
+}
+
+func (b *toolsBackend) SetShell(username, shell string) error {
+	return b.run(
+		argument.New("usermod"),
+		argument.New("--shell"),
+		argument.New(shell),
+		argument.New(username))
+}
+
+func (b *toolsBackend) DeleteUser(username string, removeHome bool) error {
+	args := []argument.Argument{argument.New("userdel")}
+	if removeHome {
+		args = append(args, argument.New("--remove"))
+	}
+	args = append(args, argument.New(username))
+
+	return b.run(args...)
+}
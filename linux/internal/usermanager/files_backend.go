@@ -0,0 +1,650 @@
+package usermanager
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	passwdFile  = "/etc/passwd"
+	shadowFile  = "/etc/shadow"
+	gshadowFile = "/etc/gshadow"
+	skelDir     = "/etc/skel"
+
+	// defaultShell is login shell of created users, same as useradd defaults on most distros.
+	defaultShell = "/bin/sh"
+	// uidMin and uidMax is default range of UIDs and GIDs allocated for regular users.
+	uidMin = 1000
+	uidMax = 60000
+)
+
+var (
+	ErrUserExists    = errors.New("user already exists")
+	ErrUserNotFound  = errors.New("user not found")
+	ErrGroupExists   = errors.New("group already exists")
+	ErrGroupNotFound = errors.New("group not found")
+	ErrNoFreeID      = errors.New("no free id left")
+)
+
+// filesBackend changes accounts by editing passwd, shadow, group and gshadow files directly.
+// Files are locked same way as lckpwdf(3) does, so tools of shadow-utils and busybox would wait for us,
+// and replaced atomically leaving previous version with '-' suffix, as shadow-utils does.
+type filesBackend struct {
+	fs   afero.Fs
+	lock func() (unlock func(), err error)
+	now  func() time.Time
+}
+
+func newFilesBackend(fs afero.Fs) *filesBackend {
+	return &filesBackend{
+		fs:   fs,
+		lock: lockPasswd,
+		now:  time.Now,
+	}
+}
+
+// table is colon separated accounts database file.
+type table struct {
+	path    string
+	nfields int
+	lines   []string
+	exists  bool
+	changed bool
+}
+
+// find returns index and fields of entry with name, or -1 if there is no such entry.
+func (t *table) find(name string) (int, []string) {
+	for i, l := range t.lines {
+		f := strings.Split(l, ":")
+		if f[0] == name {
+			return i, t.pad(f)
+		}
+	}
+
+	return -1, nil
+}
+
+func (t *table) pad(f []string) []string {
+	for len(f) < t.nfields {
+		f = append(f, "")
+	}
+
+	return f
+}
+
+func (t *table) set(i int, f []string) {
+	t.lines[i] = strings.Join(f, ":")
+	t.changed = true
+}
+
+func (t *table) add(f []string) {
+	t.lines = append(t.lines, strings.Join(t.pad(f), ":"))
+	t.changed = true
+}
+
+func (t *table) remove(i int) {
+	t.lines = append(t.lines[:i], t.lines[i+1:]...)
+	t.changed = true
+}
+
+// ids returns set of ids in third field, which is uid for passwd and gid for group.
+func (t *table) ids() map[int]struct{} {
+	ids := make(map[int]struct{})
+	for _, l := range t.lines {
+		f := strings.Split(l, ":")
+		if len(f) < 3 {
+			continue
+		}
+		if id, err := strconv.Atoi(f[2]); err == nil {
+			ids[id] = struct{}{}
+		}
+	}
+
+	return ids
+}
+
+// accountsDB is set of files, which is loaded and stored together under lock.
+type accountsDB struct {
+	passwd, shadow, group, gshadow *table
+}
+
+func (db *accountsDB) tables() []*table {
+	return []*table{db.passwd, db.shadow, db.group, db.gshadow}
+}
+
+// update loads accounts database under lock, applies fn and stores changed files.
+func (b *filesBackend) update(fn func(db *accountsDB) error) error {
+	unlock, err := b.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	db := &accountsDB{
+		passwd:  &table{path: passwdFile, nfields: 7},
+		shadow:  &table{path: shadowFile, nfields: 9},
+		group:   &table{path: groupFile, nfields: 4},
+		gshadow: &table{path: gshadowFile, nfields: 4},
+	}
+	for _, t := range db.tables() {
+		if err = b.load(t); err != nil {
+			return err
+		}
+	}
+	if !db.passwd.exists || !db.group.exists {
+		return fmt.Errorf("%v or %v not found: %w", passwdFile, groupFile, fs.ErrNotExist)
+	}
+
+	if err = fn(db); err != nil {
+		return err
+	}
+
+	for _, t := range db.tables() {
+		if !t.changed {
+			continue
+		}
+		if err = b.store(t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *filesBackend) load(t *table) error {
+	content, err := afero.ReadFile(b.fs, t.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	t.exists = true
+	for _, l := range strings.Split(string(content), "\n") {
+		if l != "" {
+			t.lines = append(t.lines, l)
+		}
+	}
+
+	return nil
+}
+
+// store writes table to file with '+' suffix, keeps backup with '-' suffix and renames new file over old one.
+func (b *filesBackend) store(t *table) error {
+	perm := os.FileMode(0600)
+	uid, gid := -1, -1
+	if info, err := b.fs.Stat(t.path); err == nil {
+		perm = info.Mode().Perm()
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid, gid = int(st.Uid), int(st.Gid)
+		}
+
+		if err = b.writeFile(t.path+"-", b.backup(t.path), perm, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	content := []byte(strings.Join(t.lines, "\n") + "\n")
+	if err := b.writeFile(t.path+"+", func() ([]byte, error) { return content, nil }, perm, uid, gid); err != nil {
+		return err
+	}
+
+	return b.fs.Rename(t.path+"+", t.path)
+}
+
+func (b *filesBackend) backup(p string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		return afero.ReadFile(b.fs, p)
+	}
+}
+
+// writeFile writes and syncs file, so it is on disk before rename.
+func (b *filesBackend) writeFile(p string, content func() ([]byte, error), perm os.FileMode, uid, gid int) error {
+	c, err := content()
+	if err != nil {
+		return err
+	}
+
+	f, err := b.fs.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(c); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	// mode could be masked by umask on create
+	if err = b.fs.Chmod(p, perm); err != nil {
+		return err
+	}
+	if uid >= 0 {
+		return b.fs.Chown(p, uid, gid)
+	}
+
+	return nil
+}
+
+// days returns days since epoch, as used in shadow file.
+func (b *filesBackend) days() string {
+	return strconv.FormatInt(b.now().Unix()/int64(24*time.Hour/time.Second), 10)
+}
+
+// freeID returns preferred id if it is free, otherwise id after greatest used one in [min, max] range, as useradd does.
+// If range end is reached, lowest free id is returned.
+func freeID(used map[int]struct{}, preferred, min, max int) (int, error) {
+	if _, ok := used[preferred]; !ok && preferred >= min && preferred <= max {
+		return preferred, nil
+	}
+
+	id := min
+	for u := range used {
+		if u >= id && u < max {
+			id = u + 1
+		}
+	}
+	if _, ok := used[id]; !ok {
+		return id, nil
+	}
+
+	for id = min; id <= max; id++ {
+		if _, ok := used[id]; !ok {
+			return id, nil
+		}
+	}
+
+	return 0, ErrNoFreeID
+}
+
+// withDefaults fills empty options with defaults of files backend.
+func (o createOptions) withDefaults() createOptions {
+	if o.Shell == "" {
+		o.Shell = defaultShell
+	}
+	if o.HomeBase == "" {
+		o.HomeBase = defaultHomeBase
+	}
+	if o.SkelDir == "" {
+		o.SkelDir = skelDir
+	}
+	if o.UIDMin == 0 {
+		o.UIDMin = uidMin
+	}
+	if o.UIDMax == 0 {
+		o.UIDMax = uidMax
+	}
+
+	return o
+}
+
+func (b *filesBackend) CreateUser(username string, opts createOptions) error {
+	var uid, gid int
+	opts = opts.withDefaults()
+	home := path.Join(opts.HomeBase, username)
+
+	err := b.update(func(db *accountsDB) error {
+		if i, _ := db.passwd.find(username); i >= 0 {
+			return fmt.Errorf("%w: %v", ErrUserExists, username)
+		}
+		if i, _ := db.group.find(username); i >= 0 {
+			return fmt.Errorf("%w: %v", ErrGroupExists, username)
+		}
+
+		var err error
+		if uid, err = freeID(db.passwd.ids(), 0, opts.UIDMin, opts.UIDMax); err != nil {
+			return err
+		}
+		// user private group has same id as user, if possible
+		if gid, err = freeID(db.group.ids(), uid, opts.UIDMin, opts.UIDMax); err != nil {
+			return err
+		}
+
+		db.passwd.add([]string{username, "x", strconv.Itoa(uid), strconv.Itoa(gid), "", home, opts.Shell})
+		db.shadow.add([]string{username, "!", b.days(), "0", "99999", "7", "", "", ""})
+		db.group.add([]string{username, "x", strconv.Itoa(gid), ""})
+		if db.gshadow.exists {
+			db.gshadow.add([]string{username, "!", "", ""})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return b.createHome(home, opts.SkelDir, uid, gid)
+}
+
+// createHome creates home directory with content of skel directory, owned by user.
+func (b *filesBackend) createHome(home, skel string, uid, gid int) error {
+	if _, err := b.fs.Stat(home); err == nil {
+		return nil
+	}
+
+	if err := b.fs.MkdirAll(home, 0700); err != nil {
+		return err
+	}
+	if err := b.fs.Chown(home, uid, gid); err != nil {
+		return err
+	}
+
+	if _, err := b.fs.Stat(skel); err != nil {
+		return nil
+	}
+
+	return afero.Walk(b.fs, skel, func(p string, info fs.FileInfo, err error) error {
+		if err != nil || p == skel {
+			return err
+		}
+
+		dst := path.Join(home, strings.TrimPrefix(p, skel))
+		switch {
+		case info.IsDir():
+			err = b.fs.MkdirAll(dst, info.Mode().Perm())
+		case info.Mode().IsRegular():
+			var c []byte
+			if c, err = afero.ReadFile(b.fs, p); err == nil {
+				err = afero.WriteFile(b.fs, dst, c, info.Mode().Perm())
+			}
+		default:
+			// symlinks and special files are not copied
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return b.fs.Chown(dst, uid, gid)
+	})
+}
+
+func (b *filesBackend) SetPassword(username, hash string) error {
+	return b.update(func(db *accountsDB) error {
+		if i, _ := db.passwd.find(username); i < 0 {
+			return fmt.Errorf("%w: %v", ErrUserNotFound, username)
+		}
+
+		i, f := db.shadow.find(username)
+		if i < 0 {
+			db.shadow.add([]string{username, hash, b.days(), "0", "99999", "7", "", "", ""})
+			return nil
+		}
+
+		f[1], f[2] = hash, b.days()
+		db.shadow.set(i, f)
+
+		return nil
+	})
+}
+
+func (b *filesBackend) SetPasswordAging(username string, aging PasswordAging) error {
+	return b.update(func(db *accountsDB) error {
+		i, f := db.shadow.find(username)
+		if i < 0 {
+			return fmt.Errorf("%w: %v", ErrUserNotFound, username)
+		}
+
+		if aging.MustChange {
+			f[2] = "0"
+		}
+		if aging.MaxDays != 0 {
+			f[4] = aging.maxDays()
+		}
+		db.shadow.set(i, f)
+
+		return nil
+	})
+}
+
+func (b *filesBackend) AddToGroup(username, group string) error {
+	return b.update(func(db *accountsDB) error {
+		if i, _ := db.passwd.find(username); i < 0 {
+			return fmt.Errorf("%w: %v", ErrUserNotFound, username)
+		}
+
+		i, f := db.group.find(group)
+		if i < 0 {
+			return fmt.Errorf("%w: %v", ErrGroupNotFound, group)
+		}
+		db.group.set(i, withMember(f, username))
+
+		if i, f = db.gshadow.find(group); i >= 0 {
+			db.gshadow.set(i, withMember(f, username))
+		}
+
+		return nil
+	})
+}
+
+func (b *filesBackend) RemoveFromGroup(username, group string) error {
+	return b.update(func(db *accountsDB) error {
+		i, f := db.group.find(group)
+		if i < 0 {
+			return fmt.Errorf("%w: %v", ErrGroupNotFound, group)
+		}
+		db.group.set(i, withoutMember(f, username))
+
+		if i, f = db.gshadow.find(group); i >= 0 {
+			db.gshadow.set(i, withoutMember(f, username))
+		}
+
+		return nil
+	})
+}
+
+func (b *filesBackend) LockUser(username string) error {
+	return b.update(func(db *accountsDB) error {
+		i, f := db.shadow.find(username)
+		if i < 0 {
+			return fmt.Errorf("%w: %v", ErrUserNotFound, username)
+		}
+
+		if !strings.HasPrefix(f[1], "!") {
+			f[1] = "!" + f[1]
+		}
+		// expiration date of 1 day since epoch is in past, which disables account for all authentication methods
+		f[7] = "1"
+		db.shadow.set(i, f)
+
+		return nil
+	})
+}
+
+func (b *filesBackend) UnlockUser(username string) error {
+	return b.update(func(db *accountsDB) error {
+		i, f := db.shadow.find(username)
+		if i < 0 {
+			return fmt.Errorf("%w: %v", ErrUserNotFound, username)
+		}
+
+		// same as usermod, password stays locked, if unlocking would make it empty
+		if strings.HasPrefix(f[1], "!") && len(f[1]) > 1 {
+			f[1] = f[1][1:]
+		}
+		f[7] = ""
+		db.shadow.set(i, f)
+
+		return nil
+	})
+}
+
+func (b *filesBackend) ClearExpiry(username string) error {
+	return b.update(func(db *accountsDB) error {
+		i, f := db.shadow.find(username)
+		if i < 0 {
+			return fmt.Errorf("%w: %v", ErrUserNotFound, username)
+		}
+
+		f[7] = ""
+		db.shadow.set(i, f)
+
+		return nil
+	})
+}
+
+func (b *filesBackend) SetShell(username, shell string) error {
+	return b.update(func(db *accountsDB) error {
+		i, f := db.passwd.find(username)
+		if i < 0 {
+			return fmt.Errorf("%w: %v", ErrUserNotFound, username)
+		}
+
+		f[6] = shell
+		db.passwd.set(i, f)
+
+		return nil
+	})
+}
+
+func (b *filesBackend) DeleteUser(username string, removeHome bool) error {
+	var home string
+
+	err := b.update(func(db *accountsDB) error {
+		i, f := db.passwd.find(username)
+		if i < 0 {
+			return fmt.Errorf("%w: %v", ErrUserNotFound, username)
+		}
+		home = f[5]
+		gid := f[3]
+		db.passwd.remove(i)
+
+		if i, _ = db.shadow.find(username); i >= 0 {
+			db.shadow.remove(i)
+		}
+
+		for _, t := range []*table{db.group, db.gshadow} {
+			for i := len(t.lines) - 1; i >= 0; i-- {
+				f := t.pad(strings.Split(t.lines[i], ":"))
+				g := withoutMember(f, username)
+
+				// user private group is removed, if nobody else is in it
+				if t == db.group && g[0] == username && g[2] == gid && g[3] == "" {
+					t.remove(i)
+					if j, _ := db.gshadow.find(username); j >= 0 {
+						db.gshadow.remove(j)
+					}
+					continue
+				}
+
+				if g[3] != f[3] {
+					t.set(i, g)
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if removeHome {
+		return b.removeHome(home)
+	}
+
+	return nil
+}
+
+func (b *filesBackend) removeHome(home string) error {
+	if !path.IsAbs(home) || path.Clean(home) == "/" {
+		return fmt.Errorf("refuse to remove home directory %q", home)
+	}
+
+	return b.fs.RemoveAll(home)
+}
+
+// withMember returns group entry fields with username added to members list.
+func withMember(f []string, username string) []string {
+	members := splitMembers(f[3])
+	for _, m := range members {
+		if m == username {
+			return f
+		}
+	}
+
+	g := append([]string(nil), f...)
+	g[3] = strings.Join(append(members, username), ",")
+
+	return g
+}
+
+// withoutMember returns group entry fields with username removed from members list.
+func withoutMember(f []string, username string) []string {
+	var members []string
+	for _, m := range splitMembers(f[3]) {
+		if m != username {
+			members = append(members, m)
+		}
+	}
+
+	g := append([]string(nil), f...)
+	g[3] = strings.Join(members, ",")
+
+	return g
+}
+
+func splitMembers(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(s, ",")
+}
+
+// pwdLockFile is lock file used by lckpwdf(3).
+const pwdLockFile = "/etc/.pwd.lock"
+
+// pwdLockTimeout is same timeout as lckpwdf(3) waits for lock.
+const pwdLockTimeout = 15 * time.Second
+
+// ErrPasswdLocked is returned if accounts database lock could not be acquired in time.
+var ErrPasswdLocked = errors.New("accounts database is locked by other process")
+
+// lockPasswd acquires exclusive fcntl lock of pwdLockFile, same as lckpwdf(3).
+func lockPasswd() (func(), error) {
+	f, err := os.OpenFile(pwdLockFile, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	lk := unix.Flock_t{Type: unix.F_WRLCK, Whence: io.SeekStart}
+	deadline := time.Now().Add(pwdLockTimeout)
+	for {
+		err = unix.FcntlFlock(f.Fd(), unix.F_SETLK, &lk)
+		if err == nil {
+			// closing file releases fcntl lock
+			return func() { _ = f.Close() }, nil
+		}
+
+		if !errors.Is(err, unix.EAGAIN) && !errors.Is(err, unix.EACCES) {
+			_ = f.Close()
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			_ = f.Close()
+			return nil, ErrPasswdLocked
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
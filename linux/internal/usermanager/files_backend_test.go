@@ -0,0 +1,226 @@
+package usermanager
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testPasswd = "root:x:0:0:root:/root:/bin/sh\n" +
+		"nobody:x:65534:65534:nobody:/nonexistent:/usr/sbin/nologin\n" +
+		"bob:x:1000:1000::/home/bob:/bin/sh\n"
+	testShadow = "root:*:19000:0:99999:7:::\n" +
+		"nobody:*:19000:0:99999:7:::\n" +
+		"bob:$6$salt$hash:19000:0:99999:7:::\n"
+	testGroup = "root:x:0:\n" +
+		"wheel:x:10:root\n" +
+		"nogroup:x:65534:\n" +
+		"bob:x:1000:\n"
+	testGshadow = "root:*::\n" +
+		"wheel:*::root\n" +
+		"nogroup:*::\n" +
+		"bob:!::\n"
+)
+
+func newTestFilesBackend(t *testing.T) *filesBackend {
+	t.Helper()
+
+	fs := afero.NewMemMapFs()
+	for n, c := range map[string]string{
+		passwdFile:              testPasswd,
+		shadowFile:              testShadow,
+		groupFile:               testGroup,
+		gshadowFile:             testGshadow,
+		skelDir + "/.profile":   "# profile\n",
+		skelDir + "/.config/rc": "rc\n",
+	} {
+		require.NoError(t, afero.WriteFile(fs, n, []byte(c), 0640))
+	}
+
+	now := time.Date(2022, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	return &filesBackend{
+		fs:   fs,
+		lock: func() (func(), error) { return func() {}, nil },
+		now:  func() time.Time { return now },
+	}
+}
+
+func readFile(t *testing.T, b *filesBackend, p string) string {
+	t.Helper()
+
+	c, err := afero.ReadFile(b.fs, p)
+	require.NoError(t, err)
+
+	return string(c)
+}
+
+func TestFilesBackend_CreateUser(t *testing.T) {
+	b := newTestFilesBackend(t)
+
+	require.NoError(t, b.CreateUser("alice", createOptions{}))
+
+	assert.Contains(t, readFile(t, b, passwdFile), "alice:x:1001:1001::/home/alice:/bin/sh\n")
+	assert.Contains(t, readFile(t, b, shadowFile), "alice:!:18994:0:99999:7:::\n")
+	assert.Contains(t, readFile(t, b, groupFile), "alice:x:1001:\n")
+	assert.Contains(t, readFile(t, b, gshadowFile), "alice:!::\n")
+
+	// previous version is kept as backup
+	assert.Equal(t, testPasswd, readFile(t, b, passwdFile+"-"))
+	_, err := b.fs.Stat(passwdFile + "+")
+	assert.Error(t, err)
+
+	info, err := b.fs.Stat(shadowFile)
+	require.NoError(t, err)
+	assert.Equal(t, "-rw-r-----", info.Mode().Perm().String())
+
+	assert.Equal(t, "# profile\n", readFile(t, b, "/home/alice/.profile"))
+	assert.Equal(t, "rc\n", readFile(t, b, "/home/alice/.config/rc"))
+
+	assert.ErrorIs(t, b.CreateUser("alice", createOptions{}), ErrUserExists)
+}
+
+func TestFilesBackend_SetPassword(t *testing.T) {
+	b := newTestFilesBackend(t)
+
+	require.NoError(t, b.SetPassword("bob", "$6$new$hash"))
+	assert.Contains(t, readFile(t, b, shadowFile), "bob:$6$new$hash:18994:0:99999:7:::\n")
+
+	assert.ErrorIs(t, b.SetPassword("alice", "$6$new$hash"), ErrUserNotFound)
+}
+
+func TestFilesBackend_SetPasswordAging(t *testing.T) {
+	b := newTestFilesBackend(t)
+
+	require.NoError(t, b.SetPasswordAging("bob", PasswordAging{MustChange: true, MaxDays: 30}))
+	assert.Contains(t, readFile(t, b, shadowFile), "bob:$6$salt$hash:0:0:30:7:::\n")
+
+	require.NoError(t, b.SetPasswordAging("bob", PasswordAging{MaxDays: NeverExpire}))
+	assert.Contains(t, readFile(t, b, shadowFile), "bob:$6$salt$hash:0:0:99999:7:::\n")
+}
+
+func TestFilesBackend_AddToGroup(t *testing.T) {
+	b := newTestFilesBackend(t)
+
+	require.NoError(t, b.AddToGroup("bob", "wheel"))
+	// adding twice keeps single membership
+	require.NoError(t, b.AddToGroup("bob", "wheel"))
+
+	assert.Contains(t, readFile(t, b, groupFile), "wheel:x:10:root,bob\n")
+	assert.Contains(t, readFile(t, b, gshadowFile), "wheel:*::root,bob\n")
+
+	assert.ErrorIs(t, b.AddToGroup("bob", "sudo"), ErrGroupNotFound)
+}
+
+func TestFilesBackend_LockUser(t *testing.T) {
+	b := newTestFilesBackend(t)
+
+	require.NoError(t, b.LockUser("bob"))
+	require.NoError(t, b.LockUser("bob"))
+
+	assert.Contains(t, readFile(t, b, shadowFile), "bob:!$6$salt$hash:19000:0:99999:7::1:\n")
+}
+
+func TestFilesBackend_UnlockUser(t *testing.T) {
+	b := newTestFilesBackend(t)
+	require.NoError(t, b.LockUser("bob"))
+
+	require.NoError(t, b.UnlockUser("bob"))
+	assert.Contains(t, readFile(t, b, shadowFile), "bob:$6$salt$hash:19000:0:99999:7:::\n")
+
+	// account without password stays locked for password authentication
+	require.NoError(t, b.CreateUser("carol", createOptions{}))
+	require.NoError(t, b.LockUser("carol"))
+	require.NoError(t, b.UnlockUser("carol"))
+	assert.Regexp(t, `carol:!:\d+:0:99999:7:::\n`, readFile(t, b, shadowFile))
+
+	assert.ErrorIs(t, b.UnlockUser("alice"), ErrUserNotFound)
+}
+
+func TestFilesBackend_Reenable(t *testing.T) {
+	b := newTestFilesBackend(t)
+	require.NoError(t, b.LockUser("bob"))
+
+	require.NoError(t, b.ClearExpiry("bob"))
+	require.NoError(t, b.SetShell("bob", "/bin/bash"))
+
+	assert.Contains(t, readFile(t, b, shadowFile), "bob:!$6$salt$hash:19000:0:99999:7:::\n")
+	assert.Contains(t, readFile(t, b, passwdFile), "bob:x:1000:1000::/home/bob:/bin/bash\n")
+}
+
+func TestFilesBackend_DeleteUser(t *testing.T) {
+	b := newTestFilesBackend(t)
+	require.NoError(t, b.AddToGroup("bob", "wheel"))
+	require.NoError(t, afero.WriteFile(b.fs, "/home/bob/file", []byte("data"), 0600))
+
+	require.NoError(t, b.DeleteUser("bob", true))
+
+	assert.NotContains(t, readFile(t, b, passwdFile), "bob")
+	assert.NotContains(t, readFile(t, b, shadowFile), "bob")
+	assert.Equal(t, "root:x:0:\nwheel:x:10:root\nnogroup:x:65534:\n", readFile(t, b, groupFile))
+	assert.Equal(t, "root:*::\nwheel:*::root\nnogroup:*::\n", readFile(t, b, gshadowFile))
+
+	_, err := b.fs.Stat("/home/bob")
+	assert.Error(t, err)
+}
+
+func TestFilesBackend_Locked(t *testing.T) {
+	b := newTestFilesBackend(t)
+	b.lock = func() (func(), error) { return nil, ErrPasswdLocked }
+
+	assert.ErrorIs(t, b.CreateUser("alice", createOptions{}), ErrPasswdLocked)
+	assert.Equal(t, testPasswd, readFile(t, b, passwdFile))
+}
+
+func TestFreeID(t *testing.T) {
+	used := map[int]struct{}{0: {}, 1000: {}, 1005: {}, 65534: {}}
+
+	id, err := freeID(used, 0, uidMin, uidMax)
+	require.NoError(t, err)
+	assert.Equal(t, 1006, id)
+
+	id, err = freeID(used, 1001, uidMin, uidMax)
+	require.NoError(t, err)
+	assert.Equal(t, 1001, id)
+
+	id, err = freeID(map[int]struct{}{uidMax - 1: {}, uidMax: {}}, 0, uidMin, uidMax)
+	require.NoError(t, err)
+	assert.Equal(t, uidMin, id)
+
+	full := make(map[int]struct{})
+	for i := uidMin; i <= uidMax; i++ {
+		full[i] = struct{}{}
+	}
+	_, err = freeID(full, 0, uidMin, uidMax)
+	assert.ErrorIs(t, err, ErrNoFreeID)
+}
+
+func TestHasAccountTools(t *testing.T) {
+	orig := lookPath
+	t.Cleanup(func() { lookPath = orig })
+
+	lookPath = func(string) (string, error) { return "/usr/sbin/tool", nil }
+	assert.True(t, hasAccountTools())
+
+	lookPath = func(file string) (string, error) {
+		if file == "useradd" {
+			return "", exec.ErrNotFound
+		}
+		return "/usr/sbin/" + file, nil
+	}
+	assert.False(t, hasAccountTools())
+
+	// group membership is revoked with gpasswd
+	lookPath = func(file string) (string, error) {
+		if file == "gpasswd" {
+			return "", exec.ErrNotFound
+		}
+		return "/usr/sbin/" + file, nil
+	}
+	assert.False(t, hasAccountTools())
+}
@@ -0,0 +1,128 @@
+package usermanager
+
+import (
+	"errors"
+	"fmt"
+	"marketplace-yaga/linux/internal/audit"
+	"marketplace-yaga/linux/internal/plan"
+	"marketplace-yaga/pkg/logger"
+	"strings"
+
+	"github.com/spf13/afero"
+	"go.uber.org/zap"
+)
+
+var ErrInvalidShell = errors.New("shell is not listed in " + shellsFile)
+
+// AddToGroup appends group to user supplementary groups, if account policy allows that group.
+func (m *Manager) AddToGroup(username, group string) error {
+	logger.DebugCtx(m.ctx, nil, "add to group",
+		zap.String("username", username),
+		zap.String("group", group))
+
+	return m.addToGroup(username, group)
+}
+
+// RemoveFromGroup removes group from user supplementary groups. Nothing is done if user is not group member.
+func (m *Manager) RemoveFromGroup(username, group string) error {
+	logger.DebugCtx(m.ctx, nil, "remove from group",
+		zap.String("username", username),
+		zap.String("group", group))
+
+	member, err := m.InGroup(username, group)
+	if err != nil || !member {
+		return err
+	}
+
+	a := plan.Action{Kind: plan.RemoveFromGroup, Target: username, Detail: group}
+	if plan.Record(m.ctx, a) {
+		return nil
+	}
+
+	err = m.backend.RemoveFromGroup(username, group)
+	audit.Record(m.ctx, a, err)
+
+	return err
+}
+
+// InGroup tells if user is supplementary member of group. Missing group has no members.
+func (m *Manager) InGroup(username, group string) (bool, error) {
+	f, err := m.findEntry(groupFile, group)
+	if err != nil {
+		return false, err
+	}
+
+	return len(f) >= 4 && contains(splitMembers(f[3]), username), nil
+}
+
+// IsAdministrator tells if user has administrator privileges granted with any admin policy,
+// i.e. has sudoers drop-in or is member of admin group.
+func (m *Manager) IsAdministrator(username string) (bool, error) {
+	if ok, err := afero.Exists(m.fs, sudoersDropInPath(username)); err != nil || ok {
+		return ok, err
+	}
+
+	group, err := m.detectAdminGroup()
+	if err != nil || group == "" {
+		return false, err
+	}
+
+	return m.InGroup(username, group)
+}
+
+// RemoveFromAdministrators revokes administrator privileges granted with any admin policy.
+func (m *Manager) RemoveFromAdministrators(username string) error {
+	logger.DebugCtx(m.ctx, nil, "remove from administrators",
+		zap.String("username", username))
+
+	if err := m.removeSudoersDropIn(username); err != nil {
+		return err
+	}
+
+	group, err := m.detectAdminGroup()
+	if err != nil || group == "" {
+		return err
+	}
+
+	return m.RemoveFromGroup(username, group)
+}
+
+// SetShell sets user login shell, which must be listed in shells file. Nothing is done if user already has it.
+func (m *Manager) SetShell(username, shell string) error {
+	content, _ := afero.ReadFile(m.fs, shellsFile)
+	if !contains(strings.Fields(string(content)), shell) {
+		return fmt.Errorf("%w: %v", ErrInvalidShell, shell)
+	}
+
+	pw, err := m.findEntry(passwdFile, username)
+	if err != nil {
+		return err
+	}
+	if len(pw) >= 7 && pw[6] == shell {
+		return nil
+	}
+
+	logger.DebugCtx(m.ctx, nil, "set shell",
+		zap.String("username", username),
+		zap.String("shell", shell))
+
+	a := plan.Action{Kind: plan.SetShell, Target: username, Detail: shell}
+	if plan.Record(m.ctx, a) {
+		return nil
+	}
+
+	err = m.backend.SetShell(username, shell)
+	audit.Record(m.ctx, a, err)
+
+	return err
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
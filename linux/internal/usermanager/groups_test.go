@@ -0,0 +1,64 @@
+package usermanager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager_RemoveFromGroup(t *testing.T) {
+	m, e := newTestManager(context.Background(), map[string]string{groupFile: "docker:x:998:bob,alice\nvideo:x:44:\n"})
+
+	require.NoError(t, m.RemoveFromGroup("alice", "docker"))
+	require.NoError(t, m.RemoveFromGroup("alice", "video"))
+	require.NoError(t, m.RemoveFromGroup("alice", "missing"))
+	assert.Equal(t, []string{"gpasswd --delete alice docker"}, e.commands)
+}
+
+func TestManager_RemoveFromAdministrators(t *testing.T) {
+	m, e := newTestManager(context.Background(), map[string]string{
+		groupFile:                  "sudo:x:27:alice\n",
+		osReleaseFile:              "ID=ubuntu\n",
+		sudoersDropInPath("alice"): "alice ALL=(ALL:ALL) ALL\n",
+	})
+
+	require.NoError(t, m.RemoveFromAdministrators("alice"))
+	assert.Equal(t, []string{"gpasswd --delete alice sudo"}, e.commands)
+	_, err := m.fs.Stat(sudoersDropInPath("alice"))
+	assert.Error(t, err)
+}
+
+func TestManager_IsAdministrator(t *testing.T) {
+	m, _ := newTestManager(context.Background(), map[string]string{
+		groupFile:                "sudo:x:27:alice\ndocker:x:998:bob\n",
+		osReleaseFile:            "ID=ubuntu\n",
+		sudoersDropInPath("bob"): "bob ALL=(ALL:ALL) ALL\n",
+	})
+
+	for user, want := range map[string]bool{"alice": true, "bob": true, "carol": false} {
+		got, err := m.IsAdministrator(user)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, user)
+	}
+
+	member, err := m.InGroup("bob", "docker")
+	require.NoError(t, err)
+	assert.True(t, member)
+	member, err = m.InGroup("bob", "missing")
+	require.NoError(t, err)
+	assert.False(t, member)
+}
+
+func TestManager_SetShell(t *testing.T) {
+	m, e := newTestManager(context.Background(), map[string]string{
+		shellsFile: "/bin/sh\n/bin/bash\n/bin/zsh\n",
+		passwdFile: "alice:x:1001:1001::/home/alice:/bin/bash\n",
+	})
+
+	require.NoError(t, m.SetShell("alice", "/bin/bash"))
+	require.NoError(t, m.SetShell("alice", "/bin/zsh"))
+	assert.ErrorIs(t, m.SetShell("alice", "/tmp/evil"), ErrInvalidShell)
+	assert.Equal(t, []string{"usermod --shell /bin/zsh alice"}, e.commands)
+}
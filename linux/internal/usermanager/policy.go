@@ -0,0 +1,261 @@
+package usermanager
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// DefaultAccountPolicyFile is path of account policy, default policy is used if there is no such file.
+const DefaultAccountPolicyFile = "/etc/yandex-guest-agent/account-policy.json"
+
+// loginDefsFile holds distro defaults of shadow-utils.
+const loginDefsFile = "/etc/login.defs"
+
+// ErrDeniedUser is returned if account policy does not allow to manage user.
+var ErrDeniedUser = errors.New("user is denied by account policy")
+
+// AccountPolicy restricts which users agent manages and defines how users are created.
+// User and group lists hold names or shell patterns, e.g. "svc-*".
+type AccountPolicy struct {
+	// UIDMin is lowest UID of regular users, users below are treated as system ones. Read from login.defs if not set.
+	UIDMin int `json:"uidMin,omitempty"`
+	// UIDMax is highest UID allocated for created users. Read from login.defs if not set.
+	UIDMax int `json:"uidMax,omitempty"`
+
+	// AllowUsers, if not empty, lists only users which could be managed.
+	AllowUsers []string `json:"allowUsers,omitempty"`
+	// DenyUsers lists users which must never be managed, takes precedence over AllowUsers.
+	DenyUsers []string `json:"denyUsers,omitempty"`
+	// AllowGroups, if not empty, requires existing user to be member of one of groups.
+	AllowGroups []string `json:"allowGroups,omitempty"`
+	// DenyGroups forbids to manage existing members of groups and to add users to them.
+	DenyGroups []string `json:"denyGroups,omitempty"`
+
+	// Shell is login shell of created users.
+	Shell string `json:"shell,omitempty"`
+	// HomeBase is directory, where home directories of created users are placed.
+	HomeBase string `json:"homeBase,omitempty"`
+	// SkelDir is copied into home directories of created users.
+	SkelDir string `json:"skelDir,omitempty"`
+	// ExtraGroups are added to created users supplementary groups.
+	ExtraGroups []string `json:"extraGroups,omitempty"`
+}
+
+// DefaultAccountPolicy matches behaviour of shadow-utils with distro defaults.
+// Shell, home base and skeleton directory are left empty, so account tools use their own defaults.
+func DefaultAccountPolicy() AccountPolicy {
+	return AccountPolicy{
+		UIDMin: uidMin,
+		UIDMax: uidMax,
+	}
+}
+
+// LoadAccountPolicy reads policy from JSON file, missing fields are filled from login.defs and defaults.
+// Missing file is not an error, default policy is returned then.
+func LoadAccountPolicy(fsys afero.Fs, file string) (AccountPolicy, error) {
+	var p AccountPolicy
+
+	content, err := afero.ReadFile(fsys, file)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return p, err
+	default:
+		if err = json.Unmarshal(content, &p); err != nil {
+			return p, fmt.Errorf("failed to parse account policy %v: %w", file, err)
+		}
+	}
+
+	defs := readLoginDefs(fsys)
+	d := DefaultAccountPolicy()
+	if p.UIDMin == 0 {
+		p.UIDMin = defs.int("UID_MIN", d.UIDMin)
+	}
+	if p.UIDMax == 0 {
+		p.UIDMax = defs.int("UID_MAX", d.UIDMax)
+	}
+
+	return p, p.validate()
+}
+
+func (p AccountPolicy) validate() error {
+	if p.UIDMin <= 0 || p.UIDMax < p.UIDMin {
+		return fmt.Errorf("invalid account policy UID range [%v, %v]", p.UIDMin, p.UIDMax)
+	}
+	for _, d := range []string{p.Shell, p.HomeBase, p.SkelDir} {
+		if d != "" && !path.IsAbs(d) {
+			return fmt.Errorf("invalid account policy path %q, must be absolute", d)
+		}
+	}
+	for _, l := range [][]string{p.AllowUsers, p.DenyUsers, p.AllowGroups, p.DenyGroups} {
+		for _, pattern := range l {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid account policy pattern %q: %w", pattern, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// matchAny tells if name matches any of patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkUsername checks username against user lists.
+func (p AccountPolicy) checkUsername(username string) error {
+	if matchAny(p.DenyUsers, username) {
+		return fmt.Errorf("%w: %v is in deny list", ErrDeniedUser, username)
+	}
+	if len(p.AllowUsers) > 0 && !matchAny(p.AllowUsers, username) {
+		return fmt.Errorf("%w: %v is not in allow list", ErrDeniedUser, username)
+	}
+
+	return nil
+}
+
+// checkGroups checks groups of existing user against group lists.
+func (p AccountPolicy) checkGroups(username string, groups []string) error {
+	allowed := len(p.AllowGroups) == 0
+	for _, g := range groups {
+		if matchAny(p.DenyGroups, g) {
+			return fmt.Errorf("%w: %v is member of denied group %v", ErrDeniedUser, username, g)
+		}
+		if matchAny(p.AllowGroups, g) {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %v is not member of allowed groups", ErrDeniedUser, username)
+	}
+
+	return nil
+}
+
+// checkGroup tells if user could be added to group.
+func (p AccountPolicy) checkGroup(group string) error {
+	if matchAny(p.DenyGroups, group) {
+		return fmt.Errorf("%w: group %v is denied", ErrDeniedUser, group)
+	}
+
+	return nil
+}
+
+// userGroups returns names of primary and supplementary groups of existing user, or nil if there is no such user.
+func (m *Manager) userGroups(username string) ([]string, error) {
+	pw, err := m.findEntry(passwdFile, username)
+	if err != nil || len(pw) < 4 {
+		return nil, err
+	}
+
+	content, err := afero.ReadFile(m.fs, groupFile)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []string{}
+	s := bufio.NewScanner(bytes.NewReader(content))
+	for s.Scan() {
+		f := strings.Split(s.Text(), ":")
+		if len(f) < 4 {
+			continue
+		}
+		if f[2] == pw[3] {
+			groups = append(groups, f[0])
+			continue
+		}
+		for _, member := range strings.Split(f[3], ",") {
+			if member == username {
+				groups = append(groups, f[0])
+				break
+			}
+		}
+	}
+
+	return groups, s.Err()
+}
+
+// homeDir returns home directory of user created with policy.
+func (p AccountPolicy) homeDir(username string) string {
+	if p.HomeBase == "" {
+		return path.Join(defaultHomeBase, username)
+	}
+
+	return path.Join(p.HomeBase, username)
+}
+
+// createOptions returns options for accounts backend.
+func (p AccountPolicy) createOptions() createOptions {
+	return createOptions{
+		Shell:    p.Shell,
+		HomeBase: p.HomeBase,
+		SkelDir:  p.SkelDir,
+		UIDMin:   p.UIDMin,
+		UIDMax:   p.UIDMax,
+	}
+}
+
+type accountPolicyKey struct{}
+
+// NewAccountPolicyContext stores account policy in context, managers created with that context follow it.
+func NewAccountPolicyContext(ctx context.Context, p AccountPolicy) context.Context {
+	return context.WithValue(ctx, accountPolicyKey{}, p)
+}
+
+// accountPolicyFromContext returns policy stored in context or default one.
+func accountPolicyFromContext(ctx context.Context) AccountPolicy {
+	if p, ok := ctx.Value(accountPolicyKey{}).(AccountPolicy); ok {
+		return p
+	}
+
+	return DefaultAccountPolicy()
+}
+
+// loginDefs is key-value content of login.defs.
+type loginDefs map[string]string
+
+func readLoginDefs(fsys afero.Fs) loginDefs {
+	defs := make(loginDefs)
+
+	content, err := afero.ReadFile(fsys, loginDefsFile)
+	if err != nil {
+		return defs
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(content))
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) < 2 || strings.HasPrefix(f[0], "#") {
+			continue
+		}
+		defs[f[0]] = f[1]
+	}
+
+	return defs
+}
+
+func (d loginDefs) int(k string, def int) int {
+	v, err := strconv.Atoi(d[k])
+	if err != nil {
+		return def
+	}
+
+	return v
+}
@@ -0,0 +1,135 @@
+package usermanager
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadAccountPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    AccountPolicy
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			want: AccountPolicy{UIDMin: uidMin, UIDMax: uidMax},
+		},
+		{
+			name:  "login.defs",
+			files: map[string]string{loginDefsFile: "# comment\nUID_MIN\t\t 500\nUID_MAX 30000\nGID_MIN 500\n"},
+			want:  AccountPolicy{UIDMin: 500, UIDMax: 30000},
+		},
+		{
+			name: "policy file overrides login.defs",
+			files: map[string]string{
+				loginDefsFile:            "UID_MIN 500\n",
+				DefaultAccountPolicyFile: `{"uidMin": 2000, "shell": "/bin/zsh", "denyUsers": ["svc-*"], "extraGroups": ["users"]}`,
+			},
+			want: AccountPolicy{UIDMin: 2000, UIDMax: uidMax, Shell: "/bin/zsh", DenyUsers: []string{"svc-*"}, ExtraGroups: []string{"users"}},
+		},
+		{
+			name:    "relative home base",
+			files:   map[string]string{DefaultAccountPolicyFile: `{"homeBase": "home"}`},
+			wantErr: true,
+		},
+		{
+			name:    "bad pattern",
+			files:   map[string]string{DefaultAccountPolicyFile: `{"allowUsers": ["["]}`},
+			wantErr: true,
+		},
+		{
+			name:    "broken file",
+			files:   map[string]string{DefaultAccountPolicyFile: `{`},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			for n, c := range tt.files {
+				require.NoError(t, afero.WriteFile(fs, n, []byte(c), 0644))
+			}
+
+			got, err := LoadAccountPolicy(fs, DefaultAccountPolicyFile)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAccountPolicy_checkUsername(t *testing.T) {
+	p := AccountPolicy{AllowUsers: []string{"alice", "svc-*"}, DenyUsers: []string{"svc-backup"}}
+
+	assert.NoError(t, p.checkUsername("alice"))
+	assert.NoError(t, p.checkUsername("svc-web"))
+	assert.ErrorIs(t, p.checkUsername("svc-backup"), ErrDeniedUser)
+	assert.ErrorIs(t, p.checkUsername("bob"), ErrDeniedUser)
+	assert.NoError(t, AccountPolicy{}.checkUsername("bob"))
+}
+
+func TestManager_ValidateUser_Groups(t *testing.T) {
+	files := map[string]string{
+		passwdFile: "alice:x:1001:1001::/home/alice:/bin/bash\nbob:x:1002:100::/home/bob:/bin/bash\n",
+		groupFile:  "users:x:100:\nalice:x:1001:\ndocker:x:998:bob\n",
+	}
+
+	tests := []struct {
+		name     string
+		policy   AccountPolicy
+		username string
+		wantErr  error
+	}{
+		{name: "no restrictions", policy: AccountPolicy{}, username: "bob"},
+		{name: "primary group allowed", policy: AccountPolicy{AllowGroups: []string{"users"}}, username: "bob"},
+		{name: "not in allowed group", policy: AccountPolicy{AllowGroups: []string{"users"}}, username: "alice", wantErr: ErrDeniedUser},
+		{name: "supplementary group denied", policy: AccountPolicy{DenyGroups: []string{"docker"}}, username: "bob", wantErr: ErrDeniedUser},
+		{name: "new user", policy: AccountPolicy{AllowGroups: []string{"users"}}, username: "carol"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.policy.UIDMin, tt.policy.UIDMax = uidMin, uidMax
+			m, _ := newTestManager(NewAccountPolicyContext(context.Background(), tt.policy), files)
+
+			err := m.ValidateUser(tt.username)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.Is(err, tt.wantErr), "ValidateUser() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestManager_CreateUser_Policy(t *testing.T) {
+	p := AccountPolicy{
+		UIDMin:      2000,
+		UIDMax:      3000,
+		Shell:       "/bin/zsh",
+		HomeBase:    "/srv/home",
+		SkelDir:     "/etc/skel.guest",
+		ExtraGroups: []string{"users"},
+	}
+	m, e := newTestManager(NewAccountPolicyContext(context.Background(), p), nil)
+
+	require.NoError(t, m.CreateUser("alice"))
+	assert.Equal(t, []string{
+		"useradd --create-home --key UID_MIN=2000 --key UID_MAX=3000 --shell /bin/zsh --base-dir /srv/home --skel /etc/skel.guest alice",
+		"usermod --append --groups users alice",
+	}, e.commands)
+
+	p.DenyGroups = []string{"users"}
+	m, e = newTestManager(NewAccountPolicyContext(context.Background(), p), nil)
+	assert.ErrorIs(t, m.CreateUser("alice"), ErrDeniedUser)
+	assert.Empty(t, e.commands)
+}
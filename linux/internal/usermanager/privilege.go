@@ -0,0 +1,295 @@
+package usermanager
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"marketplace-yaga/linux/internal/audit"
+	"marketplace-yaga/linux/internal/executor/argument"
+	"marketplace-yaga/linux/internal/executor/command"
+	"marketplace-yaga/linux/internal/plan"
+	"marketplace-yaga/pkg/logger"
+	"path"
+	"strings"
+
+	"github.com/spf13/afero"
+	"go.uber.org/zap"
+)
+
+// AdminPolicy defines how users are granted administrator privileges.
+type AdminPolicy string
+
+const (
+	// AdminGroup appends user to distro's admin group (sudo, wheel or admin),
+	// falls back to AdminSudoers if there is no such group.
+	AdminGroup AdminPolicy = "group"
+	// AdminSudoers writes sudoers drop-in, which requires password.
+	AdminSudoers AdminPolicy = "sudoers"
+	// AdminSudoersNoPasswd writes sudoers drop-in with passwordless sudo.
+	AdminSudoersNoPasswd AdminPolicy = "sudoers-nopasswd"
+	// AdminNone grants nothing.
+	AdminNone AdminPolicy = "none"
+)
+
+// AdminPolicies lists all known policies.
+var AdminPolicies = []AdminPolicy{AdminGroup, AdminSudoers, AdminSudoersNoPasswd, AdminNone}
+
+// ErrUnknownAdminPolicy is returned on parse of unknown policy.
+var ErrUnknownAdminPolicy = errors.New("unknown admin policy")
+
+// ParseAdminPolicy returns policy by its name.
+func ParseAdminPolicy(s string) (AdminPolicy, error) {
+	for _, p := range AdminPolicies {
+		if string(p) == s {
+			return p, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %v", ErrUnknownAdminPolicy, s)
+}
+
+type adminPolicyKey struct{}
+
+// NewAdminPolicyContext stores admin policy in context, managers created with that context follow it.
+func NewAdminPolicyContext(ctx context.Context, p AdminPolicy) context.Context {
+	return context.WithValue(ctx, adminPolicyKey{}, p)
+}
+
+// adminPolicyFromContext returns policy stored in context or AdminGroup.
+func adminPolicyFromContext(ctx context.Context) AdminPolicy {
+	if p, ok := ctx.Value(adminPolicyKey{}).(AdminPolicy); ok {
+		return p
+	}
+
+	return AdminGroup
+}
+
+const (
+	groupFile     = "/etc/group"
+	osReleaseFile = "/etc/os-release"
+	sudoersDir    = "/etc/sudoers.d"
+)
+
+// adminGroupsByDistro maps os-release ID or ID_LIKE to admin group, which is allowed to sudo by default.
+var adminGroupsByDistro = map[string]string{
+	"debian":   "sudo",
+	"ubuntu":   "sudo",
+	"rhel":     "wheel",
+	"fedora":   "wheel",
+	"centos":   "wheel",
+	"suse":     "wheel",
+	"alpine":   "wheel",
+	"arch":     "wheel",
+	"altlinux": "wheel",
+}
+
+// fallbackAdminGroups are tried in order if distro is unknown.
+var fallbackAdminGroups = []string{"sudo", "wheel", "admin"}
+
+// AddToAdministrators grants user administrator privileges according to admin policy from context.
+func (m *Manager) AddToAdministrators(username string) error {
+	p := adminPolicyFromContext(m.ctx)
+
+	logger.DebugCtx(m.ctx, nil, "add to administrators",
+		zap.String("username", username),
+		zap.String("policy", string(p)))
+
+	switch p {
+	case AdminNone:
+		return nil
+	case AdminSudoers, AdminSudoersNoPasswd:
+		return m.writeSudoersDropIn(username, p == AdminSudoersNoPasswd)
+	}
+
+	group, err := m.detectAdminGroup()
+	if err != nil {
+		return err
+	}
+	if group == "" {
+		logger.InfoCtx(m.ctx, nil, "no admin group found, fall back to sudoers drop-in",
+			zap.String("username", username))
+
+		return m.writeSudoersDropIn(username, false)
+	}
+
+	return m.addToGroup(username, group)
+}
+
+// addToGroup appends group to user supplementary groups, keeping existing ones.
+func (m *Manager) addToGroup(username, group string) error {
+	if err := accountPolicyFromContext(m.ctx).checkGroup(group); err != nil {
+		return err
+	}
+
+	a := plan.Action{Kind: plan.AddToGroup, Target: username, Detail: group}
+	if plan.Record(m.ctx, a) {
+		return nil
+	}
+
+	err := m.backend.AddToGroup(username, group)
+	audit.Record(m.ctx, a, err)
+
+	return err
+}
+
+// detectAdminGroup returns admin group of distro, if it exists. Empty group is returned if none found.
+func (m *Manager) detectAdminGroup() (string, error) {
+	groups, err := m.localGroups()
+	if err != nil {
+		return "", err
+	}
+
+	for _, id := range m.distroIDs() {
+		if g, ok := adminGroupsByDistro[id]; ok {
+			if _, exist := groups[g]; exist {
+				return g, nil
+			}
+		}
+	}
+
+	for _, g := range fallbackAdminGroups {
+		if _, exist := groups[g]; exist {
+			return g, nil
+		}
+	}
+
+	return "", nil
+}
+
+// distroIDs returns os-release ID followed by ID_LIKE entries.
+func (m *Manager) distroIDs() []string {
+	content, err := afero.ReadFile(m.fs, osReleaseFile)
+	if err != nil {
+		logger.DebugCtx(m.ctx, err, "read os-release")
+		return nil
+	}
+
+	var id, like []string
+	s := bufio.NewScanner(bytes.NewReader(content))
+	for s.Scan() {
+		k, v, ok := strings.Cut(s.Text(), "=")
+		if !ok {
+			continue
+		}
+		v = strings.Trim(v, `"'`)
+		switch k {
+		case "ID":
+			id = append(id, v)
+		case "ID_LIKE":
+			like = append(like, strings.Fields(v)...)
+		}
+	}
+
+	return append(id, like...)
+}
+
+// localGroups returns set of group names from group file.
+func (m *Manager) localGroups() (map[string]struct{}, error) {
+	content, err := afero.ReadFile(m.fs, groupFile)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]struct{})
+	s := bufio.NewScanner(bytes.NewReader(content))
+	for s.Scan() {
+		if name, _, ok := strings.Cut(s.Text(), ":"); ok && name != "" && !strings.HasPrefix(name, "#") {
+			groups[name] = struct{}{}
+		}
+	}
+
+	return groups, s.Err()
+}
+
+// sudoersDropInNameEscaper hex-escapes dots, which sudo skips files with, and underscore, which is escape character
+// itself, so different usernames never share drop-in.
+var sudoersDropInNameEscaper = strings.NewReplacer("_", "_5f", ".", "_2e")
+
+// sudoersDropInPath returns path of drop-in for user.
+func sudoersDropInPath(username string) string {
+	return path.Join(sudoersDir, "90-yandex-guest-agent-"+sudoersDropInNameEscaper.Replace(username))
+}
+
+// writeSudoersDropIn writes sudoers drop-in for user. Drop-in is validated with visudo before it is put in place,
+// so broken file could never lock out sudo.
+func (m *Manager) writeSudoersDropIn(username string, noPasswd bool) (err error) {
+	rule := "ALL"
+	if noPasswd {
+		rule = "NOPASSWD: ALL"
+	}
+	content := fmt.Sprintf("# managed by yandex-guest-agent\n%v ALL=(ALL:ALL) %v\n", username, rule)
+	dropIn := sudoersDropInPath(username)
+
+	a := plan.Action{Kind: plan.WriteFile, Target: dropIn, Detail: content}
+	if plan.Record(m.ctx, a) {
+		return nil
+	}
+	defer func() { audit.Record(m.ctx, a, err) }()
+
+	if err = m.fs.MkdirAll(sudoersDir, 0750); err != nil {
+		return err
+	}
+
+	// dot in name hides temporary file from sudo
+	tmp := path.Join(sudoersDir, "."+path.Base(dropIn)+".tmp")
+	if err = afero.WriteFile(m.fs, tmp, []byte(content), 0440); err != nil {
+		return err
+	}
+
+	if err = m.validateSudoers(tmp); err != nil {
+		_ = m.fs.Remove(tmp)
+		return err
+	}
+
+	if err = m.fs.Rename(tmp, dropIn); err != nil {
+		_ = m.fs.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// validateSudoers checks syntax of sudoers file with visudo.
+func (m *Manager) validateSudoers(file string) error {
+	cmd, err := command.New(
+		argument.New("visudo"),
+		argument.New("-c"),
+		argument.New("-q"),
+		argument.New("-f"),
+		argument.New(file))
+	if err != nil {
+		return fmt.Errorf("failed construct command: %w", err)
+	}
+
+	if err = m.executor.Run(cmd); err != nil {
+		return fmt.Errorf("sudoers drop-in validation failed: %w", err)
+	}
+
+	return nil
+}
+
+// removeSudoersDropIn removes user sudoers drop-in, if there is one.
+func (m *Manager) removeSudoersDropIn(username string) error {
+	dropIn := sudoersDropInPath(username)
+
+	_, err := m.fs.Stat(dropIn)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	a := plan.Action{Kind: plan.RemoveFile, Target: dropIn}
+	if plan.Record(m.ctx, a) {
+		return nil
+	}
+
+	err = m.fs.Remove(dropIn)
+	audit.Record(m.ctx, a, err)
+
+	return err
+}
@@ -0,0 +1,155 @@
+package usermanager
+
+import (
+	"context"
+	"errors"
+	"marketplace-yaga/linux/internal/executor/command"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeExecutor struct {
+	commands []string
+	err      error
+}
+
+func (e *fakeExecutor) Run(c *command.Command) error {
+	e.commands = append(e.commands, c.String())
+
+	return e.err
+}
+
+func newTestManager(ctx context.Context, files map[string]string) (*Manager, *fakeExecutor) {
+	fs := afero.NewMemMapFs()
+	for n, c := range files {
+		_ = afero.WriteFile(fs, n, []byte(c), 0644)
+	}
+	e := &fakeExecutor{}
+
+	return &Manager{ctx: ctx, fs: fs, executor: e, backend: &toolsBackend{executor: e}}, e
+}
+
+func TestManager_AddToAdministrators_Group(t *testing.T) {
+	tests := []struct {
+		name      string
+		osRelease string
+		groups    string
+		want      string
+	}{
+		{
+			name:      "ubuntu",
+			osRelease: "ID=ubuntu\nID_LIKE=debian\n",
+			groups:    "root:x:0:\nsudo:x:27:\n",
+			want:      "usermod --append --groups sudo alice",
+		},
+		{
+			name:      "centos",
+			osRelease: "ID=\"centos\"\nID_LIKE=\"rhel fedora\"\n",
+			groups:    "root:x:0:\nwheel:x:10:\n",
+			want:      "usermod --append --groups wheel alice",
+		},
+		{
+			name:      "rocky via ID_LIKE",
+			osRelease: "ID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\n",
+			groups:    "root:x:0:\nwheel:x:10:\nsudo:x:27:\n",
+			want:      "usermod --append --groups wheel alice",
+		},
+		{
+			name:   "unknown distro",
+			groups: "root:x:0:\nadmin:x:110:\n",
+			want:   "usermod --append --groups admin alice",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{groupFile: tt.groups}
+			if tt.osRelease != "" {
+				files[osReleaseFile] = tt.osRelease
+			}
+			m, e := newTestManager(context.Background(), files)
+
+			require.NoError(t, m.AddToAdministrators("alice"))
+			assert.Equal(t, []string{tt.want}, e.commands)
+		})
+	}
+}
+
+func TestManager_AddToAdministrators_Sudoers(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy AdminPolicy
+		groups string
+		want   string
+	}{
+		{
+			name:   "no admin group",
+			policy: AdminGroup,
+			groups: "root:x:0:\n",
+			want:   "alice.smith ALL=(ALL:ALL) ALL\n",
+		},
+		{
+			name:   "sudoers",
+			policy: AdminSudoers,
+			want:   "alice.smith ALL=(ALL:ALL) ALL\n",
+		},
+		{
+			name:   "passwordless",
+			policy: AdminSudoersNoPasswd,
+			want:   "alice.smith ALL=(ALL:ALL) NOPASSWD: ALL\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := NewAdminPolicyContext(context.Background(), tt.policy)
+			m, e := newTestManager(ctx, map[string]string{groupFile: tt.groups, osReleaseFile: "ID=alpine\n"})
+
+			require.NoError(t, m.AddToAdministrators("alice.smith"))
+
+			dropIn := "/etc/sudoers.d/90-yandex-guest-agent-alice_2esmith"
+			content, err := afero.ReadFile(m.fs, dropIn)
+			require.NoError(t, err)
+			assert.Contains(t, string(content), tt.want)
+			assert.Equal(t, []string{"visudo -c -q -f /etc/sudoers.d/.90-yandex-guest-agent-alice_2esmith.tmp"}, e.commands)
+		})
+	}
+}
+
+func TestSudoersDropInPath(t *testing.T) {
+	assert.Equal(t, "/etc/sudoers.d/90-yandex-guest-agent-alice", sudoersDropInPath("alice"))
+	assert.NotEqual(t, sudoersDropInPath("alice.smith"), sudoersDropInPath("alice_smith"))
+	assert.NotEqual(t, sudoersDropInPath("alice_2esmith"), sudoersDropInPath("alice.smith"))
+	assert.NotContains(t, sudoersDropInPath("alice.smith"), "alice.smith")
+}
+
+func TestManager_AddToAdministrators_InvalidSudoers(t *testing.T) {
+	ctx := NewAdminPolicyContext(context.Background(), AdminSudoers)
+	m, e := newTestManager(ctx, nil)
+	e.err = errors.New("parse error")
+
+	require.Error(t, m.AddToAdministrators("alice"))
+
+	files, err := afero.ReadDir(m.fs, sudoersDir)
+	require.NoError(t, err)
+	assert.Empty(t, files, "invalid drop-in must not be left in sudoers.d")
+}
+
+func TestManager_AddToAdministrators_None(t *testing.T) {
+	m, e := newTestManager(NewAdminPolicyContext(context.Background(), AdminNone), nil)
+
+	require.NoError(t, m.AddToAdministrators("alice"))
+	assert.Empty(t, e.commands)
+}
+
+func TestParseAdminPolicy(t *testing.T) {
+	for _, p := range AdminPolicies {
+		got, err := ParseAdminPolicy(string(p))
+		require.NoError(t, err)
+		assert.Equal(t, p, got)
+	}
+
+	_, err := ParseAdminPolicy("root")
+	assert.ErrorIs(t, err, ErrUnknownAdminPolicy)
+}
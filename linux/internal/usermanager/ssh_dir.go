@@ -0,0 +1,262 @@
+package usermanager
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+
+	"github.com/spf13/afero"
+	"golang.org/x/sys/unix"
+	"marketplace-yaga/linux/internal/executor/argument"
+	"marketplace-yaga/linux/internal/executor/command"
+)
+
+// ErrUnsafePath is returned, when user controlled path could redirect root reads or writes elsewhere.
+var ErrUnsafePath = errors.New("unsafe path")
+
+// selinuxEnforceFile exists, when selinuxfs is mounted, i.e. SELinux is enabled.
+const selinuxEnforceFile = "/sys/fs/selinux/enforce"
+
+// Files agent keeps in user .ssh dir besides authorized_keys.
+const (
+	userTmpFile              = ".yandex-guest-agent.tmp"
+	authorizedKeysBackupFile = "authorized_keys.yandex-guest-agent.bak"
+)
+
+// sshDir is opened user .ssh dir. Its content is user controlled, so files are opened, checked and replaced
+// relative to opened dir, never by path checked beforehand, and symlinks are not followed.
+type sshDir interface {
+	// readFile returns content of regular file owned by user or root.
+	readFile(name string) ([]byte, error)
+	// writeFile atomically replaces file with content owned by user.
+	writeFile(name, content string) error
+	Close() error
+}
+
+// openSshDir opens user .ssh dir, creating it owned by user if create is set.
+// Home dir itself is trusted, as it is created by agent or useradd, but its content is user controlled.
+func (m *Manager) openSshDir(u *user.User, create bool) (sshDir, error) {
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+	dir := path.Join(u.HomeDir, ".ssh")
+
+	if _, ok := m.fs.(*afero.OsFs); !ok {
+		return openFsSshDir(m.fs, dir, uid, gid, create)
+	}
+
+	return openOsSshDir(dir, uid, gid, create)
+}
+
+// osSshDir is .ssh dir opened with O_NOFOLLOW, files are accessed with *at syscalls relative to it.
+type osSshDir struct {
+	fd       int
+	path     string
+	uid, gid int
+}
+
+func openOsSshDir(dir string, uid, gid int, create bool) (*osSshDir, error) {
+	flags := unix.O_RDONLY | unix.O_DIRECTORY | unix.O_NOFOLLOW | unix.O_CLOEXEC
+	fd, err := unix.Open(dir, flags, 0)
+	created := false
+	if errors.Is(err, unix.ENOENT) && create {
+		if err = unix.Mkdir(dir, 0700); err != nil && !errors.Is(err, unix.EEXIST) {
+			return nil, &fs.PathError{Op: "mkdir", Path: dir, Err: err}
+		}
+		created = err == nil
+		fd, err = unix.Open(dir, flags, 0)
+	}
+	if errors.Is(err, unix.ELOOP) || errors.Is(err, unix.ENOTDIR) {
+		return nil, fmt.Errorf("%w: %v is symlink or not a directory", ErrUnsafePath, dir)
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: dir, Err: err}
+	}
+
+	d := &osSshDir{fd: fd, path: dir, uid: uid, gid: gid}
+	var st unix.Stat_t
+	if err = unix.Fstat(fd, &st); err != nil {
+		_ = d.Close()
+		return nil, &fs.PathError{Op: "stat", Path: dir, Err: err}
+	}
+	if err = d.checkOwner(dir, st.Uid); err != nil {
+		_ = d.Close()
+		return nil, err
+	}
+
+	// dir is passed to user through opened descriptor, so it could not be redirected by replacing it
+	if created {
+		if err = unix.Fchown(fd, uid, gid); err != nil {
+			_ = d.Close()
+			return nil, &fs.PathError{Op: "chown", Path: dir, Err: err}
+		}
+	}
+
+	return d, nil
+}
+
+func (d *osSshDir) checkOwner(name string, uid uint32) error {
+	if uid != 0 && int(uid) != d.uid {
+		return fmt.Errorf("%w: %v is owned by uid %d", ErrUnsafePath, name, uid)
+	}
+
+	return nil
+}
+
+func (d *osSshDir) readFile(name string) ([]byte, error) {
+	p := path.Join(d.path, name)
+	// non-blocking open does not hang on fifo user could put instead of file
+	fd, err := unix.Openat(d.fd, name, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if errors.Is(err, unix.ELOOP) {
+		return nil, fmt.Errorf("%w: %v is symlink", ErrUnsafePath, p)
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: err}
+	}
+	f := os.NewFile(uintptr(fd), p)
+	defer func() { _ = f.Close() }()
+
+	var st unix.Stat_t
+	if err = unix.Fstat(fd, &st); err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: p, Err: err}
+	}
+	// hard link could make root owned file look like one in user dir
+	if st.Mode&unix.S_IFMT != unix.S_IFREG || st.Nlink > 1 {
+		return nil, fmt.Errorf("%w: %v is not a regular file", ErrUnsafePath, p)
+	}
+	if err = d.checkOwner(p, st.Uid); err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(f)
+}
+
+// writeFile writes content into temporary file, which is synced and passed to user through its descriptor
+// before it is renamed over file. Rename replaces symlink or hard link at file path rather than writing through it.
+func (d *osSshDir) writeFile(name, content string) error {
+	// leftover of interrupted write, or anything user put there, unlink does not follow symlinks
+	if err := unix.Unlinkat(d.fd, userTmpFile, 0); err != nil && !errors.Is(err, unix.ENOENT) {
+		return &fs.PathError{Op: "remove", Path: path.Join(d.path, userTmpFile), Err: err}
+	}
+
+	tmp := path.Join(d.path, userTmpFile)
+	fd, err := unix.Openat(d.fd, userTmpFile, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0600)
+	if err != nil {
+		return &fs.PathError{Op: "open", Path: tmp, Err: err}
+	}
+	f := os.NewFile(uintptr(fd), tmp)
+	err = d.fill(f, content)
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		if err = unix.Renameat(d.fd, userTmpFile, d.fd, name); err != nil {
+			err = &fs.PathError{Op: "rename", Path: path.Join(d.path, name), Err: err}
+		}
+	}
+	if err != nil {
+		_ = unix.Unlinkat(d.fd, userTmpFile, 0)
+		return err
+	}
+
+	// makes rename durable
+	if err = unix.Fsync(d.fd); err != nil {
+		return &fs.PathError{Op: "sync", Path: d.path, Err: err}
+	}
+
+	return nil
+}
+
+func (d *osSshDir) fill(f *os.File, content string) error {
+	if _, err := f.WriteString(content); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Chmod(0600); err != nil {
+		return err
+	}
+
+	return f.Chown(d.uid, d.gid)
+}
+
+func (d *osSshDir) Close() error {
+	return unix.Close(d.fd)
+}
+
+// fsSshDir is .ssh dir on file system, which is not backed by OS, e.g. in memory one in tests.
+// Such file systems have neither symlinks nor owners, so files are accessed by path.
+type fsSshDir struct {
+	fs       afero.Fs
+	path     string
+	uid, gid int
+}
+
+func openFsSshDir(fsys afero.Fs, dir string, uid, gid int, create bool) (*fsSshDir, error) {
+	fi, err := fsys.Stat(dir)
+	if errors.Is(err, fs.ErrNotExist) && create {
+		if err = fsys.Mkdir(dir, 0700); err != nil {
+			return nil, err
+		}
+		if err = fsys.Chown(dir, uid, gid); err != nil {
+			return nil, err
+		}
+		fi, err = fsys.Stat(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%w: %v is not a directory", ErrUnsafePath, dir)
+	}
+
+	return &fsSshDir{fs: fsys, path: dir, uid: uid, gid: gid}, nil
+}
+
+func (d *fsSshDir) readFile(name string) ([]byte, error) {
+	return afero.ReadFile(d.fs, path.Join(d.path, name))
+}
+
+func (d *fsSshDir) writeFile(name, content string) error {
+	tmp := path.Join(d.path, userTmpFile)
+	if err := afero.WriteFile(d.fs, tmp, []byte(content), 0600); err != nil {
+		return err
+	}
+	if err := d.fs.Chown(tmp, d.uid, d.gid); err != nil {
+		_ = d.fs.Remove(tmp)
+		return err
+	}
+
+	return d.fs.Rename(tmp, path.Join(d.path, name))
+}
+
+func (d *fsSshDir) Close() error {
+	return nil
+}
+
+// restoreSecurityContext resets SELinux labels of user .ssh dir, as files created by agent get label of agent
+// domain, which sshd is not allowed to read.
+func (m *Manager) restoreSecurityContext(userSshDir string) error {
+	if ok, _ := afero.Exists(m.fs, selinuxEnforceFile); !ok {
+		return nil
+	}
+
+	cmd, err := command.New(
+		argument.New("restorecon"),
+		argument.New("-R"),
+		argument.New(userSshDir))
+	if err != nil {
+		return fmt.Errorf("failed construct command: %w", err)
+	}
+
+	if err = m.executor.Run(cmd); err != nil {
+		return fmt.Errorf("failed to restore SELinux context of %v: %w", userSshDir, err)
+	}
+
+	return nil
+}
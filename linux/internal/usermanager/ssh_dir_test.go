@@ -0,0 +1,116 @@
+package usermanager
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newOsTestManager returns manager working with real files, owned by current user, in temporary home dir.
+func newOsTestManager(t *testing.T) (*Manager, *user.User) {
+	t.Helper()
+
+	m, _ := newTestManager(context.Background(), nil)
+	m.fs = afero.NewOsFs()
+	u := &user.User{Username: "alice", Uid: strconv.Itoa(os.Getuid()), Gid: strconv.Itoa(os.Getgid()), HomeDir: t.TempDir()}
+
+	return m, u
+}
+
+func TestManager_writeAuthorizedKeys(t *testing.T) {
+	m, u := newOsTestManager(t)
+	sshDir := path.Join(u.HomeDir, ".ssh")
+	file := path.Join(sshDir, authorizedKeysFile)
+
+	require.NoError(t, m.writeAuthorizedKeys(u, nil, "ssh-ed25519 A\n"))
+	require.NoError(t, m.writeAuthorizedKeys(u, []byte("ssh-ed25519 A\n"), "ssh-ed25519 B\n"))
+
+	content, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "ssh-ed25519 B\n", string(content))
+	backup, err := os.ReadFile(path.Join(sshDir, authorizedKeysBackupFile))
+	require.NoError(t, err)
+	assert.Equal(t, "ssh-ed25519 A\n", string(backup))
+
+	fi, err := os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	fi, err = os.Stat(sshDir)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), fi.Mode().Perm())
+	_, err = os.Lstat(path.Join(sshDir, userTmpFile))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestManager_SyncSshKeys_Symlinks(t *testing.T) {
+	t.Run("authorized_keys", func(t *testing.T) {
+		m, u := newOsTestManager(t)
+		target := path.Join(t.TempDir(), "shadow")
+		require.NoError(t, os.WriteFile(target, []byte("secret\n"), 0600))
+		require.NoError(t, os.Mkdir(path.Join(u.HomeDir, ".ssh"), 0700))
+		require.NoError(t, os.Symlink(target, path.Join(u.HomeDir, ".ssh", authorizedKeysFile)))
+
+		_, _, err := m.SyncSshKeys(u, []string{"ssh-ed25519 A"})
+		assert.ErrorIs(t, err, ErrUnsafePath)
+		content, err := os.ReadFile(target)
+		require.NoError(t, err)
+		assert.Equal(t, "secret\n", string(content))
+		_, err = os.Lstat(path.Join(u.HomeDir, ".ssh", authorizedKeysBackupFile))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("hard link", func(t *testing.T) {
+		m, u := newOsTestManager(t)
+		target := path.Join(u.HomeDir, "shadow")
+		require.NoError(t, os.WriteFile(target, []byte("secret\n"), 0600))
+		require.NoError(t, os.Mkdir(path.Join(u.HomeDir, ".ssh"), 0700))
+		require.NoError(t, os.Link(target, path.Join(u.HomeDir, ".ssh", authorizedKeysFile)))
+
+		_, _, err := m.SyncSshKeys(u, []string{"ssh-ed25519 A"})
+		assert.ErrorIs(t, err, ErrUnsafePath)
+		_, err = os.Lstat(path.Join(u.HomeDir, ".ssh", authorizedKeysBackupFile))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run(".ssh", func(t *testing.T) {
+		m, u := newOsTestManager(t)
+		target := t.TempDir()
+		require.NoError(t, os.Symlink(target, path.Join(u.HomeDir, ".ssh")))
+
+		_, _, err := m.SyncSshKeys(u, []string{"ssh-ed25519 A"})
+		assert.ErrorIs(t, err, ErrUnsafePath)
+		_, err = os.Stat(path.Join(target, authorizedKeysFile))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("temporary file", func(t *testing.T) {
+		m, u := newOsTestManager(t)
+		target := path.Join(t.TempDir(), "shadow")
+		require.NoError(t, os.WriteFile(target, []byte("secret\n"), 0600))
+		require.NoError(t, os.Mkdir(path.Join(u.HomeDir, ".ssh"), 0700))
+		require.NoError(t, os.Symlink(target, path.Join(u.HomeDir, ".ssh", userTmpFile)))
+
+		_, _, err := m.SyncSshKeys(u, []string{"ssh-ed25519 A"})
+		require.NoError(t, err)
+		content, err := os.ReadFile(target)
+		require.NoError(t, err)
+		assert.Equal(t, "secret\n", string(content))
+	})
+}
+
+func TestManager_writeAuthorizedKeys_SELinux(t *testing.T) {
+	u := &user.User{Username: "alice", Uid: "1001", Gid: "1001", HomeDir: "/home/alice"}
+
+	m, e := newTestManager(context.Background(), map[string]string{selinuxEnforceFile: "1\n"})
+	require.NoError(t, m.fs.MkdirAll(u.HomeDir, 0755))
+
+	require.NoError(t, m.writeAuthorizedKeys(u, nil, "ssh-ed25519 A\n"))
+	assert.Equal(t, []string{"restorecon -R /home/alice/.ssh"}, e.commands)
+}
@@ -0,0 +1,187 @@
+package usermanager
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"marketplace-yaga/linux/internal/audit"
+	"marketplace-yaga/linux/internal/plan"
+	"marketplace-yaga/pkg/logger"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	"go.uber.org/zap"
+)
+
+// shellsFile lists valid login shells.
+const shellsFile = "/etc/shells"
+
+// preferredLoginShells are tried in order, when login shell of account must be restored.
+var preferredLoginShells = []string{"/bin/bash", defaultShell}
+
+// AccountState describes conditions, which prevent user from logging in.
+type AccountState struct {
+	// Locked is set if password is locked with '!' prefix.
+	Locked bool `json:"locked"`
+	// Expired is set if account expiration date is in past.
+	Expired bool `json:"expired"`
+	// PasswordExpired is set if password is older than its maximum age or must be changed on next login.
+	// Login is still possible, but user has to change password.
+	PasswordExpired bool `json:"passwordExpired"`
+	// PasswordInactive is set if password expired longer than inactivity period ago, login with it is refused.
+	PasswordInactive bool `json:"passwordInactive"`
+	// NoLoginShell is set if login shell refuses logins, e.g. nologin or false.
+	NoLoginShell bool   `json:"noLoginShell"`
+	Shell        string `json:"shell"`
+}
+
+// CanLogin tells if none of conditions prevents user from logging in.
+func (s AccountState) CanLogin() bool {
+	return !s.Locked && !s.Expired && !s.PasswordInactive && !s.NoLoginShell
+}
+
+// isNoLoginShell tells if shell refuses logins.
+func isNoLoginShell(shell string) bool {
+	switch path.Base(shell) {
+	case "nologin", "false":
+		return true
+	}
+
+	return false
+}
+
+// AccountState reads state of user account from passwd and shadow files.
+func (m *Manager) AccountState(username string) (AccountState, error) {
+	var st AccountState
+
+	pw, err := m.findEntry(passwdFile, username)
+	if err != nil {
+		return st, err
+	}
+	if len(pw) < 7 {
+		return st, fmt.Errorf("%w: %v", ErrUserNotFound, username)
+	}
+	st.Shell = pw[6]
+	st.NoLoginShell = isNoLoginShell(st.Shell)
+
+	sp, err := m.findEntry(shadowFile, username)
+	if err != nil || len(sp) < 8 {
+		// without shadow entry password is in passwd file, where it could not be locked or expired
+		return st, nil
+	}
+	st.Locked = strings.HasPrefix(sp[1], "!")
+	if sp[7] != "" {
+		days, pErr := strconv.ParseInt(sp[7], 10, 64)
+		st.Expired = pErr == nil && days*secondsPerDay <= time.Now().Unix()
+	}
+	st.PasswordExpired, st.PasswordInactive = passwordExpiry(sp, time.Now().Unix()/secondsPerDay)
+
+	return st, nil
+}
+
+const secondsPerDay = int64(24 * time.Hour / time.Second)
+
+// passwordExpiry tells if password of shadow entry is expired or inactive on given day, counting from last change
+// date with maximum age and inactivity period, as login does. Empty fields disable the checks.
+func passwordExpiry(sp []string, today int64) (expired, inactive bool) {
+	field := func(i int) (int64, bool) {
+		v, err := strconv.ParseInt(sp[i], 10, 64)
+		return v, err == nil && v >= 0
+	}
+
+	lastChange, ok := field(2)
+	if !ok {
+		return false, false
+	}
+	// zero last change date forces password change on next login
+	if lastChange == 0 {
+		return true, false
+	}
+
+	maxAge, ok := field(4)
+	if !ok {
+		return false, false
+	}
+	expired = today-lastChange > maxAge
+
+	if inactivity, ok := field(6); ok {
+		inactive = today-lastChange > maxAge+inactivity
+	}
+
+	return expired, inactive
+}
+
+// findEntry returns fields of entry with name from colon separated file, or nil if there is no such entry.
+func (m *Manager) findEntry(file, name string) ([]string, error) {
+	content, err := afero.ReadFile(m.fs, file)
+	if err != nil {
+		return nil, err
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(content))
+	for s.Scan() {
+		f := strings.Split(s.Text(), ":")
+		if f[0] == name {
+			return f, nil
+		}
+	}
+
+	return nil, s.Err()
+}
+
+// ClearExpiry removes account expiration date, so expired account could be used again.
+func (m *Manager) ClearExpiry(username string) error {
+	logger.DebugCtx(m.ctx, nil, "clear account expiration date",
+		zap.String("username", username))
+
+	a := plan.Action{Kind: plan.ClearExpiry, Target: username}
+	if plan.Record(m.ctx, a) {
+		return nil
+	}
+
+	err := m.backend.ClearExpiry(username)
+	audit.Record(m.ctx, a, err)
+
+	return err
+}
+
+// RestoreLoginShell sets first available of preferred login shells and returns it.
+func (m *Manager) RestoreLoginShell(username string) (string, error) {
+	shell := m.loginShell()
+
+	logger.DebugCtx(m.ctx, nil, "restore login shell",
+		zap.String("username", username),
+		zap.String("shell", shell))
+
+	a := plan.Action{Kind: plan.SetShell, Target: username, Detail: shell}
+	if plan.Record(m.ctx, a) {
+		return shell, nil
+	}
+
+	err := m.backend.SetShell(username, shell)
+	audit.Record(m.ctx, a, err)
+
+	return shell, err
+}
+
+// loginShell returns first of preferred login shells, which is listed in shells file and exists.
+func (m *Manager) loginShell() string {
+	content, _ := afero.ReadFile(m.fs, shellsFile)
+	valid := strings.Fields(string(content))
+
+	for _, sh := range preferredLoginShells {
+		if _, err := m.fs.Stat(sh); err != nil {
+			continue
+		}
+		for _, v := range valid {
+			if v == sh {
+				return sh
+			}
+		}
+	}
+
+	return defaultShell
+}
@@ -0,0 +1,86 @@
+package usermanager
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager_AccountState(t *testing.T) {
+	m, _ := newTestManager(context.Background(), map[string]string{
+		passwdFile: "alice:x:1000:1000::/home/alice:/bin/bash\n" +
+			"bob:x:1001:1001::/home/bob:/usr/sbin/nologin\n" +
+			"carol:x:1002:1002::/home/carol:/bin/false\n" +
+			"erin:x:1003:1003::/home/erin:/bin/bash\n",
+		shadowFile: "alice:$6$salt$hash:19000:0:99999:7:::\n" +
+			"bob:!$6$salt$hash:19000:0:99999:7::1:\n" +
+			"erin:$6$salt$hash:19000:0:90:7:30::\n",
+	})
+
+	st, err := m.AccountState("alice")
+	require.NoError(t, err)
+	assert.True(t, st.CanLogin())
+
+	st, err = m.AccountState("bob")
+	require.NoError(t, err)
+	assert.Equal(t, AccountState{Locked: true, Expired: true, NoLoginShell: true, Shell: "/usr/sbin/nologin"}, st)
+
+	// password expired long ago
+	st, err = m.AccountState("erin")
+	require.NoError(t, err)
+	assert.Equal(t, AccountState{PasswordExpired: true, PasswordInactive: true, Shell: "/bin/bash"}, st)
+	assert.False(t, st.CanLogin())
+
+	// no shadow entry
+	st, err = m.AccountState("carol")
+	require.NoError(t, err)
+	assert.Equal(t, AccountState{NoLoginShell: true, Shell: "/bin/false"}, st)
+
+	_, err = m.AccountState("dave")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestPasswordExpiry(t *testing.T) {
+	const today = 19100
+
+	tests := []struct {
+		name         string
+		entry        string
+		wantExpired  bool
+		wantInactive bool
+	}{
+		{name: "no aging", entry: "alice:$6$hash:19000::::::"},
+		{name: "no maximum age", entry: "alice:$6$hash:19000:0::7:30::"},
+		{name: "valid", entry: "alice:$6$hash:19000:0:180:7:30::"},
+		{name: "expired", entry: "alice:$6$hash:19000:0:90:7:::", wantExpired: true},
+		{name: "expired within inactivity period", entry: "alice:$6$hash:19000:0:90:7:30::", wantExpired: true},
+		{name: "inactive", entry: "alice:$6$hash:19000:0:60:7:30::", wantExpired: true, wantInactive: true},
+		{name: "change forced", entry: "alice:$6$hash:0:0:99999:7:::", wantExpired: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expired, inactive := passwordExpiry(strings.Split(tt.entry, ":"), today)
+			assert.Equal(t, tt.wantExpired, expired)
+			assert.Equal(t, tt.wantInactive, inactive)
+		})
+	}
+}
+
+func TestManager_RestoreLoginShell(t *testing.T) {
+	m, e := newTestManager(context.Background(), map[string]string{
+		shellsFile:  "/bin/sh\n/bin/bash\n",
+		"/bin/bash": "",
+	})
+
+	shell, err := m.RestoreLoginShell("alice")
+	require.NoError(t, err)
+	assert.Equal(t, "/bin/bash", shell)
+	assert.Equal(t, []string{"usermod --shell /bin/bash alice"}, e.commands)
+
+	// bash is not installed
+	m, _ = newTestManager(context.Background(), map[string]string{shellsFile: "/bin/sh\n/bin/bash\n"})
+	assert.Equal(t, defaultShell, m.loginShell())
+}
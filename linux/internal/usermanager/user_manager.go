@@ -1,7 +1,6 @@
 package usermanager
 
 import (
-	"bufio"
 	"context"
 	"crypto/rand"
 	"errors"
@@ -9,15 +8,12 @@ import (
 	"github.com/GehirnInc/crypt/sha512_crypt"
 	"github.com/spf13/afero"
 	"go.uber.org/zap"
-	"io/fs"
+	"marketplace-yaga/linux/internal/audit"
 	"marketplace-yaga/linux/internal/executor"
-	"marketplace-yaga/linux/internal/executor/argument"
-	"marketplace-yaga/linux/internal/executor/command"
+	"marketplace-yaga/linux/internal/plan"
 	"marketplace-yaga/pkg/logger"
 	"math/big"
-	"os"
 	"os/user"
-	"path"
 	"regexp"
 	"strconv"
 	"strings"
@@ -33,6 +29,7 @@ type Manager struct {
 	ctx      context.Context
 	fs       afero.Fs
 	executor executor.ExecutorService
+	backend  accountBackend
 }
 
 var ErrRestrictedUser = errors.New(`modifications to restricted users not allowed (BUILTIN\Administrator on windows or system users on linux)`)
@@ -47,11 +44,21 @@ func newManager(ctx context.Context) *Manager {
 	// propagate context so if server signaled to stop, commands in flight also canceled
 	osexec := executor.NewBuilder(ctx).WithTimeout(commandsTimeout).Build()
 
-	return &Manager{
+	m := &Manager{
 		ctx:      ctx,
 		fs:       afero.NewOsFs(),
 		executor: osexec,
 	}
+
+	// minimal images may lack shadow-utils, then accounts database is edited directly
+	if hasAccountTools() {
+		m.backend = &toolsBackend{executor: osexec}
+	} else {
+		logger.DebugCtx(ctx, nil, "account tools not found, use native accounts backend")
+		m.backend = newFilesBackend(m.fs)
+	}
+
+	return m
 }
 
 func (m *Manager) GetLocalNonSystemUsers() ([]string, error) {
@@ -74,148 +81,67 @@ func (m *Manager) ValidateUser(username string) error {
 	logger.DebugCtx(m.ctx, nil, "validate username",
 		zap.String("username", username))
 
+	p := accountPolicyFromContext(m.ctx)
+
 	if err := validateUsernamePattern(username); err != nil {
 		return err
 	}
 
-	return validateIsNotSystemUser(username)
-}
-
-func (m *Manager) ValidateUsername(username string) error {
-	return validateUsernamePattern(username)
-}
-
-func (m *Manager) CreateUser(username string) error {
-	logger.DebugCtx(m.ctx, nil, "create user",
-		zap.String("username", username))
-
-	cmd, err := command.New(
-		argument.New("useradd"),
-		argument.New("--create-home"),
-		argument.New(username))
-	if err != nil {
-		return fmt.Errorf("failed to create command: %w", err)
-	}
-
-	return m.executor.Run(cmd)
-}
-
-func (m *Manager) AddSshKey(u *user.User, sshKey string) (err error) {
-	if err != nil {
-		return err
-	}
-	userSshDir, err := m.ensureSshFolder(u.HomeDir, err)
-	if err != nil {
+	if err := p.checkUsername(username); err != nil {
 		return err
 	}
 
-	authorizedKeysFile, err := m.ensureAuthorizedKeysFile(u.HomeDir, userSshDir)
-	if err != nil {
+	if err := validateIsNotSystemUser(username, p.UIDMin); err != nil {
 		return err
 	}
 
-	err = m.appendKey(u.HomeDir, sshKey, err, authorizedKeysFile)
-	if err != nil {
-		return err
+	// groups of existing user are checked only if policy restricts them
+	if len(p.AllowGroups) == 0 && len(p.DenyGroups) == 0 {
+		return nil
 	}
-
-	err = m.chown(userSshDir, u)
-	if err != nil {
+	groups, err := m.userGroups(username)
+	if err != nil || groups == nil {
 		return err
 	}
 
-	err = m.chown(authorizedKeysFile, u)
-	if err != nil {
-		return err
-	}
-	return nil
+	return p.checkGroups(username, groups)
 }
 
-func (m *Manager) ensureAuthorizedKeysFile(username string, userSshDir string) (string, error) {
-	authorizedKeysFile := path.Join(userSshDir, "authorized_keys")
-
-	_, err := m.fs.Stat(authorizedKeysFile)
-	if errors.Is(err, fs.ErrNotExist) {
-		_, err := m.fs.Create(authorizedKeysFile)
-		if err != nil {
-			logger.ErrorCtx(m.ctx, err, "create user authorized_keys file",
-				zap.String("username", username))
-			return "", err
-		}
-		logger.DebugCtx(m.ctx, nil, "created user authorized_keys file",
-			zap.String("username", username))
-	}
-	return authorizedKeysFile, nil
+func (m *Manager) ValidateUsername(username string) error {
+	return validateUsernamePattern(username)
 }
 
-func (m *Manager) ensureSshFolder(homedir string, err error) (string, error) {
-	userSshDir := path.Join(homedir, ".ssh")
-	info, err := m.fs.Stat(userSshDir)
-	if errors.Is(err, fs.ErrNotExist) {
-		logger.ErrorCtx(m.ctx, err, "no user .ssh dir",
-			zap.String("homedir", homedir))
-		err := m.fs.Mkdir(userSshDir, 0700)
-		if err != nil {
-			logger.ErrorCtx(m.ctx, err, "created user .ssh dir",
-				zap.String("homedir", homedir))
-			return "", err
-		}
-	} else {
-		if !info.IsDir() {
-			return "", fmt.Errorf("%s .ssh dir is file", homedir)
-		}
-	}
-	return userSshDir, nil
-}
+// CreateUser creates user with shell, home and skeleton directory from account policy,
+// and adds it to policy extra groups.
+func (m *Manager) CreateUser(username string) error {
+	logger.DebugCtx(m.ctx, nil, "create user",
+		zap.String("username", username))
 
-func (m *Manager) appendKey(homedir string, sshKey string, err error, authorizedKeysFile string) error {
-	readFile, err := m.fs.Open(authorizedKeysFile)
-	if err != nil {
-		return err
-	}
-	fileScanner := bufio.NewScanner(readFile)
-
-	fileScanner.Split(bufio.ScanLines)
-	found := false
-	var lines []string
-	for fileScanner.Scan() {
-		line := fileScanner.Text()
-		if line == sshKey {
-			found = true
+	p := accountPolicyFromContext(m.ctx)
+	for _, g := range p.ExtraGroups {
+		if err := p.checkGroup(g); err != nil {
+			return err
 		}
-		lines = append(lines, line)
 	}
 
-	err = readFile.Close()
-	if err != nil {
-		logger.ErrorCtx(m.ctx, err, "add key for user",
-			zap.String("homedir", homedir),
-			zap.String("sshKey", sshKey),
-		)
-		return err
-	}
-	if !found {
-		lines = append(lines, sshKey)
-		file, err := m.fs.OpenFile(authorizedKeysFile, os.O_RDWR|os.O_CREATE, 0600)
+	a := plan.Action{Kind: plan.CreateUser, Target: username}
+	if !plan.Record(m.ctx, a) {
+		err := m.backend.CreateUser(username, p.createOptions())
+		audit.Record(m.ctx, a, err)
 		if err != nil {
 			return err
 		}
+	}
 
-		_, err = file.Write([]byte(strings.Join(lines, "\n") + "\n"))
-		if err != nil {
-			logger.ErrorCtx(m.ctx, err, "written authorized_keys",
-				zap.String("homedir", homedir),
-				zap.String("sshKey", sshKey),
-			)
-			return err
-		}
-		err = file.Close()
-		if err != nil {
+	for _, g := range p.ExtraGroups {
+		if err := m.addToGroup(username, g); err != nil {
 			return err
 		}
 	}
-	return err
+
+	return nil
 }
+
 func (m *Manager) Exist(username string) (bool, error) {
 	_, err := user.Lookup(username)
 	if err != nil {
@@ -225,6 +151,19 @@ func (m *Manager) Exist(username string) (bool, error) {
 	return true, nil
 }
 
+// defaultHomeBase is directory, in which useradd creates home directories by default.
+const defaultHomeBase = "/home"
+
+// Lookup finds local user. In plan mode user, which creation was planned, is returned as well.
+func (m *Manager) Lookup(username string) (*user.User, error) {
+	u, err := user.Lookup(username)
+	if err != nil && plan.Planned(m.ctx, plan.CreateUser, username) {
+		return &user.User{Username: username, HomeDir: accountPolicyFromContext(m.ctx).homeDir(username)}, nil
+	}
+
+	return u, err
+}
+
 func (m *Manager) chown(file string, u *user.User) error {
 	uid, _ := strconv.Atoi(u.Uid)
 	gid, _ := strconv.Atoi(u.Gid)
@@ -232,7 +171,8 @@ func (m *Manager) chown(file string, u *user.User) error {
 	return m.fs.Chown(file, uid, gid)
 }
 
-func validateIsNotSystemUser(username string) error {
+// validateIsNotSystemUser checks that existing user is not a system one, i.e. its UID is not below uidMin.
+func validateIsNotSystemUser(username string, uidMin int) error {
 	u, err := user.Lookup(username)
 	if err != nil {
 		return nil
@@ -243,7 +183,7 @@ func validateIsNotSystemUser(username string) error {
 		return fmt.Errorf("failed to convert uid (%v) to int: %w", u.Uid, err)
 	}
 
-	if id < 1000 {
+	if id < uint64(uidMin) {
 		return ErrRestrictedUser
 	}
 
@@ -283,36 +223,15 @@ func (m *Manager) SetPassword(username, password string) error {
 	logger.InfoCtx(m.ctx, nil, "set password",
 		zap.String("hash", hashedPassword))
 
-	cmd, err := command.New(
-		argument.New("usermod"),
-		argument.New("--password"),
-		argument.New(hashedPassword, argument.Sensitive(), argument.NoEscape()),
-		argument.New(username))
-
-	if err != nil {
-		return fmt.Errorf("failed construct command: %w", err)
+	a := plan.Action{Kind: plan.SetPassword, Target: username}
+	if plan.Record(m.ctx, a) {
+		return nil
 	}
 
-	return m.executor.Run(cmd)
-}
-
-func (m *Manager) AddToAdministrators(username string) error {
-	logger.DebugCtx(m.ctx, nil, `add to local group sudo`,
-		zap.String("username", username))
-
-	const group = "sudo"
+	err = m.backend.SetPassword(username, hashedPassword)
+	audit.Record(m.ctx, a, err)
 
-	cmd, err := command.New(
-		argument.New("usermod"),
-		argument.New("--groups"),
-		argument.New(group),
-		argument.New(username))
-
-	if err != nil {
-		return fmt.Errorf("failed construct command: %w", err)
-	}
-
-	return m.executor.Run(cmd)
+	return err
 }
 
 func getSHA512Crypt(password string) (string, error) {
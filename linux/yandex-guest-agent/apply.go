@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"marketplace-yaga/linux/internal/guest"
+	"marketplace-yaga/pkg/logger"
+	"marketplace-yaga/pkg/serial"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	applyOnce     bool
+	applyHandlers []string
+	applySerial   bool
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Args:  cobra.NoArgs,
+	Short: "Apply current metadata with selected handlers and exit",
+	Long: "Fetch metadata once without waiting for change, run selected handlers synchronously " +
+		"and print their responses as JSON. Exits with non-zero code if any handler failed.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		l, err := logger.NewStderrLogger(logLevel)
+		if err != nil {
+			return err
+		}
+		ctx, err := newContext(l)
+		if err != nil {
+			return err
+		}
+
+		// metadata is applied once anyway, flag only makes one-shot mode explicit
+		if !applyOnce {
+			return ErrApplyOnce
+		}
+
+		// responses are printed anyway, serial port is optional here
+		if applySerial {
+			if err = serial.Init(portName); err != nil {
+				return err
+			}
+		}
+
+		results, err := guest.ApplyOnce(ctx, applyHandlers, applySerial)
+		if err != nil {
+			return err
+		}
+
+		if err = printJSON(results); err != nil {
+			return err
+		}
+
+		return checkResults(results)
+	},
+}
+
+var ErrApplyOnce = errors.New("only one-shot apply is supported, use --once")
+
+// checkResults returns error, listing failed handlers, if any.
+func checkResults(results []guest.HandlerResult) error {
+	var failed []string
+	for _, r := range results {
+		if r.Failed() {
+			failed = append(failed, r.Handler)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%w: %v", ErrHandlerFailed, strings.Join(failed, ","))
+	}
+
+	return nil
+}
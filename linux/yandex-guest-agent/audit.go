@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"marketplace-yaga/linux/internal/audit"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+var auditLog string
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspect audit log of account and secret changes",
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Args:  cobra.NoArgs,
+	Short: "Verify hash chain of audit log and that it was not truncated",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		n, err := audit.VerifyFile(afero.NewOsFs(), auditLog)
+		if err != nil {
+			return fmt.Errorf("%v entries verified before failure: %w", n, err)
+		}
+
+		fmt.Printf("%v entries verified\n", n)
+
+		return nil
+	},
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"marketplace-yaga/linux/internal/control"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Args:  cobra.NoArgs,
+	Short: "Print status of running agent",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		st, err := control.NewClient(controlSocket).Status()
+		if err != nil {
+			return err
+		}
+
+		return printJSON(st)
+	},
+}
+
+var handlersCmd = &cobra.Command{
+	Use:   "handlers",
+	Args:  cobra.NoArgs,
+	Short: "Print handlers of running agent with their last run and result",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		hs, err := control.NewClient(controlSocket).Handlers()
+		if err != nil {
+			return err
+		}
+
+		return printJSON(hs)
+	},
+}
+
+var ErrHandlerFailed = errors.New("handler failed")
+
+var triggerCmd = &cobra.Command{
+	Use:   "trigger <handler>",
+	Args:  cobra.ExactArgs(1),
+	Short: "Re-run handler of running agent with last received metadata",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tr, err := control.NewClient(controlSocket).Trigger(args[0])
+		if err != nil {
+			return err
+		}
+
+		if err = printJSON(tr); err != nil {
+			return err
+		}
+
+		if !tr.Result.Skipped && !tr.Result.Success {
+			return fmt.Errorf("%w: %v", ErrHandlerFailed, tr.Result.Error)
+		}
+
+		return nil
+	},
+}
+
+func printJSON(v interface{}) error {
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "  ")
+
+	return e.Encode(v)
+}
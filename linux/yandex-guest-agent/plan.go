@@ -0,0 +1,40 @@
+package main
+
+import (
+	"marketplace-yaga/linux/internal/guest"
+	"marketplace-yaga/pkg/logger"
+
+	"github.com/spf13/cobra"
+)
+
+var planHandlers []string
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Args:  cobra.NoArgs,
+	Short: "Print changes selected handlers would make for current metadata",
+	Long: "Fetch metadata once and run selected handlers in dry-run mode: users to be created, " +
+		"authorized_keys lines to be added, files to be written and commands to be executed are printed as JSON, " +
+		"but not performed. Nothing is written to serial port.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		l, err := logger.NewStderrLogger(logLevel)
+		if err != nil {
+			return err
+		}
+		ctx, err := newContext(l)
+		if err != nil {
+			return err
+		}
+
+		results, err := guest.PlanOnce(ctx, planHandlers)
+		if err != nil {
+			return err
+		}
+
+		if err = printJSON(results); err != nil {
+			return err
+		}
+
+		return checkResults(results)
+	},
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"marketplace-yaga/linux/internal/handlers/sshkeys"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sshKeysMode             string
+	sshKeyPolicy            string
+	sshKeysVanished         string
+	hostKeysGuestAttributes bool
+)
+
+// sshKeysModes returns comma separated list of known ssh keys modes.
+func sshKeysModes() string {
+	var ms []string
+	for _, m := range sshkeys.Modes {
+		ms = append(ms, string(m))
+	}
+
+	return strings.Join(ms, ",")
+}
+
+// vanishedPolicies returns comma separated list of known vanished users policies.
+func vanishedPolicies() string {
+	var ps []string
+	for _, p := range sshkeys.VanishedPolicies {
+		ps = append(ps, string(p))
+	}
+
+	return strings.Join(ps, ",")
+}
+
+var authorizedKeysCmd = &cobra.Command{
+	Use:   "authorized-keys <user>",
+	Args:  cobra.ExactArgs(1),
+	Short: "Print valid ssh-keys metadata keys of user, to be used as sshd AuthorizedKeysCommand",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keys, err := sshkeys.AuthorizedKeys(afero.NewOsFs(), sshkeys.DefaultKeysCacheFile, args[0], time.Now())
+		if err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			fmt.Println(k)
+		}
+
+		return nil
+	},
+}
@@ -4,12 +4,19 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"marketplace-yaga/linux/internal/audit"
+	"marketplace-yaga/linux/internal/control"
 	"marketplace-yaga/linux/internal/guest"
+	"marketplace-yaga/linux/internal/handlers/sshkeys"
+	"marketplace-yaga/linux/internal/usermanager"
 	"marketplace-yaga/pkg/logger"
 	"marketplace-yaga/pkg/serial"
+	"strings"
 
 	"github.com/blang/semver/v4"
+	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
 )
 
 const portName = "/dev/ttyS3"
@@ -19,7 +26,10 @@ func initAgent() (*guest.Server, error) {
 	if err != nil {
 		return nil, err
 	}
-	ctx := logger.NewContext(context.Background(), l)
+	ctx, err := newContext(l)
+	if err != nil {
+		return nil, err
+	}
 
 	// it will try to lock COM4-port for exclusive use
 	if err = serial.Init(portName); err != nil {
@@ -31,7 +41,46 @@ func initAgent() (*guest.Server, error) {
 		return nil, err
 	}
 
-	return s, nil
+	return s.WithVersion(version).WithControlSocket(controlSocket).WithHostKeysGuestAttributes(hostKeysGuestAttributes), nil
+}
+
+// newContext creates agent context carrying logger and settings from flags.
+func newContext(l *zap.Logger) (context.Context, error) {
+	p, err := usermanager.ParseAdminPolicy(adminPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	ap, err := usermanager.LoadAccountPolicy(afero.NewOsFs(), accountPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := usermanager.NewAdminPolicyContext(logger.NewContext(context.Background(), l), p)
+	ctx = usermanager.NewAccountPolicyContext(ctx, ap)
+
+	m, err := sshkeys.ParseMode(sshKeysMode)
+	if err != nil {
+		return nil, err
+	}
+	ctx = sshkeys.NewModeContext(ctx, m)
+
+	vp, err := sshkeys.ParseVanishedPolicy(sshKeysVanished)
+	if err != nil {
+		return nil, err
+	}
+	ctx = sshkeys.NewVanishedPolicyContext(ctx, vp)
+
+	kp, err := sshkeys.LoadKeyPolicy(afero.NewOsFs(), sshKeyPolicy)
+	if err != nil {
+		return nil, err
+	}
+	ctx = sshkeys.NewKeyPolicyContext(ctx, kp)
+	if auditLog != "" {
+		ctx = audit.NewContext(ctx, audit.NewLog(afero.NewOsFs(), auditLog))
+	}
+
+	return ctx, nil
 }
 
 var startCmd = &cobra.Command{
@@ -67,17 +116,61 @@ var versionCmd = &cobra.Command{
 var (
 	logLevel          string
 	disableSerialSink bool
+	controlSocket     string
+	adminPolicy       string
+	accountPolicy     string
 	s                 *guest.Server
 	version           = "devel"
 	rootCmd           = &cobra.Command{Use: "yandex-guest-agent"}
 )
 
+// adminPolicies returns comma separated list of known admin policies.
+func adminPolicies() string {
+	var ps []string
+	for _, p := range usermanager.AdminPolicies {
+		ps = append(ps, string(p))
+	}
+
+	return strings.Join(ps, ",")
+}
+
 func main() {
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "")
 	rootCmd.PersistentFlags().BoolVar(&disableSerialSink, "log-disable-serial", true, "")
+	rootCmd.PersistentFlags().StringVar(&controlSocket, "control-socket", control.DefaultSocketPath, "")
+	rootCmd.PersistentFlags().BoolVar(&hostKeysGuestAttributes, "host-keys-guest-attributes", false,
+		"also publish host keys fingerprints to guest attributes")
+	rootCmd.PersistentFlags().StringVar(&auditLog, "audit-log", audit.DefaultPath, "audit log of changes, empty disables it")
+	rootCmd.PersistentFlags().StringVar(&adminPolicy, "admin-policy", string(usermanager.AdminGroup),
+		"how created users are granted administrator privileges, any of: "+adminPolicies())
+	rootCmd.PersistentFlags().StringVar(&accountPolicy, "account-policy", usermanager.DefaultAccountPolicyFile,
+		"JSON file restricting managed users and defining how users are created")
+	rootCmd.PersistentFlags().StringVar(&sshKeysMode, "ssh-keys-mode", string(sshkeys.ModeFiles),
+		"how ssh-keys metadata keys are passed to sshd, any of: "+sshKeysModes())
+	rootCmd.PersistentFlags().StringVar(&sshKeysVanished, "ssh-keys-vanished", string(sshkeys.VanishedKeep),
+		"what is done with accounts agent created, when their users disappear from ssh-keys metadata, any of: "+vanishedPolicies())
+	rootCmd.PersistentFlags().StringVar(&sshKeyPolicy, "ssh-key-policy", sshkeys.DefaultKeyPolicyFile,
+		"JSON file with accepted ssh key algorithms, minimal RSA key size and allowed options")
+
+	applyCmd.Flags().BoolVar(&applyOnce, "once", false, "apply current metadata once and exit")
+	applyCmd.Flags().StringSliceVar(&applyHandlers, "handlers", nil,
+		"handlers to run, all by default, any of: "+strings.Join(guest.HandlerNames(), ","))
+	applyCmd.Flags().BoolVar(&applySerial, "serial", false, "also write handlers responses to serial port, required by users handler")
+	_ = applyCmd.MarkFlagRequired("once")
+
+	planCmd.Flags().StringSliceVar(&planHandlers, "handlers", nil,
+		"handlers to run, all by default, any of: "+strings.Join(guest.HandlerNames(), ","))
 
 	rootCmd.AddCommand(startCmd)
 	rootCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(handlersCmd)
+	rootCmd.AddCommand(triggerCmd)
+	rootCmd.AddCommand(applyCmd)
+	rootCmd.AddCommand(planCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+	rootCmd.AddCommand(auditCmd)
+	rootCmd.AddCommand(authorizedKeysCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatal("agent execution failed: ", err)
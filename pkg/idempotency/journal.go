@@ -0,0 +1,259 @@
+// Package idempotency provides journal of processed requests, protecting handlers from replays.
+package idempotency
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+var (
+	// ErrReplay is returned if request hash is already in journal and not expired yet.
+	ErrReplay = errors.New("request already processed")
+	// ErrFull is returned if journal holds capacity of entries, which have not expired yet.
+	// Entries are never dropped before expiry, otherwise their requests could be replayed.
+	ErrFull = errors.New("journal is full")
+	// ErrCorrupted is returned if journal could not be parsed. Requests are rejected till it is repaired,
+	// e.g. removed once requests it could hold have expired, otherwise they could be replayed.
+	ErrCorrupted = errors.New("journal is corrupted")
+)
+
+// DefaultCapacity is maximum number of entries kept in journal by default.
+const DefaultCapacity = 256
+
+// legacyTTL is time legacy single hash entry is kept after migration.
+const legacyTTL = 10 * time.Minute
+
+// Entry is single processed request.
+type Entry struct {
+	Hash    string `json:"hash"`
+	Expires int64  `json:"expires"`
+}
+
+type journalFile struct {
+	Entries []Entry `json:"entries"`
+}
+
+// Journal is bounded set of processed request hashes with expiry, stored in file.
+// File is replaced atomically, so crash leaves either previous or new version of journal.
+// Processes sharing journal, e.g. daemon and one-shot apply, are serialized with lock file next to journal.
+type Journal struct {
+	fs       afero.Fs
+	path     string
+	capacity int
+	now      func() time.Time
+}
+
+// locks serializes access to journal files within process, journals for same file could be created independently.
+// Lock of file is held by process, so it does not serialize goroutines.
+var locks sync.Map
+
+// NewJournal return instance of Journal stored in file path.
+func NewJournal(fs afero.Fs, path string) *Journal {
+	return &Journal{
+		fs:       fs,
+		path:     path,
+		capacity: DefaultCapacity,
+		now:      time.Now,
+	}
+}
+
+// WithCapacity sets maximum number of entries, which have not expired yet.
+func (j *Journal) WithCapacity(n int) *Journal {
+	j.capacity = n
+
+	return j
+}
+
+// lock serializes access to journal within process and with other processes. Lock file is not replaced with
+// journal, so lock of it stays valid.
+func (j *Journal) lock() (func(), error) {
+	l, _ := locks.LoadOrStore(j.path, &sync.Mutex{})
+	m := l.(*sync.Mutex)
+	m.Lock()
+
+	f, err := j.fs.OpenFile(j.path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	// there is no journal without its directory, and it could not be written either
+	if errors.Is(err, fs.ErrNotExist) {
+		return m.Unlock, nil
+	}
+	if err != nil {
+		m.Unlock()
+		return nil, err
+	}
+	if err = lockFile(f); err != nil {
+		_ = f.Close()
+		m.Unlock()
+		return nil, err
+	}
+
+	return func() {
+		// closing file releases its lock
+		_ = f.Close()
+		m.Unlock()
+	}, nil
+}
+
+// Check returns ErrReplay if hash is recorded in journal and not expired.
+func (j *Journal) Check(hash string) error {
+	unlock, err := j.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := j.load()
+	if err != nil {
+		return err
+	}
+
+	return j.check(entries, hash)
+}
+
+// Record stores hash in journal until given time. Expired entries are pruned on every record.
+func (j *Journal) Record(hash string, until time.Time) error {
+	unlock, err := j.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := j.load()
+	if err != nil {
+		return err
+	}
+
+	return j.record(entries, hash, until)
+}
+
+// CheckAndRecord returns ErrReplay if hash is recorded in journal and not expired, and records it otherwise.
+// Both are done under single lock, so request is accepted once, even by different processes.
+func (j *Journal) CheckAndRecord(hash string, until time.Time) error {
+	unlock, err := j.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := j.load()
+	if err != nil {
+		return err
+	}
+	if err = j.check(entries, hash); err != nil {
+		return err
+	}
+
+	return j.record(entries, hash, until)
+}
+
+func (j *Journal) check(entries []Entry, hash string) error {
+	now := j.now().Unix()
+	for _, e := range entries {
+		if e.Hash == hash && e.Expires > now {
+			return ErrReplay
+		}
+	}
+
+	return nil
+}
+
+func (j *Journal) record(entries []Entry, hash string, until time.Time) error {
+	now := j.now().Unix()
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.Expires > now && e.Hash != hash {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) >= j.capacity {
+		return fmt.Errorf("%w: %d entries have not expired yet", ErrFull, len(kept))
+	}
+	kept = append(kept, Entry{Hash: hash, Expires: until.Unix()})
+
+	return j.store(kept)
+}
+
+// load reads journal entries. File with single raw hash, written by previous agent versions, is migrated.
+func (j *Journal) load() ([]Entry, error) {
+	content, err := afero.ReadFile(j.fs, j.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	content = bytes.TrimSpace(content)
+	if len(content) == 0 {
+		return nil, nil
+	}
+
+	if content[0] != '{' {
+		return []Entry{{Hash: string(content), Expires: j.now().Add(legacyTTL).Unix()}}, nil
+	}
+
+	// journal is replaced atomically, so that could be only damage from outside; treating it as empty
+	// would allow replays, so it is left for inspection and requests are rejected
+	var f journalFile
+	if err = json.Unmarshal(content, &f); err != nil {
+		return nil, fmt.Errorf("%w: %v: %v", ErrCorrupted, j.path, err)
+	}
+
+	return f.Entries, nil
+}
+
+// store writes entries to temporary file and renames it over journal.
+func (j *Journal) store(entries []Entry) (err error) {
+	content, err := json.Marshal(journalFile{Entries: entries})
+	if err != nil {
+		return err
+	}
+
+	tmp := j.path + ".tmp"
+	f, err := j.fs.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = j.fs.Remove(tmp)
+		}
+	}()
+
+	if _, err = f.Write(content); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	if err = j.fs.Rename(tmp, j.path); err != nil {
+		return err
+	}
+
+	return j.syncDir()
+}
+
+// syncDir makes rename of journal durable.
+func (j *Journal) syncDir() error {
+	d, err := j.fs.Open(path.Dir(j.path))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = d.Close() }()
+
+	return d.Sync()
+}
@@ -0,0 +1,123 @@
+package idempotency
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPath = "/opt/yandex-guest-agent/idempotency"
+
+func TestJournal_Replay(t *testing.T) {
+	j := NewJournal(afero.NewMemMapFs(), testPath)
+	until := time.Now().Add(time.Minute)
+
+	require.NoError(t, j.Check("a"))
+	require.NoError(t, j.Record("a", until))
+	require.NoError(t, j.Record("b", until))
+
+	// alternating requests must not replay older one
+	assert.ErrorIs(t, j.Check("a"), ErrReplay)
+	assert.ErrorIs(t, j.Check("b"), ErrReplay)
+	assert.NoError(t, j.Check("c"))
+}
+
+func TestJournal_Expiry(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	now := time.Now()
+	j := NewJournal(fs, testPath)
+	j.now = func() time.Time { return now }
+
+	require.NoError(t, j.Record("a", now.Add(time.Minute)))
+	assert.ErrorIs(t, j.Check("a"), ErrReplay)
+
+	now = now.Add(2 * time.Minute)
+	assert.NoError(t, j.Check("a"))
+
+	// expired entries are pruned on record
+	require.NoError(t, j.Record("b", now.Add(time.Minute)))
+	entries, err := j.load()
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{{Hash: "b", Expires: now.Add(time.Minute).Unix()}}, entries)
+}
+
+func TestJournal_Capacity(t *testing.T) {
+	now := time.Now()
+	j := NewJournal(afero.NewMemMapFs(), testPath).WithCapacity(3)
+	j.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, j.Record(fmt.Sprint(i), now.Add(time.Duration(i+1)*time.Minute)))
+	}
+
+	// live entries are never dropped, so none of them could be replayed
+	assert.ErrorIs(t, j.Record("3", now.Add(time.Hour)), ErrFull)
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, j.Check(fmt.Sprint(i)), ErrReplay)
+	}
+
+	now = now.Add(90 * time.Second)
+	require.NoError(t, j.Record("3", now.Add(time.Hour)))
+	entries, err := j.load()
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+}
+
+func TestJournal_CheckAndRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "idempotency")
+	until := time.Now().Add(time.Minute)
+
+	// journals of different processes are independent instances sharing file
+	var wg sync.WaitGroup
+	var accepted int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if NewJournal(afero.NewOsFs(), path).CheckAndRecord("a", until) == nil {
+				atomic.AddInt32(&accepted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), accepted)
+	assert.ErrorIs(t, NewJournal(afero.NewOsFs(), path).CheckAndRecord("a", until), ErrReplay)
+}
+
+func TestJournal_Legacy(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, testPath, []byte("123"), 0600))
+	j := NewJournal(fs, testPath)
+
+	assert.ErrorIs(t, j.Check("123"), ErrReplay)
+	assert.NoError(t, j.Check("456"))
+
+	require.NoError(t, j.Record("456", time.Now().Add(time.Minute)))
+	assert.ErrorIs(t, j.Check("123"), ErrReplay)
+	assert.ErrorIs(t, j.Check("456"), ErrReplay)
+
+	_, err := fs.Stat(testPath + ".tmp")
+	assert.Error(t, err, "temporary file left")
+}
+
+func TestJournal_Corrupted(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, testPath, []byte("{broken"), 0600))
+	j := NewJournal(fs, testPath)
+
+	// requests are rejected, as journal could have held any of them
+	assert.ErrorIs(t, j.Check("a"), ErrCorrupted)
+	assert.ErrorIs(t, j.CheckAndRecord("a", time.Now().Add(time.Minute)), ErrCorrupted)
+
+	content, err := afero.ReadFile(fs, testPath)
+	require.NoError(t, err)
+	assert.Equal(t, "{broken", string(content))
+}
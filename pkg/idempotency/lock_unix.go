@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package idempotency
+
+import (
+	"errors"
+
+	"github.com/spf13/afero"
+	"golang.org/x/sys/unix"
+)
+
+// lockFile takes exclusive lock on file, which is held till file is closed.
+// Files, which are not backed by OS, e.g. in tests, are guarded by journal mutex only.
+func lockFile(f afero.File) error {
+	osFile, ok := f.(interface{ Fd() uintptr })
+	if !ok {
+		return nil
+	}
+
+	for {
+		err := unix.Flock(int(osFile.Fd()), unix.LOCK_EX)
+		if !errors.Is(err, unix.EINTR) {
+			return err
+		}
+	}
+}
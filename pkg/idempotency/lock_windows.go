@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package idempotency
+
+import (
+	"github.com/spf13/afero"
+)
+
+// lockFile does nothing, journal is used by single process on Windows, which is guarded by journal mutex.
+func lockFile(afero.File) error {
+	return nil
+}
@@ -48,6 +48,15 @@ var defaultEncoderConfig = zapcore.EncoderConfig{
 // Serial log formatted as JSON's for easy-parsing.
 // Console log utilize text encoder.
 func NewLogger(lvl string, withSerial bool) (*zap.Logger, error) {
+	return newLogger(lvl, withSerial, os.Stdout)
+}
+
+// NewStderrLogger create console logger, writing to stderr, so stdout is left for command output.
+func NewStderrLogger(lvl string) (*zap.Logger, error) {
+	return newLogger(lvl, false, os.Stderr)
+}
+
+func newLogger(lvl string, withSerial bool, console zapcore.WriteSyncer) (*zap.Logger, error) {
 	var level zapcore.Level
 	if err := level.UnmarshalText([]byte(lvl)); err != nil {
 		return nil, err
@@ -55,7 +64,7 @@ func NewLogger(lvl string, withSerial bool) (*zap.Logger, error) {
 
 	cores := zapcore.NewCore(
 		zapcore.NewConsoleEncoder(defaultEncoderConfig),
-		zapcore.Lock(os.Stdout),
+		zapcore.Lock(console),
 		level)
 
 	if withSerial {
@@ -2,6 +2,7 @@ package meta
 
 import (
 	"context"
+	"errors"
 	"marketplace-yaga/pkg/logger"
 	"sync"
 	"time"
@@ -22,15 +23,52 @@ type MetadataWatcher struct {
 	ctx          context.Context
 	m            sync.Mutex
 	timeToHandle time.Duration
+
+	// handleBase is parent of handlers contexts, it outlives ctx, so handlers in flight could finish on stop
+	handleBase   context.Context
+	handleCancel context.CancelFunc
+
+	sm      sync.RWMutex
+	watches []*watchState
+	// stopping is set by Drain under sm, no handler starts after that; inFlight counts handlers started before.
+	stopping bool
+	inFlight sync.WaitGroup
+}
+
+// watchState holds last known state of single watch, so it could be queried or re-run.
+type watchState struct {
+	handler MetadataChangeHandler
+	status  HandlerStatus
+	data    []byte
+	hasData bool
+	running bool
+}
+
+// valuesContext is canceled as embedded context, but holds values (e.g. logger) of other one.
+type valuesContext struct {
+	context.Context
+	values context.Context
 }
 
+func (c valuesContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
+}
+
+var (
+	ErrUnknownHandler = errors.New("unknown handler")
+	ErrNoData         = errors.New("no metadata received yet")
+)
+
 const handleTimeout = time.Minute
 
 func NewMetadataWatcher(ctx context.Context) *MetadataWatcher {
-	return &MetadataWatcher{
+	w := &MetadataWatcher{
 		ctx:          ctx,
 		timeToHandle: handleTimeout,
 	}
+	w.handleBase, w.handleCancel = context.WithCancel(context.Background())
+
+	return w
 }
 
 func (w *MetadataWatcher) AddWatch(url string, handler MetadataChangeHandler) {
@@ -38,6 +76,7 @@ func (w *MetadataWatcher) AddWatch(url string, handler MetadataChangeHandler) {
 
 	logger.InfoCtx(ctx, nil, "start metadata watch")
 	poller := NewPoller(url)
+	w.state(handler).status.URL = url
 
 	go w.watch(ctx, poller, handler)
 }
@@ -47,7 +86,91 @@ func (w *MetadataWatcher) Wait() {
 	<-w.ctx.Done()
 }
 
+// Status returns snapshot of all watches states in order they were added.
+func (w *MetadataWatcher) Status() []HandlerStatus {
+	w.sm.RLock()
+	defer w.sm.RUnlock()
+
+	statuses := make([]HandlerStatus, 0, len(w.watches))
+	for _, s := range w.watches {
+		st := s.status
+		if st.LastResult != nil {
+			r := *st.LastResult
+			st.LastResult = &r
+		}
+		statuses = append(statuses, st)
+	}
+
+	return statuses
+}
+
+// Stalled returns names of handlers, which watch loops made no progress for longer than threshold.
+func (w *MetadataWatcher) Stalled(threshold time.Duration) []string {
+	w.sm.RLock()
+	defer w.sm.RUnlock()
+
+	var stalled []string
+	for _, s := range w.watches {
+		if time.Since(s.status.LastProgress) > threshold {
+			stalled = append(stalled, s.status.Name)
+		}
+	}
+
+	return stalled
+}
+
+// Trigger re-runs handler with last received metadata, bypassing handler checks of already processed data.
+func (w *MetadataWatcher) Trigger(name string) (Result, error) {
+	w.sm.RLock()
+	var s *watchState
+	for _, ws := range w.watches {
+		if ws.handler.String() == name {
+			s = ws
+			break
+		}
+	}
+	var data []byte
+	var hasData bool
+	if s != nil {
+		data, hasData = s.data, s.hasData
+	}
+	w.sm.RUnlock()
+
+	if s == nil {
+		return Result{}, ErrUnknownHandler
+	}
+	if !hasData {
+		return Result{}, ErrNoData
+	}
+
+	ctx := logger.NewContext(w.ctx, logger.FromContext(w.ctx).With(zap.Stringer("event", s.handler)))
+	logger.InfoCtx(ctx, nil, "trigger handler")
+
+	return w.handle(WithForce(ctx), s.handler, data), nil
+}
+
+// state returns state of watch for given handler, creates one if not found.
+func (w *MetadataWatcher) state(h MetadataChangeHandler) *watchState {
+	w.sm.Lock()
+	defer w.sm.Unlock()
+
+	for _, s := range w.watches {
+		if s.handler == h {
+			return s
+		}
+	}
+
+	s := &watchState{handler: h}
+	s.status.Name = h.String()
+	s.status.LastProgress = time.Now()
+	w.watches = append(w.watches, s)
+
+	return s
+}
+
 func (w *MetadataWatcher) watch(ctx context.Context, p pollerGet, h MetadataChangeHandler) {
+	s := w.state(h)
+
 	for {
 		err := ctx.Err()
 		if err != nil {
@@ -57,19 +180,156 @@ func (w *MetadataWatcher) watch(ctx context.Context, p pollerGet, h MetadataChan
 
 		var data []byte
 		data, err = p.Get(ctx)
+		w.recordPoll(s, data, err)
 		if err != nil {
 			logger.ErrorCtx(ctx, err, "got new metadata", zap.ByteString("content", data))
 			continue
 		}
 
-		w.syncCall(func() {
-			handleCtx, handleCtxCancel := context.WithTimeout(ctx, w.timeToHandle)
-			h.Handle(handleCtx, data)
-			handleCtxCancel()
-		})
+		w.handle(ctx, h, data)
 	}
 }
 
+// handle calls handler exclusively with other handlers and records its result.
+// Handler is not canceled when watcher stops, but new ones are not started then.
+func (w *MetadataWatcher) handle(ctx context.Context, h MetadataChangeHandler, data []byte) (r Result) {
+	s := w.state(h)
+	r.Skipped = true
+
+	w.syncCall(func() {
+		if !w.start(s) {
+			logger.InfoCtx(ctx, w.ctx.Err(), "watcher stopped, skip handler")
+			return
+		}
+
+		handleCtx, handleCtxCancel := context.WithTimeout(valuesContext{w.handleBase, ctx}, w.timeToHandle)
+		handleCtx, getResult := NewResultContext(handleCtx)
+
+		started := time.Now()
+		h.Handle(handleCtx, data)
+		handleCtxCancel()
+		w.finish(s)
+
+		r = getResult()
+		w.recordRun(s, started, r)
+	})
+
+	return
+}
+
+// start marks handler running, unless watcher is stopped. Check and mark are done under same lock as Drain sets
+// stopping, so Drain either waits for handler or handler is not started.
+func (w *MetadataWatcher) start(s *watchState) bool {
+	w.sm.Lock()
+	defer w.sm.Unlock()
+
+	if w.stopping || w.ctx.Err() != nil {
+		return false
+	}
+	s.running = true
+	w.inFlight.Add(1)
+
+	return true
+}
+
+func (w *MetadataWatcher) finish(s *watchState) {
+	w.sm.Lock()
+	s.running = false
+	w.sm.Unlock()
+
+	w.inFlight.Done()
+}
+
+// InFlight returns names of handlers running at the moment.
+func (w *MetadataWatcher) InFlight() []string {
+	w.sm.RLock()
+	defer w.sm.RUnlock()
+
+	var names []string
+	for _, s := range w.watches {
+		if s.running {
+			names = append(names, s.status.Name)
+		}
+	}
+
+	return names
+}
+
+// Drain waits for handlers in flight to finish, must be called after watcher context is done.
+// On timeout handlers contexts are canceled and names of handlers, which were still running, returned.
+func (w *MetadataWatcher) Drain(timeout time.Duration) []string {
+	w.sm.Lock()
+	w.stopping = true
+	w.sm.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+	}
+
+	inFlight := w.InFlight()
+	logger.ErrorCtx(w.ctx, nil, "cancel handlers in flight", zap.Strings("handlers", inFlight))
+	w.handleCancel()
+
+	return inFlight
+}
+
+func (w *MetadataWatcher) recordPoll(s *watchState, data []byte, err error) {
+	w.sm.Lock()
+	defer w.sm.Unlock()
+
+	s.status.LastPoll = time.Now()
+	s.status.LastProgress = s.status.LastPoll
+	s.status.LastPollError = ""
+	if err != nil {
+		s.status.LastPollError = err.Error()
+		return
+	}
+
+	s.data = data
+	s.hasData = true
+}
+
+func (w *MetadataWatcher) recordRun(s *watchState, started time.Time, r Result) {
+	w.sm.Lock()
+	defer w.sm.Unlock()
+
+	s.status.Runs++
+	s.status.LastRun = started
+	s.status.LastDuration = time.Since(started).String()
+	s.status.LastProgress = time.Now()
+	// handler skipped data as already processed, so keep previous result
+	if !r.Skipped {
+		s.status.LastResult = &r
+	}
+}
+
+// HandleOnce fetches current metadata without waiting for change and passes it to handler synchronously.
+func HandleOnce(ctx context.Context, url string, h MetadataChangeHandler) (Result, error) {
+	ctx = logger.NewContext(ctx, logger.FromContext(ctx).With(zap.Stringer("event", h)))
+
+	data, err := NewOneShotPoller(url).Get(ctx)
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "got metadata")
+		return Result{}, err
+	}
+
+	handleCtx, handleCtxCancel := context.WithTimeout(ctx, handleTimeout)
+	defer handleCtxCancel()
+	handleCtx, getResult := NewResultContext(handleCtx)
+
+	h.Handle(handleCtx, data)
+
+	return getResult(), nil
+}
+
 func (w *MetadataWatcher) syncCall(f func()) {
 	w.m.Lock()
 	defer w.m.Unlock()
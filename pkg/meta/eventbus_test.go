@@ -168,3 +168,157 @@ func TestEventWatcher_watch(t *testing.T) {
 	poller.AssertNumberOfCalls(t, "Get", 2)
 	handler.AssertNumberOfCalls(t, "Handle", 1)
 }
+
+type reportingHandler struct {
+	name  string
+	calls int
+	err   error
+}
+
+func (h *reportingHandler) Handle(ctx context.Context, data []byte) {
+	h.calls++
+	// mimics real handlers, which skip data processed before
+	if h.calls > 1 && !IsForced(ctx) {
+		return
+	}
+	SetResult(ctx, string(data), h.err)
+}
+
+func (h *reportingHandler) String() string {
+	return h.name
+}
+
+func TestEventWatcher_Trigger(t *testing.T) {
+	ctx, ctxCancel := context.WithCancel(logger.NewContext(context.Background(), zaptest.NewLogger(t)))
+	defer ctxCancel()
+
+	watcher := NewMetadataWatcher(ctx)
+	handler := &reportingHandler{name: "reporting", err: errors.New("test error")}
+
+	if _, err := watcher.Trigger("reporting"); !errors.Is(err, ErrUnknownHandler) {
+		t.Errorf("Trigger() error = %v, want %v", err, ErrUnknownHandler)
+	}
+
+	s := watcher.state(handler)
+	if _, err := watcher.Trigger("reporting"); !errors.Is(err, ErrNoData) {
+		t.Errorf("Trigger() error = %v, want %v", err, ErrNoData)
+	}
+
+	watcher.recordPoll(s, []byte("asdf"), nil)
+	watcher.handle(ctx, handler, []byte("asdf"))
+	// skipped run must keep previous result
+	watcher.handle(ctx, handler, []byte("asdf"))
+
+	st := watcher.Status()
+	if len(st) != 1 || st[0].Runs != 2 || st[0].LastResult == nil || st[0].LastResult.Error != "test error" {
+		t.Errorf("Status() got = %+v", st)
+	}
+
+	r, err := watcher.Trigger("reporting")
+	if err != nil {
+		t.Errorf("Trigger() error = %v", err)
+	}
+	if r.Skipped || r.Success || r.Response != "asdf" {
+		t.Errorf("Trigger() got = %+v", r)
+	}
+}
+
+func TestEventWatcher_Stalled(t *testing.T) {
+	ctx, ctxCancel := context.WithCancel(logger.NewContext(context.Background(), zaptest.NewLogger(t)))
+	defer ctxCancel()
+
+	watcher := NewMetadataWatcher(ctx)
+	s := watcher.state(&reportingHandler{name: "reporting"})
+
+	if stalled := watcher.Stalled(time.Minute); len(stalled) != 0 {
+		t.Errorf("Stalled() got = %v, want none", stalled)
+	}
+
+	s.status.LastProgress = time.Now().Add(-2 * time.Minute)
+	if stalled := watcher.Stalled(time.Minute); len(stalled) != 1 || stalled[0] != "reporting" {
+		t.Errorf("Stalled() got = %v, want [reporting]", stalled)
+	}
+
+	watcher.recordPoll(s, nil, errors.New("test error"))
+	if stalled := watcher.Stalled(time.Minute); len(stalled) != 0 {
+		t.Errorf("Stalled() got = %v, want none after poll", stalled)
+	}
+}
+
+type blockingHandler struct {
+	started  chan struct{}
+	release  chan struct{}
+	canceled bool
+}
+
+func (h *blockingHandler) Handle(ctx context.Context, _ []byte) {
+	close(h.started)
+	select {
+	case <-h.release:
+	case <-ctx.Done():
+		h.canceled = true
+	}
+}
+
+func (h *blockingHandler) String() string {
+	return "blocking"
+}
+
+func TestEventWatcher_Drain(t *testing.T) {
+	for _, tt := range []struct {
+		name            string
+		release         bool
+		wantInterrupted bool
+	}{
+		{name: "handler finishes after stop", release: true},
+		{name: "handler interrupted", release: false, wantInterrupted: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, ctxCancel := context.WithCancel(logger.NewContext(context.Background(), zaptest.NewLogger(t)))
+			defer ctxCancel()
+
+			watcher := NewMetadataWatcher(ctx)
+			h := &blockingHandler{started: make(chan struct{}), release: make(chan struct{})}
+
+			done := make(chan struct{})
+			go func() {
+				watcher.handle(ctx, h, nil)
+				close(done)
+			}()
+			<-h.started
+
+			// stop must not cancel handler in flight
+			ctxCancel()
+			if tt.release {
+				close(h.release)
+			}
+
+			interrupted := watcher.Drain(300 * time.Millisecond)
+			<-done
+
+			if (len(interrupted) > 0) != tt.wantInterrupted || h.canceled != tt.wantInterrupted {
+				t.Errorf("Drain() got = %v, handler canceled = %v", interrupted, h.canceled)
+			}
+
+			// no new handler runs after stop
+			if r := watcher.handle(ctx, h, nil); !r.Skipped {
+				t.Errorf("handle() after stop got = %+v, want skipped", r)
+			}
+		})
+	}
+}
+
+func TestEventWatcher_HandleAfterDrain(t *testing.T) {
+	ctx := logger.NewContext(context.Background(), zaptest.NewLogger(t))
+
+	// handler, which passed cancellation check racing with stop, must not start once Drain returned
+	watcher := NewMetadataWatcher(ctx)
+	if interrupted := watcher.Drain(time.Second); len(interrupted) != 0 {
+		t.Fatalf("Drain() got = %v, want none", interrupted)
+	}
+
+	h := &blockingHandler{started: make(chan struct{}), release: make(chan struct{})}
+	if r := watcher.handle(ctx, h, nil); !r.Skipped {
+		t.Errorf("handle() after Drain got = %+v, want skipped", r)
+	}
+}
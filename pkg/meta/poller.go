@@ -22,6 +22,7 @@ type HTTPClient interface {
 type Poller struct {
 	url        string
 	lastETag   string
+	once       bool
 	HTTPClient HTTPClient
 }
 
@@ -34,6 +35,14 @@ func NewPoller(url string) *Poller {
 	}
 }
 
+// NewOneShotPoller creates instance of Poller, which returns current data immediately, without waiting for change.
+func NewOneShotPoller(url string) *Poller {
+	p := NewPoller(url)
+	p.once = true
+
+	return p
+}
+
 const retryTimeout = 60 * time.Second
 
 const retryMinInterval = 1 * time.Second
@@ -51,6 +60,10 @@ func (p *Poller) Get(ctx context.Context) (bs []byte, err error) {
 		}
 
 		bs, opErr = p.get(ctx)
+		// there is no sense to wait for missing attribute, if we are not going to wait for change
+		if p.once && errors.Is(opErr, ErrNotFound) {
+			return backoff.Permanent(opErr)
+		}
 
 		return opErr
 	}
@@ -60,7 +73,10 @@ func (p *Poller) Get(ctx context.Context) (bs []byte, err error) {
 	return
 }
 
-var ErrStatusNotOK = errors.New("received non 200 response")
+var (
+	ErrStatusNotOK = errors.New("received non 200 response")
+	ErrNotFound    = errors.New("metadata attribute not found")
+)
 
 const pollerTimeout = 60 * time.Second
 
@@ -69,7 +85,7 @@ func (p *Poller) get(ctx context.Context) ([]byte, error) {
 		zap.String("url", p.url),
 		zap.String("etag", p.lastETag)))
 
-	req, err := createRequest(ctx, p.url, pollerTimeout, p.lastETag)
+	req, err := createRequest(ctx, p.url, pollerTimeout, p.lastETag, !p.once)
 	if err != nil {
 		return nil, err
 	}
@@ -84,6 +100,9 @@ func (p *Poller) get(ctx context.Context) ([]byte, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		logger.InfoCtx(ctx, err, "context close has failed", zap.Int("statusCode", resp.StatusCode))
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w, code: %v", ErrNotFound, resp.StatusCode)
+		}
 
 		return nil, fmt.Errorf("%w, code: %v", ErrStatusNotOK, resp.StatusCode)
 	}
@@ -92,10 +111,12 @@ func (p *Poller) get(ctx context.Context) ([]byte, error) {
 	return io.ReadAll(resp.Body)
 }
 
-func createRequest(ctx context.Context, url string, timeout time.Duration, lastETag string) (*http.Request, error) {
-	req, err := http.NewRequest(http.MethodGet,
-		url+"?wait_for_change=true&timeout_sec="+fmt.Sprint(timeout.Seconds())+"&last_etag="+lastETag,
-		nil)
+func createRequest(ctx context.Context, url string, timeout time.Duration, lastETag string, wait bool) (*http.Request, error) {
+	if wait {
+		url += "?wait_for_change=true&timeout_sec=" + fmt.Sprint(timeout.Seconds()) + "&last_etag=" + lastETag
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		logger.ErrorCtx(ctx, err, "create request")
 		return nil, err
@@ -68,3 +68,33 @@ func TestPoller_Get(t *testing.T) {
 	server.Close()
 	textCtxCancel()
 }
+
+func TestPoller_GetOnce(t *testing.T) {
+	at := assert.New(t)
+	ctx := logger.NewContext(context.Background(), zaptest.NewLogger(t))
+
+	// test ok, without waiting for change
+	mux := http.ServeMux{}
+	mux.HandleFunc("/asd", func(writer http.ResponseWriter, request *http.Request) {
+		at.Empty(request.URL.RawQuery)
+		_, _ = writer.Write([]byte("OK"))
+	})
+	server := httptest.NewServer(&mux)
+
+	data, err := NewOneShotPoller(server.URL + "/asd").Get(ctx)
+	at.Equal([]byte("OK"), data)
+	at.NoError(err)
+
+	// test missing attribute is not retried
+	var callTimes int
+	mux.HandleFunc("/missing", func(writer http.ResponseWriter, request *http.Request) {
+		callTimes++
+		writer.WriteHeader(http.StatusNotFound)
+	})
+
+	_, err = NewOneShotPoller(server.URL + "/missing").Get(ctx)
+	at.ErrorIs(err, ErrNotFound)
+	at.Equal(1, callTimes)
+
+	server.Close()
+}
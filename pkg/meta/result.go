@@ -0,0 +1,91 @@
+package meta
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Result is outcome of single handler run, reported by handler itself with SetResult.
+type Result struct {
+	Success  bool        `json:"success"`
+	Error    string      `json:"error,omitempty"`
+	Response interface{} `json:"response,omitempty"`
+	// Skipped is set if handler reported nothing during run (e.g. data was already processed).
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// HandlerStatus describes state of single metadata watch and its handler.
+type HandlerStatus struct {
+	Name          string    `json:"name"`
+	URL           string    `json:"url"`
+	LastPoll      time.Time `json:"lastPoll,omitempty"`
+	LastPollError string    `json:"lastPollError,omitempty"`
+	LastRun       time.Time `json:"lastRun,omitempty"`
+	LastDuration  string    `json:"lastDuration,omitempty"`
+	Runs          uint64    `json:"runs"`
+	LastResult    *Result   `json:"lastResult,omitempty"`
+	// LastProgress is time watch loop last time moved forward: received metadata or finished handler run.
+	LastProgress time.Time `json:"lastProgress"`
+}
+
+type key int
+
+const (
+	resultKey key = iota
+	forceKey
+)
+
+// resultSlot is stored in context, so handler could pass back its result.
+type resultSlot struct {
+	m   sync.Mutex
+	r   Result
+	set bool
+}
+
+// NewResultContext creates context in which handler could report its result with SetResult.
+func NewResultContext(ctx context.Context) (context.Context, func() Result) {
+	s := &resultSlot{}
+
+	return context.WithValue(ctx, resultKey, s), func() Result {
+		s.m.Lock()
+		defer s.m.Unlock()
+
+		r := s.r
+		r.Skipped = !s.set
+
+		return r
+	}
+}
+
+// SetResult stores handler response and error in context, if caller is interested in them.
+func SetResult(ctx context.Context, response interface{}, err error) {
+	s, ok := ctx.Value(resultKey).(*resultSlot)
+	if !ok {
+		return
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.r = Result{
+		Success:  err == nil,
+		Response: response,
+	}
+	s.set = true
+	if err != nil {
+		s.r.Error = err.Error()
+	}
+}
+
+// WithForce marks context, so handler must process data even if it was already processed before.
+func WithForce(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceKey, true)
+}
+
+// IsForced tells if handler must skip its 'already processed' checks.
+func IsForced(ctx context.Context) bool {
+	v, ok := ctx.Value(forceKey).(bool)
+
+	return ok && v
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"errors"
 	mathRand "math/rand"
+	"strings"
 )
 
 const (
@@ -14,7 +15,10 @@ const (
 	defaultSymbols      = `~!@#$%^&*_-+=|(){}[]:;<>,.?`
 )
 
-var ErrLengthTooShort = errors.New("number of symbols and digits is lover than length")
+var (
+	ErrLengthTooShort = errors.New("number of symbols and digits is lover than length")
+	ErrEmptyPool      = errors.New("character pool is empty")
+)
 
 type Generator struct {
 	lowerLetters string
@@ -54,6 +58,33 @@ func NewGenerator(lowerLetters, upperLetters, digits, symbols string) GeneratorI
 	return g
 }
 
+// NewGeneratorExcluding is same as NewGenerator, but excluded characters are removed from all pools.
+func NewGeneratorExcluding(lowerLetters, upperLetters, digits, symbols, excluded string) GeneratorInterface {
+	g := NewGenerator(lowerLetters, upperLetters, digits, symbols).(*Generator)
+
+	without := func(pool string) string {
+		return strings.Map(func(r rune) rune {
+			if strings.ContainsRune(excluded, r) {
+				return -1
+			}
+
+			return r
+		}, pool)
+	}
+
+	g.lowerLetters = without(g.lowerLetters)
+	g.upperLetters = without(g.upperLetters)
+	g.digits = without(g.digits)
+	g.symbols = without(g.symbols)
+
+	return g
+}
+
+// PoolSizes returns number of characters left in each pool, e.g. after exclusions.
+func (g *Generator) PoolSizes() (lower, upper, digits, symbols int) {
+	return len(g.lowerLetters), len(g.upperLetters), len(g.digits), len(g.symbols)
+}
+
 func (g *Generator) Generate(length, numDigits, numSymbols uint, noUpper bool) (pwd string, err error) {
 	letters := g.lowerLetters
 	if !noUpper {
@@ -65,6 +96,14 @@ func (g *Generator) Generate(length, numDigits, numSymbols uint, noUpper bool) (
 		return
 	}
 
+	// pools could be emptied by exclusions
+	if (numDigits > 0 && g.digits == "") ||
+		(numSymbols > 0 && g.symbols == "") ||
+		(length > numDigits+numSymbols && letters == "") {
+		err = ErrEmptyPool
+		return
+	}
+
 	// digits
 	for i := uint(0); i < numDigits; i++ {
 		pwd += getRandom(g.digits)
@@ -176,3 +176,44 @@ func TestGenerator(t *testing.T) {
 		}
 	})
 }
+
+func TestNewGeneratorExcluding(t *testing.T) {
+	t.Run("excluded characters not used", func(t *testing.T) {
+		const excluded = `0Oo1lI|~!@#$%^&*`
+		g := NewGeneratorExcluding("", "", "", "", excluded)
+
+		for i := 0; i < 100; i++ {
+			pwd, err := g.Generate(30, 5, 5, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.ContainsAny(pwd, excluded) {
+				t.Errorf("password %v contains excluded characters %v", pwd, excluded)
+			}
+		}
+	})
+
+	t.Run("catch ErrEmptyPool", func(t *testing.T) {
+		g := NewGeneratorExcluding("", "", "", "", defaultDigits)
+
+		_, err := g.Generate(15, 3, 2, false)
+		if !errors.Is(err, ErrEmptyPool) {
+			t.Error(err)
+		}
+
+		// pool is not used, so it could be empty
+		_, err = g.Generate(15, 0, 2, false)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("pool sizes", func(t *testing.T) {
+		g := NewGeneratorExcluding("", "", "", "", "abc012").(*Generator)
+
+		lower, upper, digits, symbols := g.PoolSizes()
+		if lower != 23 || upper != 26 || digits != 7 || symbols != len(defaultSymbols) {
+			t.Errorf("PoolSizes() = %v, %v, %v, %v", lower, upper, digits, symbols)
+		}
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/tarm/serial"
@@ -12,7 +13,19 @@ import (
 
 var once sync.Once
 
-var port io.WriteCloser
+// port is set once by Init and read by writers and Status concurrently, pm guards it.
+var (
+	pm   sync.RWMutex
+	port io.WriteCloser
+)
+
+// currentPort returns opened port, or nil if Init has not succeeded.
+func currentPort() io.WriteCloser {
+	pm.RLock()
+	defer pm.RUnlock()
+
+	return port
+}
 
 const portBaud = 115200
 
@@ -29,7 +42,9 @@ func Init(portName string) (err error) {
 		var b backoff.ConstantBackOff
 
 		if err = backoff.Retry(tryOpen, backoff.WithMaxRetries(&b, maxRetries)); err == nil {
+			pm.Lock()
 			port = p
+			pm.Unlock()
 		}
 	})
 
@@ -38,11 +53,19 @@ func Init(portName string) (err error) {
 
 var ErrNotInitialized = errors.New("accessed methods of uninitialized port")
 
-type blockingPort struct {
-	wl sync.Mutex
+type blockingPort struct{}
+
+// wl serializes writes of all writers, as they share single port.
+var wl sync.Mutex
+
+// Flush waits for writes in progress to finish. Written data is drained by kernel on port close.
+func Flush() {
+	wl.Lock()
+	defer wl.Unlock()
 }
 
 func (p *blockingPort) Close() error {
+	port := currentPort()
 	if port == nil {
 		return ErrNotInitialized
 	}
@@ -51,18 +74,22 @@ func (p *blockingPort) Close() error {
 }
 
 func (p *blockingPort) Write(bs []byte) (int, error) {
+	port := currentPort()
 	if port == nil {
 		return 0, ErrNotInitialized
 	}
 
-	p.wl.Lock()
-	defer p.wl.Unlock()
+	wl.Lock()
+	defer wl.Unlock()
 
-	return port.Write(bs)
+	n, err := port.Write(bs)
+	recordWrite(err)
+
+	return n, err
 }
 
 func (p *blockingPort) WriteJSON(j interface{}) error {
-	if port == nil {
+	if currentPort() == nil {
 		return ErrNotInitialized
 	}
 
@@ -83,3 +110,40 @@ type BlockingWriter interface {
 func NewBlockingWriter() BlockingWriter {
 	return new(blockingPort)
 }
+
+// Health describes state of serial port as seen by writers.
+type Health struct {
+	Initialized bool      `json:"initialized"`
+	Writes      uint64    `json:"writes"`
+	Errors      uint64    `json:"errors"`
+	LastWrite   time.Time `json:"lastWrite,omitempty"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+var (
+	hm     sync.Mutex
+	health Health
+)
+
+func recordWrite(err error) {
+	hm.Lock()
+	defer hm.Unlock()
+
+	health.Writes++
+	health.LastWrite = time.Now()
+	if err != nil {
+		health.Errors++
+		health.LastError = err.Error()
+	}
+}
+
+// Status returns snapshot of serial port health.
+func Status() Health {
+	hm.Lock()
+	defer hm.Unlock()
+
+	h := health
+	h.Initialized = currentPort() != nil
+
+	return h
+}